@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// This file has the batch mode, for converting many files in a single run
+
+// The extensions we look for when given a directory, and strip when building output names
+var inputExtensions = []string{".xml", ".zip"}
+var compressedExtensions = []string{".gz", ".gzip", ".bz2", ".zst", ".zstd"}
+
+// A single file to convert, and how it went
+type batchJob struct {
+	inFileName  string
+	outFileName string
+	err         error
+}
+
+// Convert every file the arguments refer to, and return the exit code
+func runBatch(args []string, opts options) int {
+	// Every file gets its own output, so a single one would be silently ignored
+	if flagGiven("output") {
+		log.Printf("-output cannot be used when converting several files, use -outputPattern instead")
+		return 2
+	}
+
+	if opts.inferOutFileName != "" {
+		log.Printf("-inferOutput cannot be used when converting several files")
+		return 2
+	}
+
+	if opts.stateFileName != "" {
+		log.Printf("-state cannot be used when converting several files")
+		return 2
+	}
+
+	if opts.previousFileName != "" {
+		log.Printf("-previous cannot be used when converting several files")
+		return 2
+	}
+
+	jobs := expandArgs(args, opts.outputPattern)
+
+	if len(jobs) == 0 {
+		log.Printf("No input files found")
+		return 1
+	}
+
+	workers := opts.parallel
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan *batchJob)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for job := range queue {
+				job.err = convertFile(job.inFileName, job.outFileName, opts)
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		if job.err == nil {
+			queue <- job
+		}
+	}
+
+	close(queue)
+	wg.Wait()
+
+	return printSummary(jobs)
+}
+
+// Turn the arguments into jobs. Anything that can't be converted gets a job that has already failed, so it shows up in the summary
+func expandArgs(args []string, pattern string) []*batchJob {
+	out := []*batchJob{}
+	seenInputs := map[string]bool{}
+	seenOutputs := map[string]string{}
+
+	for _, arg := range args {
+		fileNames, err := expandArg(arg)
+
+		if err != nil {
+			out = append(out, &batchJob{inFileName: arg, err: err})
+			continue
+		}
+
+		for _, fileName := range fileNames {
+			if seenInputs[fileName] {
+				continue
+			}
+
+			seenInputs[fileName] = true
+
+			job := &batchJob{
+				inFileName:  fileName,
+				outFileName: outputFileName(fileName, pattern),
+			}
+
+			// Two inputs writing the same output would clobber each other, so only the first one gets to run
+			if other, found := seenOutputs[job.outFileName]; found {
+				job.err = fmt.Errorf("Output '%s' is already being written for '%s'", job.outFileName, other)
+			} else {
+				seenOutputs[job.outFileName] = fileName
+			}
+
+			out = append(out, job)
+		}
+	}
+
+	return out
+}
+
+// An argument can be a directory, a glob, or a plain file
+func expandArg(arg string) ([]string, error) {
+	if stat, err := os.Stat(arg); err == nil && stat.IsDir() {
+		return listDirectory(arg)
+	}
+
+	matches, err := filepath.Glob(arg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pattern: %v", err)
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("No such file")
+	}
+
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+// Get the convertible files in a directory, without descending into subdirectories
+func listDirectory(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	out := []string{}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		if _, ext := splitExtensions(entry.Name()); ext != "" {
+			out = append(out, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return out, nil
+}
+
+// Split a file name into the name and the input extensions, like "export" and ".xml.gz".
+// The extension is empty if the file doesn't look like something we can convert
+func splitExtensions(fileName string) (string, string) {
+	name := fileName
+	ext := ""
+
+	for _, compressed := range compressedExtensions {
+		if strings.HasSuffix(strings.ToLower(name), compressed) {
+			ext = name[len(name)-len(compressed):]
+			name = name[:len(name)-len(compressed)]
+			break
+		}
+	}
+
+	for _, input := range inputExtensions {
+		if strings.HasSuffix(strings.ToLower(name), input) {
+			return name[:len(name)-len(input)], name[len(name)-len(input):] + ext
+		}
+	}
+
+	return fileName, ""
+}
+
+// Fill in the output pattern for a given input file
+func outputFileName(inFileName, pattern string) string {
+	base := filepath.Base(inFileName)
+	name, _ := splitExtensions(base)
+
+	replacer := strings.NewReplacer(
+		"{dir}", filepath.Dir(inFileName),
+		"{name}", name,
+		"{base}", base,
+	)
+
+	return filepath.Clean(replacer.Replace(pattern))
+}
+
+// Print how each file went, and get the exit code
+func printSummary(jobs []*batchJob) int {
+	failures := 0
+
+	for _, job := range jobs {
+		if job.err != nil {
+			failures++
+			fmt.Fprintf(os.Stderr, "FAIL %s: %v\n", job.inFileName, job.err)
+		} else {
+			fmt.Fprintf(os.Stderr, "OK   %s -> %s\n", job.inFileName, job.outFileName)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%d converted, %d failed\n", len(jobs)-failures, failures)
+
+	if failures > 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+	"github.com/hovercross/fmpxml-to-json/pkg/xmlreader"
+)
+
+// Convert a single input file, or "-" for STDIN, into a single output file, or "-" for STDOUT.
+// If the conversion fails, no partial output file is left behind
+func convertFile(inFileName, outFileName string, opts options) error {
+	var reader io.ReadCloser = os.Stdin
+
+	if inFileName != "-" {
+		var err error
+
+		reader, err = os.Open(inFileName)
+
+		if err != nil {
+			return fmt.Errorf("Unable to open '%s' for reading: %s", inFileName, err)
+		}
+	}
+
+	defer reader.Close()
+
+	inputs, err := xmlreader.OpenInputs(reader, inFileName)
+
+	if err != nil {
+		return fmt.Errorf("Unable to read input: %v", err)
+	}
+
+	writer, err := openOutput(outFileName, opts.compress, opts.compressLevel)
+
+	if err != nil {
+		closeInputs(inputs)
+
+		return err
+	}
+
+	state, err := convertInputs(inputs, writer, opts)
+
+	if closeErr := writer.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("Unable to close file: %v", closeErr)
+	}
+
+	// The state only moves forward once the output is safely written, so a failed run gets repeated in full next time
+	if err == nil && state != nil {
+		err = saveState(opts.stateFileName, state)
+	}
+
+	if err != nil && outFileName != "-" {
+		os.Remove(outFileName)
+	}
+
+	return err
+}
+
+// Convert each input and write it to the output. A zip archive can hold several exports, which are written as an array of documents
+// for JSON, and one after another otherwise. If there is a state file, the new state is returned
+func convertInputs(inputs []xmlreader.Input, writer io.Writer, opts options) (fmpxmlresult.State, error) {
+	defer closeInputs(inputs)
+
+	if opts.stateFileName != "" && len(inputs) > 1 {
+		return nil, fmt.Errorf("-state cannot be used with an archive of %d exports", len(inputs))
+	}
+
+	if opts.previousFileName != "" && len(inputs) > 1 {
+		return nil, fmt.Errorf("-previous cannot be used with an archive of %d exports", len(inputs))
+	}
+
+	rw := newResultWriter(writer, opts)
+
+	if len(inputs) > 1 {
+		if err := rw.beginArray(); err != nil {
+			return nil, fmt.Errorf("Could not write JSON data: %v", err)
+		}
+	}
+
+	var state fmpxmlresult.State
+
+	for _, input := range inputs {
+		if len(inputs) > 1 {
+			log.Printf("Converting '%s'", input.Name)
+		}
+
+		parsed, err := readInput(input, input.Name, opts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := convert(parsed, opts); err != nil {
+			return nil, err
+		}
+
+		if state, err = applyState(parsed, opts.stateFileName); err != nil {
+			return nil, err
+		}
+
+		if err := applyPrevious(parsed, opts); err != nil {
+			return nil, err
+		}
+
+		if err := rw.writeResult(parsed); err != nil {
+			return nil, fmt.Errorf("Could not write JSON data: %v", err)
+		}
+	}
+
+	if err := rw.endArray(); err != nil {
+		return nil, fmt.Errorf("Could not write JSON data: %v", err)
+	}
+
+	return state, nil
+}
+
+func closeInputs(inputs []xmlreader.Input) {
+	for _, input := range inputs {
+		input.Close()
+	}
+}
+
+// Read and parse a single input, applying the encoding and sanitizing options
+func readInput(reader io.Reader, name string, opts options) (*fmpxmlresult.FMPXMLResult, error) {
+	// Everything after this point gets UTF-8, no matter what the input was
+	input, err := xmlreader.NewUTF8Reader(reader, opts.encoding)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read input: %v", err)
+	}
+
+	var sanitizer *xmlreader.Sanitizer
+
+	if opts.sanitize != "" || opts.sanitizeNewlines {
+		mode, err := getSanitizeMode(opts.sanitize)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sanitizer = xmlreader.NewSanitizer(input, xmlreader.SanitizeOptions{
+			Mode:                 mode,
+			VerticalTabAsNewline: opts.sanitizeNewlines,
+		})
+
+		input = sanitizer
+	}
+
+	parsed, err := xmlreader.ReadXML(input)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read input XML: %v", err)
+	}
+
+	if sanitizer != nil && sanitizer.Repairs() > 0 {
+		log.Printf("Repaired %d invalid XML characters in '%s'", sanitizer.Repairs(), name)
+	}
+
+	return parsed, nil
+}
+
+// Populate the records of a parsed file, according to the options
+func convert(parsed *fmpxmlresult.FMPXMLResult, opts options) error {
+	if err := configure(parsed, opts); err != nil {
+		return err
+	}
+
+	if err := parsed.PopulateRecords(); err != nil {
+		return fmt.Errorf("Unable to convert record format: %v", err)
+	}
+
+	for _, warning := range parsed.Warnings {
+		log.Printf("%s, the last one wins", warning)
+	}
+
+	return nil
+}
+
+// Set the conversion options on a parsed file, without converting any rows yet
+func configure(parsed *fmpxmlresult.FMPXMLResult, opts options) error {
+	// These must be populated before calling PopulateRecords
+	parsed.RecordIDField = opts.recordIDField
+	parsed.ModIDField = opts.modIDField
+	parsed.IDFieldsPosition = opts.idPosition
+	parsed.KeyCollisions = opts.collisions
+	parsed.Naming = opts.naming
+	parsed.Projection = opts.projection
+	parsed.Filter = opts.filter
+	parsed.Derived = opts.derived
+
+	if opts.renamesFileName != "" {
+		renames, err := readRenames(opts.renamesFileName)
+
+		if err != nil {
+			return err
+		}
+
+		parsed.Naming.Renames = renames
+	}
+
+	if opts.containers || opts.containerDir != "" {
+		parsed.Containers = &fmpxmlresult.ContainerOptions{
+			BaseDir:     opts.containerDir,
+			InlineLimit: opts.containerInline,
+		}
+	}
+
+	if opts.typesFileName != "" {
+		mapping, err := readTypeMapping(opts.typesFileName)
+
+		if err != nil {
+			return err
+		}
+
+		parsed.TypeMapping = mapping
+	}
+
+	if opts.infer {
+		if err := inferTypes(parsed, opts.inferRows, opts.inferConfidence, opts.inferOutFileName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Drop the records that haven't changed since the state file was written, and add tombstones for the deleted ones.
+// The new state is returned, to be saved once the output is written. Without a state file, nothing happens
+func applyState(parsed *fmpxmlresult.FMPXMLResult, stateFileName string) (fmpxmlresult.State, error) {
+	if stateFileName == "" {
+		return nil, nil
+	}
+
+	previous, err := fmpxmlresult.ReadStateFile(stateFileName)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read state file '%s': %v", stateFileName, err)
+	}
+
+	// This has to come before filtering, since the state covers every record, changed or not
+	current := parsed.State()
+
+	if err := parsed.FilterIncremental(previous); err != nil {
+		return nil, fmt.Errorf("Unable to filter records: %v", err)
+	}
+
+	return current, nil
+}
+
+// Add a tombstone for every record in the previous snapshot that is gone from the export. Without a snapshot, nothing happens
+func applyPrevious(parsed *fmpxmlresult.FMPXMLResult, opts options) error {
+	if opts.previousFileName == "" {
+		return nil
+	}
+
+	previous, err := readPrevious(opts.previousFileName, opts)
+
+	if err != nil {
+		return fmt.Errorf("Unable to read previous snapshot '%s': %v", opts.previousFileName, err)
+	}
+
+	tombstones := parsed.Tombstones(previous)
+
+	if len(tombstones) > 0 {
+		log.Printf("%d records deleted since the previous snapshot", len(tombstones))
+	}
+
+	parsed.Records = append(parsed.Records, tombstones...)
+
+	return nil
+}
+
+// The previous snapshot can be an export, in any form that can be converted, converted JSON or NDJSON output,
+// a state file, or a plain list of record IDs. Unlike a state file for -state, the snapshot has to exist
+func readPrevious(fileName string, opts options) (fmpxmlresult.State, error) {
+	if _, ext := splitExtensions(fileName); ext != "" {
+		exports, err := readFile(fileName, opts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		out := fmpxmlresult.State{}
+
+		for _, export := range exports {
+			for recordID, modID := range export.State() {
+				out[recordID] = modID
+			}
+		}
+
+		return out, nil
+	}
+
+	f, err := os.Open(fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	if isJSONFile(fileName) {
+		// Converted output may have been compressed on the way out
+		r, err := xmlreader.Decompress(f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer r.Close()
+
+		return fmpxmlresult.ReadSnapshot(r, opts.recordIDField, opts.modIDField)
+	}
+
+	return fmpxmlresult.ReadIDList(f)
+}
+
+// Whether a file name is JSON or NDJSON, possibly compressed
+func isJSONFile(fileName string) bool {
+	name := strings.ToLower(fileName)
+
+	for _, compressed := range compressedExtensions {
+		if strings.HasSuffix(name, compressed) {
+			name = strings.TrimSuffix(name, compressed)
+			break
+		}
+	}
+
+	ext := filepath.Ext(name)
+
+	return ext == ".json" || ext == ".ndjson"
+}
+
+func saveState(stateFileName string, state fmpxmlresult.State) error {
+	if err := fmpxmlresult.WriteStateFile(stateFileName, state); err != nil {
+		return fmt.Errorf("Unable to write state file '%s': %v", stateFileName, err)
+	}
+
+	return nil
+}
+
+func readTypeMapping(fileName string) (fmpxmlresult.TypeMapping, error) {
+	f, err := os.Open(fileName)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open '%s' for reading: %s", fileName, err)
+	}
+
+	defer f.Close()
+
+	read := fmpxmlresult.ReadTypeMapping
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		read = fmpxmlresult.ReadYAMLTypeMapping
+	}
+
+	mapping, err := read(f)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read type mapping: %v", err)
+	}
+
+	return mapping, nil
+}
+
+func readRenames(fileName string) (map[string]string, error) {
+	f, err := os.Open(fileName)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open '%s' for reading: %s", fileName, err)
+	}
+
+	defer f.Close()
+
+	return fmpxmlresult.ReadRenames(f)
+}
+
+// Infer the types of the TEXT fields, report them, and add them to any type mapping we already have
+func inferTypes(parsed *fmpxmlresult.FMPXMLResult, maxRows int, minConfidence float64, outFileName string) error {
+	inferences := parsed.InferTypes(maxRows)
+
+	for _, inference := range inferences {
+		log.Printf("Inferred '%s' as %s (%.1f%% of %d values)", inference.Name, inference.Kind, inference.Confidence*100, inference.Values)
+	}
+
+	inferred := fmpxmlresult.InferredTypeMapping(inferences, minConfidence)
+
+	if outFileName != "" {
+		f, err := os.Create(outFileName)
+
+		if err != nil {
+			return fmt.Errorf("Unable to open '%s' for writing: %s", outFileName, err)
+		}
+
+		if err := fmpxmlresult.WriteTypeMapping(f, inferred); err != nil {
+			f.Close()
+
+			return fmt.Errorf("Could not write inferred types: %v", err)
+		}
+
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("Unable to close file: %v", err)
+		}
+	}
+
+	if parsed.TypeMapping == nil {
+		parsed.TypeMapping = fmpxmlresult.TypeMapping{}
+	}
+
+	for name, mapping := range inferred {
+		parsed.TypeMapping[name] = mapping
+	}
+
+	return nil
+}
+
+func getSanitizeMode(mode string) (xmlreader.SanitizeMode, error) {
+	switch mode {
+	case "replace":
+		return xmlreader.SanitizeReplace, nil
+	case "drop", "":
+		return xmlreader.SanitizeDrop, nil
+	}
+
+	return 0, fmt.Errorf("Unknown sanitize mode '%s'", mode)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+// Compare the old export with the input, and write the changes
+func runDiff(oldFileName string, opts options) error {
+	before, err := readSingleFile(oldFileName, opts, convert)
+
+	if err != nil {
+		return err
+	}
+
+	after, err := readSingleFile(opts.inFileName, opts, convert)
+
+	if err != nil {
+		return err
+	}
+
+	changes, err := fmpxmlresult.Diff(before, after, fmpxmlresult.DiffOptions{
+		KeyField:         opts.diffKey,
+		IncludeUnchanged: opts.diffUnchanged,
+	})
+
+	if err != nil {
+		return fmt.Errorf("Unable to compare exports: %v", err)
+	}
+
+	writer, err := openOutput(opts.outFileName, opts.compress, opts.compressLevel)
+
+	if err != nil {
+		return err
+	}
+
+	err = newResultWriter(writer, opts).writeChanges(changes)
+
+	if closeErr := writer.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("Unable to close file: %v", closeErr)
+	}
+
+	if err != nil && opts.outFileName != "-" {
+		os.Remove(opts.outFileName)
+	}
+
+	return err
+}
+
+// Read a file that must hold exactly one export, and get it ready with prepare, which is either convert or configure
+func readSingleFile(fileName string, opts options, prepare func(*fmpxmlresult.FMPXMLResult, options) error) (*fmpxmlresult.FMPXMLResult, error) {
+	parsed, err := readFile(fileName, opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	if len(parsed) != 1 {
+		return nil, fmt.Errorf("%s: expected one export, found %d", fileName, len(parsed))
+	}
+
+	if err := prepare(parsed[0], opts); err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	return parsed[0], nil
+}
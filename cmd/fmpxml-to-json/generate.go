@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/codegen"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+// This file has the generators, which describe the output for an export instead of converting it
+
+type generator func(w io.Writer, parsed *fmpxmlresult.FMPXMLResult, opts options) error
+
+var generators = map[string]generator{
+	"schema":     generateSchema,
+	"go":         generateGo,
+	"typescript": generateTypeScript,
+}
+
+// Read the input and write what the chosen generator makes of it
+func runGenerate(opts options) error {
+	generate, found := generators[opts.generate]
+
+	if !found {
+		return fmt.Errorf("Unknown generator '%s'", opts.generate)
+	}
+
+	// Only the metadata and the options are described, so the rows are never converted, and a bad value can't stop the generation
+	parsed, err := readSingleFile(opts.inFileName, opts, configure)
+
+	if err != nil {
+		return err
+	}
+
+	writer, err := openOutput(opts.outFileName, opts.compress, opts.compressLevel)
+
+	if err != nil {
+		return err
+	}
+
+	err = generate(writer, parsed, opts)
+
+	if closeErr := writer.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("Unable to close file: %v", closeErr)
+	}
+
+	if err != nil && opts.outFileName != "-" {
+		os.Remove(opts.outFileName)
+	}
+
+	return err
+}
+
+// Records can be tombstones when the export is incremental, or compared with a previous snapshot
+func hasTombstones(opts options) bool {
+	return opts.stateFileName != "" || opts.previousFileName != ""
+}
+
+// The schema is for a single line with NDJSON, or the whole document with JSON
+func generateSchema(w io.Writer, parsed *fmpxmlresult.FMPXMLResult, opts options) error {
+	schemaOpts := fmpxmlresult.SchemaOptions{
+		Full:       opts.full,
+		Tombstones: hasTombstones(opts),
+	}
+
+	var schema *fmpxmlresult.Schema
+	var err error
+
+	switch opts.format {
+	case "json":
+		schema, err = parsed.EnvelopeSchema(schemaOpts)
+	case "ndjson":
+		schema, err = parsed.RecordSchema(schemaOpts)
+	default:
+		return fmt.Errorf("There is no JSON Schema for %s output", opts.format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(schema)
+}
+
+func generateGo(w io.Writer, parsed *fmpxmlresult.FMPXMLResult, opts options) error {
+	return codegen.WriteGo(w, parsed, codegen.GoOptions{
+		Package:     opts.goPackage,
+		TypeName:    opts.typeName,
+		TimeStrings: opts.timeStrings,
+		Tombstones:  hasTombstones(opts),
+	})
+}
+
+func generateTypeScript(w io.Writer, parsed *fmpxmlresult.FMPXMLResult, opts options) error {
+	return codegen.WriteTypeScript(w, parsed, codegen.TypeScriptOptions{
+		TypeName:   opts.typeName,
+		Full:       opts.full,
+		Tombstones: hasTombstones(opts),
+	})
+}
@@ -1,90 +1,272 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
-	"io"
 	"log"
 	"os"
+	"runtime"
+	"strings"
 
-	"github.com/hovercross/fmpxml-to-json/pkg/xmlreader"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+	"github.com/hovercross/fmpxml-to-json/pkg/postgres"
 )
 
+// options holds everything that came in on the command line
+type options struct {
+	inFileName, outFileName   string
+	recordIDField, modIDField string
+	idPosition                string
+	collisions                string
+	renamesFileName           string
+	naming                    fmpxmlresult.NamingOptions // Built from the other key naming flags
+	projection                fmpxmlresult.Projection    // Built from -include, -exclude and -projectFull
+	filter                    string
+	derived                   []fmpxmlresult.DerivedField
+	typesFileName             string
+	full                      bool
+	infer                     bool
+	inferRows                 int
+	inferConfidence           float64
+	inferOutFileName          string
+	encoding                  string
+	sanitize                  string
+	sanitizeNewlines          bool
+	containers                bool
+	containerDir              string
+	containerInline           int64
+	compress                  string
+	compressLevel             int
+	outputPattern             string
+	parallel                  int
+	merge                     bool
+	dropDuplicates            bool
+	format                    string
+	diffFileName              string
+	diffKey                   string
+	diffUnchanged             bool
+	stateFileName             string
+	previousFileName          string
+	sqliteFileName            string
+	sqliteTable               string
+	pgTypes                   string
+	generate                  string
+	goPackage                 string
+	typeName                  string
+	timeStrings               bool
+	postgres                  postgres.Options // Built from the other -pg flags
+}
+
 func main() {
-	var inFileName, outFileName, recordIDField, modIDField string
-	var full bool
+	var opts options
 
-	flag.StringVar(&inFileName, "input", "-", "File to read from, or \"-\" for STDIN")
-	flag.StringVar(&outFileName, "output", "-", "File to write to, or \"-\" for STDOUT")
-	flag.StringVar(&recordIDField, "recordID", "", "Field name to write the record ID value to")
-	flag.StringVar(&modIDField, "modID", "", "Field name to write the modification ID value to")
-	flag.BoolVar(&full, "full", false, "Keep all the original data")
+	flag.StringVar(&opts.inFileName, "input", "-", "File to read from, or \"-\" for STDIN. May be compressed with gzip, bzip2 or zstd, or be a zip archive")
+	flag.StringVar(&opts.outFileName, "output", "-", "File to write to, or \"-\" for STDOUT")
+	flag.StringVar(&opts.outputPattern, "outputPattern", "{dir}/{name}.json", "Output file for each input when converting several files. {dir}, {name} and {base} are replaced with the input's directory, name without extensions, and full file name")
+	flag.IntVar(&opts.parallel, "parallel", runtime.NumCPU(), "Number of files to convert at once when converting several files")
+	flag.BoolVar(&opts.merge, "merge", false, "Merge the files given as arguments, which must be exports of the same table, into a single output")
+	flag.BoolVar(&opts.dropDuplicates, "dropDuplicates", false, "When merging, keep only one row for each record ID, preferring the highest modification ID")
+	flag.StringVar(&opts.format, "format", "json", "Output format: \"json\" for a single document, \"ndjson\" for one record or change per line, or \"postgres\" for a PostgreSQL script")
+	flag.StringVar(&opts.generate, "generate", "", "Instead of converting, describe the output for the input's fields: \"schema\" for a JSON Schema, \"go\" for a Go struct, or \"typescript\" for TypeScript declarations")
+	flag.StringVar(&opts.goPackage, "goPackage", "records", "Package name for the generated Go source")
+	flag.StringVar(&opts.typeName, "typeName", "", "Name of the generated record type. Defaults to the FileMaker database name")
+	flag.BoolVar(&opts.timeStrings, "timeStrings", false, "Use strings for dates and times in generated code, instead of time types")
+	flag.StringVar(&opts.diffFileName, "diff", "", "Compare this older export with the input, and write the changed records instead of converting")
+	flag.StringVar(&opts.diffKey, "diffKey", "", "Field to match records on when comparing, instead of the record ID")
+	flag.BoolVar(&opts.diffUnchanged, "diffUnchanged", false, "Include unchanged records when comparing")
+	flag.StringVar(&opts.stateFileName, "state", "", "State file of the last modification ID seen for each record. Only new and modified records are written, along with tombstones for deleted ones, and the state is updated")
+	flag.StringVar(&opts.previousFileName, "previous", "", "A previous export, state file, or list of record IDs. Records that are gone from the input are written as tombstones")
+	flag.StringVar(&opts.sqliteFileName, "sqlite", "", "Write the records into this SQLite database instead of a JSON file, updating it if it exists")
+	flag.StringVar(&opts.sqliteTable, "sqliteTable", "", "The table to write to in the SQLite database. Defaults to the FileMaker database name")
+	flag.StringVar(&opts.postgres.Table, "pgTable", "", "The table to create and load in the PostgreSQL script. Defaults to the FileMaker database name")
+	flag.StringVar(&opts.postgres.Quote, "pgQuote", postgres.QuoteAlways, "Identifier quoting in the PostgreSQL script: \"always\", or only when \"needed\"")
+	flag.StringVar(&opts.postgres.Repeats, "pgRepeats", postgres.RepeatsArray, "Column type for repeating fields in the PostgreSQL script: \"array\" or \"jsonb\"")
+	flag.StringVar(&opts.postgres.Data, "pgData", postgres.DataCopy, "How the PostgreSQL script loads data: \"copy\" for COPY FROM STDIN, or \"insert\" for upserting INSERT statements")
+	flag.IntVar(&opts.postgres.BatchSize, "pgBatch", postgres.DefaultBatchSize, "Rows per INSERT statement in the PostgreSQL script")
+	flag.StringVar(&opts.pgTypes, "pgTypes", "", "PostgreSQL column type overrides, like \"NUMBER=double precision,TIME=text\"")
+	flag.StringVar(&opts.compress, "compress", "", "Compress the output with \"gzip\", \"zstd\" or \"none\". Inferred from the output file extension if not given")
+	flag.IntVar(&opts.compressLevel, "compressLevel", 0, "Compression level: 1-9 for gzip, 1-22 for zstd, or 0 for the default")
+	flag.StringVar(&opts.recordIDField, "recordID", "", "Field name to write the record ID value to")
+	flag.StringVar(&opts.modIDField, "modID", "", "Field name to write the modification ID value to")
+	flag.StringVar(&opts.idPosition, "idPosition", fmpxmlresult.IDFieldsFirst, "Where the record and modification ID fields go in each record: \"first\" or \"last\"")
+	flag.StringVar(&opts.collisions, "collisions", fmpxmlresult.CollisionLastWins, "What to do when two fields would be written under the same key: let the \"last\" one win with a warning, fail with an \"error\", \"suffix\" them like Name_2, or \"qualify\" them with their table occurrence")
+	flag.StringVar(&opts.renamesFileName, "renames", "", "JSON file of record keys for particular fields, like {\"First Name\": \"first\"}")
+	flag.StringVar(&opts.naming.Case, "keyCase", "", "Change the case of record keys: \"snake\", \"camel\", \"kebab\" or \"lower\"")
+	flag.BoolVar(&opts.naming.StripTableOccurrence, "stripTableOccurrence", false, "Drop the table occurrence prefix from related field keys")
+	flag.BoolVar(&opts.naming.Identifiers, "identifierKeys", false, "Remove anything that isn't a letter, digit or underscore from record keys")
+	flag.Var((*patternList)(&opts.projection.Include), "include", "Only write fields matching this glob, or /regular expression/. May be given more than once")
+	flag.Var((*patternList)(&opts.projection.Exclude), "exclude", "Don't write fields matching this glob, or /regular expression/. May be given more than once")
+	flag.BoolVar(&opts.projection.Metadata, "projectFull", false, "Apply -include and -exclude to the metadata and result set kept by -full")
+	flag.StringVar(&opts.filter, "filter", "", "Only convert rows this expression is true for, like 'Status == \"Active\" && Modified >= date(\"2024-01-01\")'")
+	flag.Var((*derivedList)(&opts.derived), "derive", "Add a field computed from the others, like 'full_name=concat(First, \" \", Last)'. May be given more than once")
+	flag.BoolVar(&opts.full, "full", false, "Keep all the original data")
+	flag.StringVar(&opts.typesFileName, "types", "", "JSON or YAML file of per-field type overrides, chosen by the .yaml or .yml extension")
+	flag.BoolVar(&opts.infer, "infer", false, "Infer types for TEXT fields that have no type override")
+	flag.IntVar(&opts.inferRows, "inferRows", 0, "Number of rows to examine when inferring types, or 0 for all of them")
+	flag.Float64Var(&opts.inferConfidence, "inferConfidence", 1, "Minimum fraction of values that must match an inferred type before it is used")
+	flag.StringVar(&opts.inferOutFileName, "inferOutput", "", "File to write the inferred types to, in the -types format")
+	flag.StringVar(&opts.encoding, "encoding", "", "Character encoding of the input, overriding any byte order mark or XML declaration")
+	flag.StringVar(&opts.sanitize, "sanitize", "", "Repair characters that are invalid in XML: \"replace\" with U+FFFD or \"drop\" them")
+	flag.BoolVar(&opts.sanitizeNewlines, "sanitizeNewlines", false, "Repair U+000B soft returns into newlines. Implies -sanitize, dropping other invalid characters if no mode is given")
+	flag.BoolVar(&opts.containers, "containers", false, "Convert CONTAINER fields into structured references")
+	flag.StringVar(&opts.containerDir, "containerDir", "", "Directory to resolve container references against, adding their size and SHA-256")
+	flag.Int64Var(&opts.containerInline, "containerInline", 0, "Inline resolved container files up to this many bytes as base64")
 
 	flag.Parse()
 
-	var reader io.ReadCloser
+	if !outputFormats[opts.format] {
+		log.Fatalf("Unknown output format '%s'", opts.format)
+	}
+
+	if opts.idPosition != fmpxmlresult.IDFieldsFirst && opts.idPosition != fmpxmlresult.IDFieldsLast {
+		log.Fatalf("Unknown ID position '%s'", opts.idPosition)
+	}
+
+	if err := opts.projection.Validate(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if err := opts.naming.Validate(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if !validCollisions(opts.collisions) {
+		log.Fatalf("Unknown key collision policy '%s'", opts.collisions)
+	}
+
+	if err := parsePostgresOptions(&opts); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// The state file already holds the previous record IDs, and both would write the same tombstones
+	if opts.stateFileName != "" && opts.previousFileName != "" {
+		log.Fatalf("-state and -previous cannot be used together")
+	}
 
-	if inFileName == "-" {
-		reader = os.Stdin
-	} else {
-		var err error
+	if opts.diffFileName != "" {
+		if opts.stateFileName != "" {
+			log.Fatalf("-state cannot be used with -diff")
+		}
+
+		if opts.previousFileName != "" {
+			log.Fatalf("-previous cannot be used with -diff, which already reports deleted records")
+		}
 
-		reader, err = os.Open(inFileName)
+		if opts.format == "postgres" {
+			log.Fatalf("-format postgres cannot be used with -diff")
+		}
 
-		if err != nil {
-			log.Fatalf("Unable to open '%s' for reading: %s", inFileName, err)
+		if err := runDiff(opts.diffFileName, opts); err != nil {
+			log.Fatalf("%v", err)
 		}
+
+		return
 	}
 
-	parsed, parseErr := xmlreader.ReadXML(reader)
+	if opts.generate != "" {
+		if err := runGenerate(opts); err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		return
+	}
 
-	closeOrFatal(reader)
+	if opts.sqliteFileName != "" {
+		if opts.merge || flag.NArg() > 0 {
+			log.Fatalf("-sqlite can only be used with a single -input")
+		}
 
-	if parseErr != nil {
-		log.Fatalf("Unable to read input XML: %v", parseErr)
+		if err := runSQLite(opts); err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		return
 	}
 
-	// These must be populated before calling PopulateRecords
-	parsed.RecordIDField = recordIDField
-	parsed.ModIDField = modIDField
+	if opts.merge {
+		if err := runMerge(flag.Args(), opts); err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		return
+	}
 
-	if err := parsed.PopulateRecords(); err != nil {
-		log.Fatalf("Unable to convert record format: %v", err)
+	// Any leftover arguments are files, directories or globs to convert in one go
+	if flag.NArg() > 0 {
+		os.Exit(runBatch(flag.Args(), opts))
 	}
 
-	if !full {
-		parsed.ResultSet = nil
-		parsed.Metadata = nil
+	if err := convertFile(opts.inFileName, opts.outFileName, opts); err != nil {
+		log.Fatalf("%v", err)
 	}
+}
 
-	var writer io.WriteCloser
+func parsePostgresOptions(opts *options) error {
+	types, err := postgres.ParseTypes(opts.pgTypes)
 
-	if outFileName == "-" {
-		writer = os.Stdout
-	} else {
-		var err error
+	if err != nil {
+		return err
+	}
+
+	opts.postgres.Types = types
 
-		writer, err = os.Create(outFileName)
+	return opts.postgres.Validate()
+}
 
-		if err != nil {
-			log.Fatalf("Unable to open '%s' for writing: %s", outFileName, err)
+func validCollisions(policy string) bool {
+	for _, valid := range fmpxmlresult.CollisionPolicies {
+		if policy == valid {
+			return true
 		}
 	}
 
-	encoder := json.NewEncoder(writer)
-	encoder.SetIndent("", "  ")
+	return false
+}
+
+// Whether a flag was given on the command line, rather than left at its default
+func flagGiven(name string) bool {
+	given := false
 
-	if err := encoder.Encode(parsed); err != nil {
-		closeOrFatal(writer)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
 
-		log.Fatalf("Could not write JSON data: %v", err)
+	return given
+}
+
+// A patternList collects a flag that can be given more than once
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ", ")
+}
+
+func (p *patternList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// A derivedList collects the -derive flags, each in the form key=expression
+type derivedList []fmpxmlresult.DerivedField
+
+func (d *derivedList) String() string {
+	keys := []string{}
+
+	for _, derived := range *d {
+		keys = append(keys, derived.Key)
 	}
 
-	closeOrFatal(writer)
+	return strings.Join(keys, ", ")
 }
 
-func closeOrFatal(f io.Closer) {
-	if err := f.Close(); err != nil {
-		log.Fatalf("Unable to close file: %v", err)
+func (d *derivedList) Set(value string) error {
+	derived, err := fmpxmlresult.ParseDerivedField(value)
+
+	if err != nil {
+		return err
 	}
+
+	*d = append(*d, derived)
+	return nil
 }
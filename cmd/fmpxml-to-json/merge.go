@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+	"github.com/hovercross/fmpxml-to-json/pkg/xmlreader"
+)
+
+// Merge every export the arguments refer to into a single output
+func runMerge(args []string, opts options) error {
+	parts := []*fmpxmlresult.FMPXMLResult{}
+	names := []string{}
+
+	for _, arg := range args {
+		fileNames, err := expandArg(arg)
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", arg, err)
+		}
+
+		for _, fileName := range fileNames {
+			parsed, err := readFile(fileName, opts)
+
+			if err != nil {
+				return fmt.Errorf("%s: %v", fileName, err)
+			}
+
+			for _, part := range parsed {
+				parts = append(parts, part)
+				names = append(names, fileName)
+			}
+		}
+	}
+
+	// Merge checks this too, but here we can say which files are the problem
+	for i, part := range parts {
+		if err := parts[0].CheckCompatible(part); err != nil {
+			return fmt.Errorf("'%s' does not match '%s': %v", names[i], names[0], err)
+		}
+	}
+
+	merged, err := fmpxmlresult.Merge(parts, opts.dropDuplicates)
+
+	if err != nil {
+		return fmt.Errorf("Unable to merge: %v", err)
+	}
+
+	if err := convert(merged, opts); err != nil {
+		return err
+	}
+
+	state, err := applyState(merged, opts.stateFileName)
+
+	if err != nil {
+		return err
+	}
+
+	if err := applyPrevious(merged, opts); err != nil {
+		return err
+	}
+
+	writer, err := openOutput(opts.outFileName, opts.compress, opts.compressLevel)
+
+	if err != nil {
+		return err
+	}
+
+	err = newResultWriter(writer, opts).writeResult(merged)
+
+	if closeErr := writer.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("Unable to close file: %v", closeErr)
+	}
+
+	if err == nil && state != nil {
+		err = saveState(opts.stateFileName, state)
+	}
+
+	if err != nil && opts.outFileName != "-" {
+		os.Remove(opts.outFileName)
+	}
+
+	return err
+}
+
+// Read every export in a file, or "-" for STDIN, which can be more than one for a zip archive
+func readFile(fileName string, opts options) ([]*fmpxmlresult.FMPXMLResult, error) {
+	var f io.ReadCloser = os.Stdin
+
+	if fileName != "-" {
+		var err error
+
+		f, err = os.Open(fileName)
+
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open '%s' for reading: %s", fileName, err)
+		}
+	}
+
+	defer f.Close()
+
+	inputs, err := xmlreader.OpenInputs(f, fileName)
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read input: %v", err)
+	}
+
+	defer closeInputs(inputs)
+
+	out := []*fmpxmlresult.FMPXMLResult{}
+
+	for _, input := range inputs {
+		parsed, err := readInput(input, input.Name, opts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, parsed)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+	"github.com/hovercross/fmpxml-to-json/pkg/postgres"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The compression codecs we can infer from an output file extension
+var codecExtensions = map[string]string{
+	".gz":   "gzip",
+	".gzip": "gzip",
+	".zst":  "zstd",
+	".zstd": "zstd",
+}
+
+// A compressed writer has to be closed before the file underneath it, or the end of the data gets lost
+type compressedWriter struct {
+	io.WriteCloser
+	underlying io.Closer
+}
+
+func (cw compressedWriter) Close() error {
+	if err := cw.WriteCloser.Close(); err != nil {
+		cw.underlying.Close()
+
+		return err
+	}
+
+	return cw.underlying.Close()
+}
+
+// Open the output file, or STDOUT for "-", and compress whatever goes to it.
+// If codec is empty, it is inferred from the file extension. A level of zero uses the codec's default
+func openOutput(fileName, codec string, level int) (io.WriteCloser, error) {
+	if codec == "" {
+		codec = codecExtensions[strings.ToLower(filepath.Ext(fileName))]
+	}
+
+	// Check the codec before creating anything, so a typo doesn't leave an empty file behind
+	if codec != "" && codec != "none" && codec != "gzip" && codec != "zstd" {
+		return nil, fmt.Errorf("Unknown compression codec '%s'", codec)
+	}
+
+	var f io.WriteCloser = os.Stdout
+
+	if fileName != "-" {
+		var err error
+
+		f, err = os.Create(fileName)
+
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open '%s' for writing: %s", fileName, err)
+		}
+	}
+
+	w, err := compressWriter(f, codec, level)
+
+	if err != nil {
+		f.Close()
+
+		if fileName != "-" {
+			os.Remove(fileName)
+		}
+
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func compressWriter(f io.WriteCloser, codec string, level int) (io.WriteCloser, error) {
+	switch codec {
+	case "gzip":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+
+		w, err := gzip.NewWriterLevel(f, level)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid gzip level %d: %v", level, err)
+		}
+
+		return compressedWriter{w, f}, nil
+	case "zstd":
+		encoderLevel := zstd.SpeedDefault
+
+		if level != 0 {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+
+		w, err := zstd.NewWriter(f, zstd.WithEncoderLevel(encoderLevel))
+
+		if err != nil {
+			return nil, fmt.Errorf("Could not create zstd writer: %v", err)
+		}
+
+		return compressedWriter{w, f}, nil
+	}
+
+	return f, nil
+}
+
+// The output formats: a single JSON document, or newline delimited JSON with one item per line
+var outputFormats = map[string]bool{
+	"json":     true,
+	"ndjson":   true,
+	"postgres": true,
+}
+
+// A resultWriter writes results in the chosen output format
+type resultWriter struct {
+	w        io.Writer
+	format   string
+	full     bool // Whether to keep the original metadata and result set in JSON output
+	postgres postgres.Options
+	pretty   *json.Encoder
+	compact  *json.Encoder
+	array    bool // Whether JSON documents are written as items of an array, for several exports in one output
+	written  int  // How many results have been written
+}
+
+func newResultWriter(w io.Writer, opts options) *resultWriter {
+	pretty := json.NewEncoder(w)
+	pretty.SetIndent("", "  ")
+
+	return &resultWriter{
+		w:        w,
+		format:   opts.format,
+		full:     opts.full,
+		postgres: opts.postgres,
+		pretty:   pretty,
+		compact:  json.NewEncoder(w),
+	}
+}
+
+// Start an array of JSON documents, so several exports still make a single JSON value. Other formats can just be written one after another
+func (rw *resultWriter) beginArray() error {
+	if rw.format != "json" {
+		return nil
+	}
+
+	rw.array = true
+	_, err := io.WriteString(rw.w, "[\n")
+
+	return err
+}
+
+// Finish the array started by beginArray, if there was one
+func (rw *resultWriter) endArray() error {
+	if !rw.array {
+		return nil
+	}
+
+	_, err := io.WriteString(rw.w, "]\n")
+
+	return err
+}
+
+// Write a converted export: the whole document for JSON, just the records for NDJSON, or a script that loads them for PostgreSQL
+func (rw *resultWriter) writeResult(parsed *fmpxmlresult.FMPXMLResult) error {
+	defer func() { rw.written++ }()
+
+	if rw.format == "postgres" {
+		return postgres.WriteScript(rw.w, parsed, rw.postgres)
+	}
+
+	if rw.array && rw.written > 0 {
+		if _, err := io.WriteString(rw.w, ",\n"); err != nil {
+			return err
+		}
+	}
+
+	if rw.format == "json" && rw.full {
+		return rw.pretty.Encode(parsed)
+	}
+
+	if rw.format == "json" {
+		stripped := *parsed
+		stripped.ResultSet = nil
+		stripped.Metadata = nil
+
+		return rw.pretty.Encode(stripped)
+	}
+
+	keys := parsed.RecordKeys()
+
+	for _, record := range parsed.Records {
+		if err := rw.compact.Encode(fmpxmlresult.OrderRecord(record, keys)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write diff changes: an array for JSON, or one change per line for NDJSON
+func (rw *resultWriter) writeChanges(changes []fmpxmlresult.Change) error {
+	if rw.format != "json" && rw.format != "ndjson" {
+		return fmt.Errorf("Changes cannot be written as %s", rw.format)
+	}
+
+	if rw.format == "json" {
+		return rw.pretty.Encode(changes)
+	}
+
+	for _, change := range changes {
+		if err := rw.compact.Encode(change); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/sqlite"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The SQLite driver is written in C, so this is only built with cgo
+
+// Write the input into a SQLite database instead of a JSON file
+func runSQLite(opts options) error {
+	exports, err := readFile(opts.inFileName, opts)
+
+	if err != nil {
+		return err
+	}
+
+	if len(exports) > 1 && (opts.stateFileName != "" || opts.previousFileName != "") {
+		return fmt.Errorf("-state and -previous cannot be used with an archive of %d exports", len(exports))
+	}
+
+	db, err := sql.Open("sqlite3", opts.sqliteFileName)
+
+	if err != nil {
+		return fmt.Errorf("Unable to open database '%s': %v", opts.sqliteFileName, err)
+	}
+
+	defer db.Close()
+
+	for _, export := range exports {
+		// A field can't go in the ID columns, so it is moved out of the way, unless the collision policy is to fail
+		export.ReservedKeys = sqlite.ReservedColumns
+
+		if err := convert(export, opts); err != nil {
+			return err
+		}
+
+		state, err := applyState(export, opts.stateFileName)
+
+		if err != nil {
+			return err
+		}
+
+		if err := applyPrevious(export, opts); err != nil {
+			return err
+		}
+
+		result, err := sqlite.Write(db, export, sqlite.Options{Table: opts.sqliteTable, Source: opts.inFileName})
+
+		if err != nil {
+			return fmt.Errorf("Unable to write to database '%s': %v", opts.sqliteFileName, err)
+		}
+
+		log.Printf("%s: %d inserted, %d updated, %d unchanged, %d deleted", result.Table, result.Inserted, result.Updated, result.Unchanged, result.Deleted)
+
+		// Like with a file, the state only moves forward once the records are safely written
+		if state != nil {
+			if err := saveState(opts.stateFileName, state); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
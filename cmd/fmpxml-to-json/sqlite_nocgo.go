@@ -0,0 +1,11 @@
+//go:build !cgo
+// +build !cgo
+
+package main
+
+import "fmt"
+
+// Without cgo there is no SQLite driver, so -sqlite can only explain how to get one
+func runSQLite(opts options) error {
+	return fmt.Errorf("-sqlite needs a build with cgo, such as with CGO_ENABLED=1 and a C compiler installed")
+}
@@ -2,4 +2,10 @@ module github.com/hovercross/fmpxml-to-json
 
 go 1.15
 
-require github.com/go-test/deep v1.0.7
+require (
+	github.com/go-test/deep v1.0.7
+	github.com/klauspost/compress v1.15.15
+	github.com/mattn/go-sqlite3 v1.14.16
+	golang.org/x/text v0.3.8
+	gopkg.in/yaml.v3 v3.0.1
+)
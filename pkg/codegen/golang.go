@@ -0,0 +1,270 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+// GoOptions control the generated Go source
+type GoOptions struct {
+	Package     string // The package name. Defaults to "records"
+	TypeName    string // The record struct name. Defaults to the database name
+	TimeStrings bool   // Use strings for dates and times, instead of types wrapping time.Time
+	Tombstones  bool   // Add the deleted marker, for incremental exports
+}
+
+// The layouts the conversion writes dates and times in, for the types wrapping time.Time
+var goTimeTypes = map[string]struct{ name, layout, doc string }{
+	"date":      {"Date", "2006-01-02", "Date is a date, written like 2006-01-02"},
+	"time":      {"Time", "15:04:05", "Time is a time of day, written like 15:04:05"},
+	"date-time": {"Timestamp", "2006-01-02T15:04:05", "Timestamp is a date and time without a time zone, written like 2006-01-02T15:04:05"},
+}
+
+// A Go source file being generated
+type goFile struct {
+	opts    GoOptions
+	imports map[string]bool
+	helpers map[string]bool // The time formats and containers that need their own types
+}
+
+// WriteGo will write a Go source file with a struct for the records of an export.
+// Only the metadata is needed, along with the conversion options set on the export
+func WriteGo(w io.Writer, fmp *fmpxmlresult.FMPXMLResult, opts GoOptions) error {
+	if opts.Package == "" {
+		opts.Package = "records"
+	}
+
+	if opts.TypeName == "" {
+		opts.TypeName = defaultTypeName(fmp)
+	}
+
+	g := &goFile{opts: opts, imports: map[string]bool{}, helpers: map[string]bool{}}
+
+	body := &bytes.Buffer{}
+
+	if err := g.writeStruct(body, fmp); err != nil {
+		return err
+	}
+
+	g.writeHelpers(body)
+
+	source := &bytes.Buffer{}
+
+	if fmp.Database != nil {
+		fmt.Fprintf(source, "// Code generated by fmpxml-to-json from %s. DO NOT EDIT.\n\n", fmp.Database.Name)
+	} else {
+		fmt.Fprintf(source, "// Code generated by fmpxml-to-json. DO NOT EDIT.\n\n")
+	}
+
+	fmt.Fprintf(source, "package %s\n\n", opts.Package)
+
+	if len(g.imports) > 0 {
+		imports := []string{}
+
+		for path := range g.imports {
+			imports = append(imports, fmt.Sprintf("%q", path))
+		}
+
+		sort.Strings(imports)
+
+		fmt.Fprintf(source, "import (\n%s\n)\n\n", strings.Join(imports, "\n"))
+	}
+
+	source.Write(body.Bytes())
+
+	formatted, err := format.Source(source.Bytes())
+
+	if err != nil {
+		return fmt.Errorf("Unable to format generated source: %v", err)
+	}
+
+	_, err = w.Write(formatted)
+
+	return err
+}
+
+func (g *goFile) writeStruct(w io.Writer, fmp *fmpxmlresult.FMPXMLResult) error {
+	names := identifiers{}
+
+	fmt.Fprintf(w, "// %s is a single record of the export\ntype %s struct {\n", g.opts.TypeName, g.opts.TypeName)
+
+	properties, err := recordProperties(fmp)
+
+	if err != nil {
+		return err
+	}
+
+	for _, prop := range properties {
+		if !validJSONTag(prop.key) {
+			return fmt.Errorf("Field '%s' cannot be used as a JSON tag", prop.key)
+		}
+
+		fmt.Fprintf(w, "%s %s `json:\"%s\"`\n", names.unique(exportedName(prop.key)), g.goType(prop.schema), prop.key)
+	}
+
+	if g.opts.Tombstones {
+		fmt.Fprintf(w, "\n// Set on a tombstone, which only has the record ID and modification ID\n")
+		fmt.Fprintf(w, "%s bool `json:\"%s,omitempty\"`\n", names.unique("Deleted"), fmpxmlresult.DeletedField)
+	}
+
+	fmt.Fprintf(w, "}\n")
+
+	return nil
+}
+
+// Get the Go type for a schema, recording any imports and helper types it needs
+func (g *goFile) goType(s *fmpxmlresult.Schema) string {
+	name, nullable := schemaType(s)
+	out := ""
+
+	switch name {
+	case "array":
+		// Arrays are never null, but their items can be
+		return "[]" + g.goType(s.Items)
+	case "number":
+		// Numbers are written with all the digits Filemaker exported, which a float64 would round
+		g.imports["encoding/json"] = true
+		out = "json.Number"
+	case "integer":
+		out = "int64"
+	case "boolean":
+		out = "bool"
+	case "object":
+		g.helpers["container"] = true
+		out = "ContainerReference"
+	case "string":
+		out = "string"
+
+		if format := timeFormat(s); format != "" && !g.opts.TimeStrings {
+			g.helpers[format] = true
+			out = goTimeTypes[format].name
+		}
+	default:
+		// Anything goes, including null
+		g.imports["encoding/json"] = true
+		return "json.RawMessage"
+	}
+
+	if nullable {
+		return "*" + out
+	}
+
+	return out
+}
+
+// Get which of the goTimeTypes a string schema is. Only dates have a format, since times and timestamps are written without a time zone
+func timeFormat(s *fmpxmlresult.Schema) string {
+	switch {
+	case s.Format == "date":
+		return "date"
+	case s.Pattern == fmpxmlresult.TimePattern:
+		return "time"
+	case s.Pattern == fmpxmlresult.TimestampPattern:
+		return "date-time"
+	}
+
+	return ""
+}
+
+func (g *goFile) writeHelpers(w io.Writer) {
+	formats := []string{}
+
+	for format := range goTimeTypes {
+		if g.helpers[format] {
+			formats = append(formats, format)
+		}
+	}
+
+	sort.Strings(formats)
+
+	for _, format := range formats {
+		timeType := goTimeTypes[format]
+
+		g.imports["encoding/json"] = true
+		g.imports["time"] = true
+
+		fmt.Fprintf(w, `
+// %s
+type %s struct {
+	time.Time
+}
+
+// UnmarshalJSON parses the converted value
+func (t *%s) UnmarshalJSON(data []byte) error {
+	return unmarshalTime(data, %q, &t.Time)
+}
+
+// MarshalJSON writes the value the way it was converted
+func (t %s) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Format(%q))
+}
+`, timeType.doc, timeType.name, timeType.name, timeType.layout, timeType.name, timeType.layout)
+	}
+
+	if len(formats) > 0 {
+		fmt.Fprintf(w, `
+func unmarshalTime(data []byte, layout string, t *time.Time) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var s string
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.Parse(layout, s)
+
+	if err != nil {
+		return err
+	}
+
+	*t = parsed
+
+	return nil
+}
+`)
+	}
+
+	if g.helpers["container"] {
+		fmt.Fprintf(w, `
+// ContainerReference is a reference to the file in a container field
+type ContainerReference struct {
+	Kind     string `+"`json:\"kind\"`"+`
+	Filename string `+"`json:\"filename\"`"+`
+	Path     string `+"`json:\"path,omitempty\"`"+`
+	URL      string `+"`json:\"url,omitempty\"`"+`
+	Width    int    `+"`json:\"width,omitempty\"`"+`
+	Height   int    `+"`json:\"height,omitempty\"`"+`
+	Size     *int64 `+"`json:\"size,omitempty\"`"+`
+	SHA256   string `+"`json:\"sha256,omitempty\"`"+`
+	Data     string `+"`json:\"data,omitempty\"`"+`
+	Missing  bool   `+"`json:\"missing,omitempty\"`"+`
+}
+`)
+	}
+}
+
+// The characters encoding/json allows in a tag name, besides letters and digits
+const jsonTagPunctuation = "!#$%&()*+-./:;<=>?@[]^_{|}~ "
+
+func validJSONTag(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for _, r := range key {
+		if !strings.ContainsRune(jsonTagPunctuation, r) && !isLetterOrDigit(r) {
+			return false
+		}
+	}
+
+	return true
+}
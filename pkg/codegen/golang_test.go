@@ -0,0 +1,96 @@
+package codegen_test
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/codegen"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_WriteGo(t *testing.T) {
+	fmp := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "Contact ID", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Age", Type: "NUMBER", MaxRepeat: 1, EmptyOK: true},
+			{Name: "Born", Type: "DATE", MaxRepeat: 1},
+			{Name: "Phones", Type: "TEXT", MaxRepeat: 3, EmptyOK: true},
+			{Name: "Photo", Type: "CONTAINER", MaxRepeat: 1, EmptyOK: true},
+			{Name: "Extra", Type: "TEXT", MaxRepeat: 1},
+			{Name: "contact_id", Type: "TEXT", MaxRepeat: 1},
+			{Name: "2nd Address", Type: "TEXT", MaxRepeat: 1},
+			{Name: "HTTPServer Port", Type: "TEXT", MaxRepeat: 1},
+		}},
+		RecordIDField: "recordID",
+		TypeMapping:   fmpxmlresult.TypeMapping{"Extra": {Type: "JSON"}},
+		Containers:    &fmpxmlresult.ContainerOptions{},
+	}
+
+	out := &bytes.Buffer{}
+
+	if err := codegen.WriteGo(out, &fmp, codegen.GoOptions{Package: "contacts", Tombstones: true}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	source := out.String()
+
+	if _, err := parser.ParseFile(token.NewFileSet(), "contacts.go", source, 0); err != nil {
+		t.Errorf("Generated source does not parse: %v", err)
+	}
+
+	// Fields are aligned by gofmt, so squash the spacing before looking for them
+	squashed := strings.Join(strings.Fields(source), " ")
+
+	for _, expected := range []string{
+		"package contacts",
+		"type Contacts struct {",
+		"RecordID string `json:\"recordID\"`",
+		"ContactID *string `json:\"Contact ID\"`",
+		"Age *json.Number `json:\"Age\"`",
+		"Born *Date `json:\"Born\"`",
+		"Phones []string `json:\"Phones\"`",
+		"Photo *ContainerReference `json:\"Photo\"`",
+		"Extra json.RawMessage `json:\"Extra\"`",
+		"ContactID2 *string `json:\"contact_id\"`",
+		"Field2ndAddress *string `json:\"2nd Address\"`",
+		"HTTPServerPort *string `json:\"HTTPServer Port\"`",
+		"Deleted bool `json:\"_deleted,omitempty\"`",
+		"type Date struct { time.Time }",
+		"type ContainerReference struct {",
+	} {
+		if !strings.Contains(squashed, expected) {
+			t.Errorf("Missing %s", expected)
+		}
+	}
+
+	// Without the time types, there's nothing to import for dates
+	out.Reset()
+
+	if err := codegen.WriteGo(out, &fmp, codegen.GoOptions{TimeStrings: true}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if strings.Contains(out.String(), `"time"`) || !strings.Contains(out.String(), "package records") {
+		t.Errorf("Unexpected source with time strings:\n%s", out.String())
+	}
+}
+
+func Test_WriteGoInvalidTag(t *testing.T) {
+	fmp := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "Contact ID", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Last, First", Type: "TEXT", MaxRepeat: 1},
+		}},
+	}
+
+	if err := codegen.WriteGo(&bytes.Buffer{}, &fmp, codegen.GoOptions{}); err == nil {
+		t.Error("Err is nil for a field name that can't be a JSON tag")
+	}
+}
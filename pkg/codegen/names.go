@@ -0,0 +1,115 @@
+package codegen
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+// This package generates source code describing the records of an export, for languages that consume the JSON output.
+// The types follow the JSON Schema for each field, so they always agree with what the conversion emits
+
+// Common initialisms, which Go writes in a single case
+var initialisms = map[string]bool{
+	"API": true, "CSS": true, "CSV": true, "DB": true, "DNS": true, "HTML": true, "HTTP": true, "HTTPS": true,
+	"ID": true, "IP": true, "JSON": true, "PDF": true, "SKU": true, "SQL": true, "SSN": true, "TCP": true,
+	"UI": true, "URI": true, "URL": true, "UUID": true, "XML": true, "ZIP": true,
+}
+
+// Build an exported identifier from a name, like "Contact ID" to "ContactID"
+func exportedName(name string) string {
+	out := ""
+
+	for _, word := range fmpxmlresult.SplitWords(name) {
+		if upper := strings.ToUpper(word); initialisms[upper] {
+			out += upper
+			continue
+		}
+
+		runes := []rune(strings.ToLower(word))
+		runes[0] = unicode.ToUpper(runes[0])
+		out += string(runes)
+	}
+
+	if out == "" || !unicode.IsLetter([]rune(out)[0]) {
+		out = "Field" + out
+	}
+
+	return out
+}
+
+// Give every name a unique identifier, numbering any repeats
+type identifiers map[string]bool
+
+func (ids identifiers) unique(name string) string {
+	out := name
+
+	for i := 2; ids[out]; i++ {
+		out = name + strconv.Itoa(i)
+	}
+
+	ids[out] = true
+
+	return out
+}
+
+// The default type name comes from the database name, without its extension
+func defaultTypeName(fmp *fmpxmlresult.FMPXMLResult) string {
+	if fmp.Database == nil || fmp.Database.Name == "" {
+		return "Record"
+	}
+
+	return exportedName(strings.TrimSuffix(fmp.Database.Name, filepath.Ext(fmp.Database.Name)))
+}
+
+// A property of the record, in output order
+type property struct {
+	key    string
+	schema *fmpxmlresult.Schema
+}
+
+// Every property is required, and the required list is in the order records are written
+func recordProperties(fmp *fmpxmlresult.FMPXMLResult) ([]property, error) {
+	schema, err := fmp.RecordSchema(fmpxmlresult.SchemaOptions{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	out := []property{}
+
+	for _, key := range schema.Required {
+		out = append(out, property{key, schema.Properties[key]})
+	}
+
+	return out, nil
+}
+
+// Split a schema type into its name and whether it can be null
+func schemaType(s *fmpxmlresult.Schema) (string, bool) {
+	switch t := s.Type.(type) {
+	case string:
+		return t, false
+	case []string:
+		name, nullable := "", false
+
+		for _, part := range t {
+			if part == "null" {
+				nullable = true
+			} else {
+				name = part
+			}
+		}
+
+		return name, nullable
+	}
+
+	return "", false
+}
+
+func isLetterOrDigit(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
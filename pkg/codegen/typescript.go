@@ -0,0 +1,228 @@
+package codegen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+// TypeScriptOptions control the generated TypeScript declarations
+type TypeScriptOptions struct {
+	TypeName   string // The record interface name. Defaults to the database name
+	Full       bool   // Whether the metadata and result set are kept in the envelope
+	Tombstones bool   // Whether records can be tombstones, for incremental exports
+}
+
+// A declaration file being generated
+type tsFile struct {
+	opts    TypeScriptOptions
+	named   map[string]*fmpxmlresult.Schema // Titled object schemas, which get their own interface
+	refs    map[string]string               // Schema references to the interface names
+	written map[string]bool
+}
+
+// WriteTypeScript will write a declaration file with an interface for the records of an export, and for the whole document.
+// Only the metadata is needed, along with the conversion options set on the export
+func WriteTypeScript(w io.Writer, fmp *fmpxmlresult.FMPXMLResult, opts TypeScriptOptions) error {
+	if opts.TypeName == "" {
+		opts.TypeName = defaultTypeName(fmp)
+	}
+
+	envelope, err := fmp.EnvelopeSchema(fmpxmlresult.SchemaOptions{Full: opts.Full, Tombstones: opts.Tombstones})
+
+	if err != nil {
+		return err
+	}
+
+	properties, err := recordProperties(fmp)
+
+	if err != nil {
+		return err
+	}
+
+	ts := &tsFile{
+		opts:  opts,
+		named: map[string]*fmpxmlresult.Schema{},
+		refs: map[string]string{
+			"#/$defs/record":    opts.TypeName,
+			"#/$defs/tombstone": opts.TypeName + "Tombstone",
+		},
+		written: map[string]bool{},
+	}
+
+	out := &strings.Builder{}
+
+	if fmp.Database != nil {
+		fmt.Fprintf(out, "// Generated by fmpxml-to-json from %s. Do not edit.\n", fmp.Database.Name)
+	} else {
+		fmt.Fprintf(out, "// Generated by fmpxml-to-json. Do not edit.\n")
+	}
+
+	// The record keeps the output order
+	fmt.Fprintf(out, "\n/** A single record of the export */\nexport interface %s {\n", opts.TypeName)
+
+	for _, prop := range properties {
+		fmt.Fprintf(out, "  %s: %s;\n", tsKey(prop.key), ts.tsType(prop.schema))
+	}
+
+	fmt.Fprintf(out, "}\n")
+
+	if opts.Tombstones {
+		ts.writeInterface(out, ts.refs["#/$defs/tombstone"], "A deleted record, from an incremental export", envelope.Defs["tombstone"])
+	}
+
+	ts.writeInterface(out, opts.TypeName+"Export", "The whole converted document", envelope)
+
+	// Containers and anything else with a title get written once, at the end
+	for len(ts.named) > len(ts.written) {
+		names := []string{}
+
+		for name := range ts.named {
+			if !ts.written[name] {
+				names = append(names, name)
+			}
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			ts.written[name] = true
+			ts.writeInterface(out, name, "", ts.named[name])
+		}
+	}
+
+	_, err = io.WriteString(w, out.String())
+
+	return err
+}
+
+func (ts *tsFile) writeInterface(w io.Writer, name, doc string, s *fmpxmlresult.Schema) {
+	fmt.Fprintf(w, "\n")
+
+	if doc != "" {
+		fmt.Fprintf(w, "/** %s */\n", doc)
+	}
+
+	fmt.Fprintf(w, "export interface %s %s\n", name, ts.objectBody(s, ""))
+}
+
+// Write the body of an object type, with its properties in name order
+func (ts *tsFile) objectBody(s *fmpxmlresult.Schema, indent string) string {
+	required := map[string]bool{}
+
+	for _, name := range s.Required {
+		required[name] = true
+	}
+
+	keys := []string{}
+
+	for key := range s.Properties {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	out := "{\n"
+
+	for _, key := range keys {
+		optional := "?"
+
+		if required[key] {
+			optional = ""
+		}
+
+		out += fmt.Sprintf("%s  %s%s: %s;\n", indent, tsKey(key), optional, ts.inlineType(s.Properties[key], indent+"  "))
+	}
+
+	return out + indent + "}"
+}
+
+func (ts *tsFile) tsType(s *fmpxmlresult.Schema) string {
+	return ts.inlineType(s, "  ")
+}
+
+// Get the TypeScript type for a schema. Untitled objects are written inline
+func (ts *tsFile) inlineType(s *fmpxmlresult.Schema, indent string) string {
+	if s.Ref != "" {
+		return ts.refs[s.Ref]
+	}
+
+	if len(s.AnyOf) > 0 {
+		parts := []string{}
+
+		for _, part := range s.AnyOf {
+			parts = append(parts, ts.inlineType(part, indent))
+		}
+
+		return strings.Join(parts, " | ")
+	}
+
+	if s.Const != nil {
+		encoded, _ := json.Marshal(s.Const)
+		return string(encoded)
+	}
+
+	name, nullable := schemaType(s)
+	out := ""
+
+	switch {
+	case len(s.Enum) > 0:
+		literals := []string{}
+
+		for _, value := range s.Enum {
+			literals = append(literals, tsString(value))
+		}
+
+		out = strings.Join(literals, " | ")
+	case name == "array":
+		out = ts.inlineType(s.Items, indent)
+
+		if strings.Contains(out, " | ") {
+			out = "(" + out + ")"
+		}
+
+		out += "[]"
+	case name == "number", name == "integer":
+		out = "number"
+	case name == "string":
+		out = "string"
+	case name == "boolean":
+		out = "boolean"
+	case name == "object" && s.Title != "":
+		ts.named[s.Title] = s
+		out = s.Title
+	case name == "object":
+		out = ts.objectBody(s, indent)
+	default:
+		// Anything goes, including null
+		return "unknown"
+	}
+
+	if nullable {
+		return out + " | null"
+	}
+
+	return out
+}
+
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// Property names are quoted unless they are plain identifiers
+func tsKey(key string) string {
+	if tsIdentifier.MatchString(key) {
+		return key
+	}
+
+	return tsString(key)
+}
+
+// A JSON string is always a valid TypeScript string
+func tsString(s string) string {
+	encoded, _ := json.Marshal(s)
+	return string(encoded)
+}
@@ -0,0 +1,105 @@
+package codegen_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/codegen"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_WriteTypeScript(t *testing.T) {
+	fmp := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "Contact ID", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Age", Type: "NUMBER", MaxRepeat: 1, EmptyOK: true},
+			{Name: "Born", Type: "DATE", MaxRepeat: 1},
+			{Name: "Phones", Type: "TEXT", MaxRepeat: 3, EmptyOK: true},
+			{Name: "Photo", Type: "CONTAINER", MaxRepeat: 1, EmptyOK: true},
+			{Name: "Extra", Type: "TEXT", MaxRepeat: 1},
+		}},
+		RecordIDField: "recordID",
+		TypeMapping:   fmpxmlresult.TypeMapping{"Extra": {Type: "JSON"}},
+		Containers:    &fmpxmlresult.ContainerOptions{},
+	}
+
+	out := &bytes.Buffer{}
+
+	if err := codegen.WriteTypeScript(out, &fmp, codegen.TypeScriptOptions{TypeName: "Contact", Tombstones: true}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := `// Generated by fmpxml-to-json from Contacts.fmp12. Do not edit.
+
+/** A single record of the export */
+export interface Contact {
+  recordID: string;
+  "Contact ID": string | null;
+  Age: number | null;
+  Born: string | null;
+  Phones: string[];
+  Photo: ContainerReference | null;
+  Extra: unknown;
+}
+
+/** A deleted record, from an incremental export */
+export interface ContactTombstone {
+  _deleted: true;
+  modID?: string;
+  recordID: string;
+}
+
+/** The whole converted document */
+export interface ContactExport {
+  database?: {
+    dateFormat?: string;
+    layout?: string;
+    name?: string;
+    records?: number;
+    timeFormat?: string;
+  };
+  errorCode: number;
+  product?: {
+    build?: string;
+    name?: string;
+    version?: string;
+  };
+  records?: (Contact | ContactTombstone)[];
+}
+
+export interface ContainerReference {
+  data?: string;
+  filename: string;
+  height?: number;
+  kind: "image" | "file" | "movie";
+  missing?: boolean;
+  path?: string;
+  sha256?: string;
+  size?: number;
+  url?: string;
+  width?: number;
+}
+`
+
+	for _, diff := range deep.Equal(out.String(), expected) {
+		t.Error(diff)
+	}
+}
+
+func Test_WriteTypeScriptFull(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	fmp := &fmpxmlresult.FMPXMLResult{Metadata: &fmpxmlresult.Metadata{}}
+
+	if err := codegen.WriteTypeScript(out, fmp, codegen.TypeScriptOptions{Full: true}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.Contains(out.Bytes(), []byte("export interface Record {\n}")) || !bytes.Contains(out.Bytes(), []byte("  resultSet?: {")) {
+		t.Errorf("Unexpected declarations:\n%s", out.String())
+	}
+}
@@ -0,0 +1,29 @@
+package fmpxmlresult_test
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Values keep their contents unexported, so compare anything holding them by the JSON it writes
+func compareJSON(t *testing.T, have, want interface{}) {
+	t.Helper()
+
+	haveJSON, err := json.Marshal(have)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	wantJSON, err := json.Marshal(want)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if string(haveJSON) != string(wantJSON) {
+		t.Errorf("Have %s, want %s", haveJSON, wantJSON)
+	}
+}
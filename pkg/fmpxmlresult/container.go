@@ -0,0 +1,341 @@
+package fmpxmlresult
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// This file has the CONTAINER field handling. Filemaker exports a container as a filename,
+// one or more reference lines like "imagewin:/C:/Photos/a.jpg", or a URL
+
+// ContainerOptions controls how CONTAINER fields are converted
+type ContainerOptions struct {
+	BaseDir     string // If set, references are resolved against this directory to add the size and SHA-256
+	InlineLimit int64  // Resolved files no larger than this are inlined as base64. Zero disables inlining
+}
+
+// ContainerReference is the structured form of a Filemaker container value
+type ContainerReference struct {
+	Kind     string `json:"kind"` // One of image, file, or movie
+	Filename string `json:"filename"`
+	Path     string `json:"path,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Size     *int64 `json:"size,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	Data     string `json:"data,omitempty"`    // The base64 file contents, if it was small enough to inline
+	Missing  bool   `json:"missing,omitempty"` // Set if the reference was resolved, but the file wasn't there or was outside the base directory
+}
+
+// The reference prefixes, without their platform suffix
+var containerPrefixKinds = map[string]string{
+	"image": "image",
+	"file":  "file",
+	"movie": "movie",
+}
+
+var containerPlatforms = []string{"", "win", "mac", "linux"}
+
+var imageExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".bmp": true,
+	".tif": true, ".tiff": true, ".heic": true, ".webp": true,
+}
+
+var movieExtensions = map[string]bool{
+	".mov": true, ".mp4": true, ".m4v": true, ".avi": true, ".wmv": true, ".mpg": true, ".mpeg": true,
+}
+
+// ParseContainer will parse a Filemaker container value into a reference, without touching the disk
+func ParseContainer(s string) ContainerReference {
+	out := ContainerReference{}
+
+	for _, line := range strings.Split(strings.ReplaceAll(s, "\r", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "size:") {
+			parseContainerSize(&out, strings.TrimPrefix(line, "size:"))
+			continue
+		}
+
+		if kind, p, found := parseContainerPath(line); found {
+			// The first path wins: Filemaker writes the relative path before the absolute ones
+			if out.Path == "" {
+				out.Kind = kind
+				out.Path = p
+			}
+
+			continue
+		}
+
+		if isContainerURL(line) {
+			if out.URL == "" {
+				out.URL = line
+			}
+
+			continue
+		}
+
+		// Anything else is an embedded file, which only gets its filename exported
+		if out.Filename == "" {
+			out.Filename = line
+		}
+	}
+
+	if out.Path != "" {
+		out.Filename = path.Base(out.Path)
+	} else if out.Filename == "" && out.URL != "" {
+		if u, err := url.Parse(out.URL); err == nil {
+			out.Filename = path.Base(u.Path)
+		}
+	}
+
+	if out.Kind == "" {
+		out.Kind = containerKindFromName(out.Filename)
+	}
+
+	return out
+}
+
+// Parse a "size:width,height" line
+func parseContainerSize(out *ContainerReference, s string) {
+	parts := strings.SplitN(s, ",", 2)
+
+	if len(parts) != 2 {
+		return
+	}
+
+	width, widthErr := strconv.Atoi(strings.TrimSpace(parts[0]))
+	height, heightErr := strconv.Atoi(strings.TrimSpace(parts[1]))
+
+	if widthErr == nil && heightErr == nil {
+		out.Width = width
+		out.Height = height
+	}
+}
+
+// Parse a reference line, like "image:Photos/a.jpg" or "filewin:/C:/Documents/a.pdf"
+func parseContainerPath(line string) (string, string, bool) {
+	colon := strings.Index(line, ":")
+
+	if colon == -1 {
+		return "", "", false
+	}
+
+	prefix := strings.ToLower(line[:colon])
+	rest := line[colon+1:]
+
+	for base, kind := range containerPrefixKinds {
+		for _, platform := range containerPlatforms {
+			if prefix != base+platform {
+				continue
+			}
+
+			// A file:// URL isn't a reference, so it's left for the URL check
+			if prefix == "file" && strings.HasPrefix(rest, "//") {
+				return "", "", false
+			}
+
+			// Windows references look like "/C:/Folder/file", which should lose the leading slash
+			if platform == "win" && len(rest) > 2 && rest[0] == '/' && rest[2] == ':' {
+				rest = rest[1:]
+			}
+
+			return kind, rest, true
+		}
+	}
+
+	return "", "", false
+}
+
+func isContainerURL(line string) bool {
+	return strings.Contains(line, "://") || strings.HasPrefix(line, "/fmi/")
+}
+
+func containerKindFromName(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+
+	if imageExtensions[ext] {
+		return "image"
+	}
+
+	if movieExtensions[ext] {
+		return "movie"
+	}
+
+	return "file"
+}
+
+// Resolve will find the referenced file under the base directory, and add its size, hash, and possibly the data
+func (cr *ContainerReference) Resolve(opts ContainerOptions) error {
+	if cr.URL != "" && cr.Path == "" {
+		return nil // Nothing on disk to look at
+	}
+
+	name := cr.Path
+
+	if name == "" {
+		name = cr.Filename
+	}
+
+	if name == "" {
+		return nil
+	}
+
+	fileName, inside := containedPath(opts.BaseDir, name)
+
+	// Symlinks are followed before looking again, since one inside the base directory can still point outside it
+	if inside {
+		var err error
+
+		if fileName, inside, err = resolveContained(opts.BaseDir, fileName); os.IsNotExist(err) {
+			cr.Missing = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// Anything that would be read from outside the base directory is never opened, so it can't end up inlined in the output
+	if !inside {
+		cr.Missing = true
+		return nil
+	}
+
+	f, err := os.Open(fileName)
+
+	if os.IsNotExist(err) {
+		cr.Missing = true
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	stat, err := f.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	size := stat.Size()
+	cr.Size = &size
+
+	var data []byte
+	hash := sha256.New()
+
+	if opts.InlineLimit > 0 && size <= opts.InlineLimit {
+		if data, err = ioutil.ReadAll(f); err != nil {
+			return err
+		}
+
+		hash.Write(data)
+		cr.Data = base64.StdEncoding.EncodeToString(data)
+	} else if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+
+	cr.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	return nil
+}
+
+// Get the file a reference points to under the base directory. Absolute references, including Windows drive letters,
+// and relative ones that climb out of the base directory with .. aren't inside it
+func containedPath(baseDir, name string) (string, bool) {
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" || (len(name) >= 2 && name[1] == ':') {
+		return "", false
+	}
+
+	base := filepath.Clean(baseDir)
+	fileName := filepath.Join(base, filepath.FromSlash(name))
+
+	if !isInside(base, fileName) {
+		return "", false
+	}
+
+	return fileName, true
+}
+
+// Follow the symlinks in both the base directory and the file, and check the file is still inside it
+func resolveContained(baseDir, fileName string) (string, bool, error) {
+	base, err := filepath.EvalSymlinks(baseDir)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	resolved, err := filepath.EvalSymlinks(fileName)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return resolved, isInside(base, resolved), nil
+}
+
+// Whether a cleaned path is inside a directory, without climbing out of it with ..
+func isInside(base, fileName string) bool {
+	rel, err := filepath.Rel(base, fileName)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Get the data encoder that turns a container value into a structured reference
+func getContainerEncoder(opts ContainerOptions) dataEncoder {
+	return func(s string) (Value, error) {
+		if s == "" {
+			return Null(), nil
+		}
+
+		ref := ParseContainer(s)
+
+		if opts.BaseDir != "" {
+			if err := ref.Resolve(opts); err != nil {
+				return Value{}, fmt.Errorf("Could not resolve container: %v", err)
+			}
+		}
+
+		// You can never fail to marshal a reference, and it always parses back
+		encoded, _ := json.Marshal(ref)
+
+		return ParseJSON(encoded)
+	}
+}
+
+// StructuredContainer will say whether a field is written as a container reference. That is any field mapped to CONTAINER,
+// and CONTAINER fields without a mapping when Containers is set
+func (fmp *FMPXMLResult) StructuredContainer(f Field) bool {
+	if m, found := fmp.TypeMapping[f.Name]; found {
+		return m.Type == "CONTAINER"
+	}
+
+	return f.Type == "CONTAINER" && fmp.Containers != nil
+}
+
+// Get the container options, even if they weren't set
+func (fmp *FMPXMLResult) getContainerOptions() ContainerOptions {
+	if fmp.Containers == nil {
+		return ContainerOptions{}
+	}
+
+	return *fmp.Containers
+}
@@ -0,0 +1,200 @@
+package fmpxmlresult_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_ParseContainer(t *testing.T) {
+	tests := []struct {
+		input string
+		want  fmpxmlresult.ContainerReference
+	}{
+		{"photo.jpg", fmpxmlresult.ContainerReference{Kind: "image", Filename: "photo.jpg"}},
+		{"report.pdf", fmpxmlresult.ContainerReference{Kind: "file", Filename: "report.pdf"}},
+		{
+			"size:640,480\rimage:Photos/photo.jpg\rimagewin:/C:/Data/Photos/photo.jpg",
+			fmpxmlresult.ContainerReference{Kind: "image", Filename: "photo.jpg", Path: "Photos/photo.jpg", Width: 640, Height: 480},
+		},
+		{
+			"filewin:/C:/Documents/report.pdf",
+			fmpxmlresult.ContainerReference{Kind: "file", Filename: "report.pdf", Path: "C:/Documents/report.pdf"},
+		},
+		{
+			"moviemac:/Macintosh HD/Movies/clip.mov",
+			fmpxmlresult.ContainerReference{Kind: "movie", Filename: "clip.mov", Path: "/Macintosh HD/Movies/clip.mov"},
+		},
+		{
+			"file://server/share/report.pdf",
+			fmpxmlresult.ContainerReference{Kind: "file", Filename: "report.pdf", URL: "file://server/share/report.pdf"},
+		},
+		{
+			"https://example.org/files/clip.mp4?download=1",
+			fmpxmlresult.ContainerReference{Kind: "movie", Filename: "clip.mp4", URL: "https://example.org/files/clip.mp4?download=1"},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, diff := range deep.Equal(fmpxmlresult.ParseContainer(tt.input), tt.want) {
+			t.Errorf("%s: %s", tt.input, diff)
+		}
+	}
+}
+
+func Test_PopulateContainers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "Photos"), 0755); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "Photos", "photo.jpg"), []byte("hello"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	metadata := fmpxmlresult.Metadata{
+		Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Photo", Type: "CONTAINER"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Missing", Type: "CONTAINER"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Empty", Type: "CONTAINER"},
+		},
+	}
+
+	database := fmpxmlresult.Database{
+		DateFormat: "M/d/yyyy",
+		Layout:     "Overview",
+		Name:       "test.fmp12",
+		Records:    1,
+		TimeFormat: "h:mm:ss a",
+	}
+
+	resultSet := fmpxmlresult.ResultSet{
+		Found: 1,
+		Rows: []fmpxmlresult.Row{
+			{ModID: "196", RecordID: "683", Cols: []fmpxmlresult.Col{
+				{Data: []string{"image:Photos/photo.jpg"}},
+				{Data: []string{"file:nowhere.pdf"}},
+				{Data: []string{""}},
+			}},
+		},
+	}
+
+	sample := fmpxmlresult.FMPXMLResult{
+		Database:   &database,
+		Metadata:   &metadata,
+		ResultSet:  &resultSet,
+		Containers: &fmpxmlresult.ContainerOptions{BaseDir: dir, InlineLimit: 10},
+	}
+
+	if err := sample.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	expectedRecords := []map[string]json.RawMessage{
+		{
+			"Photo":   json.RawMessage(`{"kind":"image","filename":"photo.jpg","path":"Photos/photo.jpg","size":5,"sha256":"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824","data":"aGVsbG8="}`),
+			"Missing": json.RawMessage(`{"kind":"file","filename":"nowhere.pdf","path":"nowhere.pdf","missing":true}`),
+			"Empty":   json.RawMessage(`null`),
+		},
+	}
+
+	compareJSON(t, sample.Records, expectedRecords)
+}
+
+func Test_ResolveContainerOutside(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "base")
+	secret := filepath.Join(dir, "secret.txt")
+
+	if err := os.Mkdir(base, 0755); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(base, "inside.txt"), []byte("fine"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A symlink inside the base directory doesn't make the file it points to any less outside
+	if err := os.Symlink(secret, filepath.Join(base, "link.txt")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := os.Symlink(dir, filepath.Join(base, "up")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	opts := fmpxmlresult.ContainerOptions{BaseDir: base, InlineLimit: 100}
+
+	// Every one of these points at the secret file, or somewhere else outside the base directory
+	for _, value := range []string{
+		"file:../secret.txt",
+		"file:sub/../../secret.txt",
+		"filemac:/" + filepath.ToSlash(secret),
+		"filelinux:" + filepath.ToSlash(secret),
+		"filewin:/C:/secret.txt",
+		"file:link.txt",
+		"file:up/secret.txt",
+	} {
+		ref := fmpxmlresult.ParseContainer(value)
+
+		if err := ref.Resolve(opts); err != nil {
+			t.Errorf("%s: %v", value, err)
+			continue
+		}
+
+		if !ref.Missing || ref.Data != "" || ref.Size != nil {
+			t.Errorf("%s was resolved outside the base directory: %+v", value, ref)
+		}
+	}
+
+	if err := os.Symlink(filepath.Join(base, "inside.txt"), filepath.Join(base, "alias.txt")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, value := range []string{"file:sub/../inside.txt", "file:alias.txt"} {
+		ref := fmpxmlresult.ParseContainer(value)
+
+		if err := ref.Resolve(opts); err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if ref.Missing || ref.Data != "ZmluZQ==" {
+			t.Errorf("%s stays inside the base directory but wasn't resolved: %+v", value, ref)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package fmpxmlresult
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file has the derived fields, which are computed from the other fields of each row and written after them
+
+// DerivedField is a value computed for each record, like a full name from the first and last names
+type DerivedField struct {
+	Key        string // The key it is written under, which is used as given
+	Expression string // An expression over the METADATA fields, in the same language as the Filter
+}
+
+// ParseDerivedField will parse a derived field written as key=expression
+func ParseDerivedField(s string) (DerivedField, error) {
+	i := strings.Index(s, "=")
+
+	if i < 0 {
+		return DerivedField{}, fmt.Errorf("Derived field '%s' should be in the form key=expression", s)
+	}
+
+	out := DerivedField{Key: strings.TrimSpace(s[:i]), Expression: s[i+1:]}
+
+	if out.Key == "" {
+		return DerivedField{}, fmt.Errorf("Derived field '%s' has no key", s)
+	}
+
+	return out, nil
+}
+
+// A derived field that has been checked against the METADATA
+type compiledDerived struct {
+	key        string
+	expression *Expression
+}
+
+// Compile the derived fields, making sure each of them has a key of its own
+func (fmp *FMPXMLResult) compileDerived() ([]compiledDerived, error) {
+	if len(fmp.Derived) == 0 {
+		return nil, nil
+	}
+
+	keys, err := fmp.FieldKeys()
+
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]string{}
+
+	for _, id := range fmp.idKeys() {
+		used[id.key] = id.label
+	}
+
+	for i, key := range keys {
+		if key != "" {
+			used[key] = fmt.Sprintf("field '%s'", fmp.Metadata.Fields[i].Name)
+		}
+	}
+
+	out := make([]compiledDerived, len(fmp.Derived))
+
+	for i, d := range fmp.Derived {
+		if d.Key == "" {
+			return nil, fmt.Errorf("Derived field %d has no key", i)
+		}
+
+		if other, found := used[d.Key]; found {
+			return nil, fmt.Errorf("Derived field '%s' has the same key as %s", d.Key, other)
+		}
+
+		used[d.Key] = fmt.Sprintf("derived field '%s'", d.Key)
+
+		expression, err := fmp.CompileExpression(d.Expression)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid derived field '%s': %v", d.Key, err)
+		}
+
+		out[i] = compiledDerived{d.Key, expression}
+	}
+
+	return out, nil
+}
+
+// DerivedOutput is a derived field along with the type of value its expression produces
+type DerivedOutput struct {
+	Key    string
+	Type   string // The FileMaker type the values are written like, or JSON when that is only known once a row is read
+	Repeat bool   // Whether each value is an array of Type, like a repeating field
+}
+
+// DerivedOutputs will get the derived fields with their types, in the order they're written, for writers that need a type for every key
+func (fmp *FMPXMLResult) DerivedOutputs() ([]DerivedOutput, error) {
+	derived, err := fmp.compileDerived()
+
+	if err != nil {
+		return nil, err
+	}
+
+	out := []DerivedOutput{}
+
+	for _, d := range derived {
+		t := d.expression.typ
+		output := DerivedOutput{Key: d.key, Type: kindType(t.kind)}
+
+		if !t.any && t.kind == ArrayKind {
+			output.Type, output.Repeat = kindType(t.item), true
+		}
+
+		if t.any {
+			output.Type = "JSON"
+		}
+
+		out = append(out, output)
+	}
+
+	return out, nil
+}
+
+// The FileMaker type a kind is written like. The null literal has no type, so it is text, and anything else can only be JSON
+func kindType(k Kind) string {
+	if k == NullKind {
+		return "TEXT"
+	}
+
+	if fmType, found := kindTypes[k]; found {
+		return fmType
+	}
+
+	return "JSON"
+}
+
+// The keys of the derived fields, in the order they're written
+func (fmp *FMPXMLResult) derivedKeys() []string {
+	out := []string{}
+
+	for _, d := range fmp.Derived {
+		out = append(out, d.Key)
+	}
+
+	return out
+}
+
+// Add the derived values to a record. The context is shared so fields used by several of them are only encoded once
+func addDerived(record Record, derived []compiledDerived, ctx *evalContext) error {
+	for _, d := range derived {
+		value, err := d.expression.root.eval(ctx)
+
+		if err != nil {
+			return fmt.Errorf("Derived field '%s': %v", d.key, err)
+		}
+
+		record[d.key] = value
+	}
+
+	return nil
+}
+
+// Derived values can always be null, since any field they use can be empty
+func (fmp *FMPXMLResult) derivedSchema(t exprType) *Schema {
+	if t.any || t.kind == NullKind {
+		return &Schema{}
+	}
+
+	if t.kind == ArrayKind {
+		return &Schema{Type: []string{"array", "null"}, Items: fmp.derivedSchema(typeOf(t.item))}
+	}
+
+	return nullable(fmp.valueSchema(kindTypes[t.kind]))
+}
+
+// The FileMaker type each kind of value is written like
+var kindTypes = map[Kind]string{
+	StringKind:    "TEXT",
+	NumberKind:    "NUMBER",
+	BoolKind:      "BOOLEAN",
+	DateKind:      "DATE",
+	TimeKind:      "TIME",
+	TimestampKind: "TIMESTAMP",
+}
@@ -0,0 +1,138 @@
+package fmpxmlresult_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_ParseDerivedField(t *testing.T) {
+	have, err := fmpxmlresult.ParseDerivedField(`loud = upper(Status) == "ACTIVE"`)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal(have, fmpxmlresult.DerivedField{Key: "loud", Expression: ` upper(Status) == "ACTIVE"`}) {
+		t.Error(diff)
+	}
+
+	for _, input := range []string{"upper(Status)", "=upper(Status)"} {
+		if _, err := fmpxmlresult.ParseDerivedField(input); err == nil {
+			t.Errorf("No error for %s", input)
+		}
+	}
+}
+
+func Test_PopulateDerived(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Name: "test.fmp12", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Status", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "First Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Modified", Type: "DATE"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Amount", Type: "NUMBER"},
+			{EmptyOK: true, MaxRepeat: 2, Name: "Tags", Type: "TEXT"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Found: 2, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Active"}}, {Data: []string{"Ann"}}, {Data: []string{"3/15/2024"}}, {Data: []string{"12.5"}}, {Data: []string{"red", "blue"}}}},
+			{RecordID: "2", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Inactive"}}, {}, {}, {}, {}}},
+		}},
+	}
+
+	sample.RecordIDField = "id"
+	sample.IDFieldsPosition = fmpxmlresult.IDFieldsLast
+	sample.Projection = fmpxmlresult.Projection{Include: []string{"First Name"}}
+	sample.Derived = []fmpxmlresult.DerivedField{
+		{Key: "greeting", Expression: "concat(\"Hi \", `First Name`)"},
+		{Key: "due", Expression: `addDays(Modified, 30)`},
+		{Key: "tagged", Expression: `length(Tags) > 0`},
+	}
+
+	if err := sample.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Derived fields come after the rest of the fields, and null propagates where the source is empty
+	compareJSON(t, sample.OrderedRecord(sample.Records[0]), json.RawMessage(`{"First Name":"Ann","greeting":"Hi Ann","due":"2024-04-14","tagged":true,"id":"1"}`))
+	compareJSON(t, sample.OrderedRecord(sample.Records[1]), json.RawMessage(`{"First Name":null,"greeting":"Hi ","due":null,"tagged":false,"id":"2"}`))
+
+	schema, err := sample.RecordSchema(fmpxmlresult.SchemaOptions{})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal(schema.Required, []string{"First Name", "greeting", "due", "tagged", "id"}) {
+		t.Error(diff)
+	}
+
+	compareJSON(t, schema.Properties["due"], json.RawMessage(`{"type":["string","null"],"format":"date"}`))
+
+	// A derived field has to have a key of its own
+	for _, key := range []string{"First Name", "id", "greeting"} {
+		sample.Derived = []fmpxmlresult.DerivedField{{Key: "greeting", Expression: `"Hi"`}, {Key: key, Expression: `1`}}
+
+		if err := sample.PopulateRecords(); err == nil {
+			t.Errorf("Err is nil for a derived field on key '%s'", key)
+		}
+	}
+
+	sample.Derived = []fmpxmlresult.DerivedField{{Key: "broken", Expression: `upper(Amount)`}}
+
+	if _, err := sample.RecordSchema(fmpxmlresult.SchemaOptions{}); err == nil {
+		t.Error("Err is nil for a derived field that doesn't type check")
+	}
+}
+
+func Test_DerivedOutputs(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Name: "test.fmp12", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Status", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "First Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Modified", Type: "DATE"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Amount", Type: "NUMBER"},
+			{EmptyOK: true, MaxRepeat: 2, Name: "Tags", Type: "TEXT"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{},
+	}
+	sample.Derived = []fmpxmlresult.DerivedField{
+		{Key: "name", Expression: "lower(`First Name`)"},
+		{Key: "double", Expression: "Amount * 2"},
+		{Key: "modified", Expression: "Modified"},
+		{Key: "tags", Expression: "Tags"},
+		{Key: "nothing", Expression: "null"},
+	}
+
+	have, err := sample.DerivedOutputs()
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []fmpxmlresult.DerivedOutput{
+		{Key: "name", Type: "TEXT"},
+		{Key: "double", Type: "NUMBER"},
+		{Key: "modified", Type: "DATE"},
+		{Key: "tags", Type: "TEXT", Repeat: true},
+		{Key: "nothing", Type: "TEXT"},
+	}
+
+	for _, diff := range deep.Equal(have, expected) {
+		t.Error(diff)
+	}
+
+	// Reserved keys can't be used by derived fields, like the ID fields
+	sample.ReservedKeys = []string{"double"}
+
+	if _, err := sample.DerivedOutputs(); err == nil {
+		t.Error("Err is nil for a derived field on a reserved key")
+	}
+}
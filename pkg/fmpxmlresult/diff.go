@@ -0,0 +1,190 @@
+package fmpxmlresult
+
+import (
+	"fmt"
+)
+
+// This file has the record level diff between two exports of the same table
+
+// The kinds of change a record can have between two exports
+const (
+	ChangeAdded     = "added"
+	ChangeDeleted   = "deleted"
+	ChangeModified  = "modified"
+	ChangeUnchanged = "unchanged"
+)
+
+// DiffOptions controls how two exports are compared
+type DiffOptions struct {
+	KeyField         string // The field to match records on. If empty, records are matched on their record ID
+	IncludeUnchanged bool   // Whether to produce changes for records that didn't change
+}
+
+// FieldChange is the old and new value of a single field in a modified record
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   Value  `json:"old"`
+	New   Value  `json:"new"`
+}
+
+// Change is what happened to a single record between two exports
+type Change struct {
+	Type     string        `json:"type"`
+	Key      string        `json:"key"`
+	RecordID string        `json:"recordID"`
+	OldModID string        `json:"oldModID,omitempty"`
+	NewModID string        `json:"newModID,omitempty"`
+	Fields   []FieldChange `json:"fields,omitempty"`
+	Record   Value         `json:"record"` // The new record, or the old one if it was deleted, with its keys in RecordKeys order
+}
+
+// Diff will compare two exports of the same table. Records must already be populated on both.
+// Changes come out in the order of the new export, followed by the deleted records in the order of the old one.
+// If the record and modification IDs both match, a record is unchanged without looking any further
+func Diff(before, after *FMPXMLResult, opts DiffOptions) ([]Change, error) {
+	oldKeys, err := before.diffKeys(opts.KeyField)
+
+	if err != nil {
+		return nil, fmt.Errorf("Old export: %v", err)
+	}
+
+	newKeys, err := after.diffKeys(opts.KeyField)
+
+	if err != nil {
+		return nil, fmt.Errorf("New export: %v", err)
+	}
+
+	// The key order is the same for every record, so it is only worked out once
+	oldOrder, newOrder := before.RecordKeys(), after.RecordKeys()
+
+	oldPositions := map[string]int{}
+
+	for i, key := range oldKeys {
+		oldPositions[key] = i
+	}
+
+	out := []Change{}
+	seen := map[string]bool{}
+
+	for i, key := range newKeys {
+		newRow := after.ResultSet.Rows[i]
+		newRecord := after.Records[i]
+		ordered := OrderRecord(newRecord, newOrder)
+
+		seen[key] = true
+
+		j, found := oldPositions[key]
+
+		if !found {
+			out = append(out, Change{Type: ChangeAdded, Key: key, RecordID: newRow.RecordID, NewModID: newRow.ModID, Record: ordered})
+			continue
+		}
+
+		oldRow := before.ResultSet.Rows[j]
+
+		change := Change{Type: ChangeUnchanged, Key: key, RecordID: newRow.RecordID, OldModID: oldRow.ModID, NewModID: newRow.ModID, Record: ordered}
+
+		if oldRow.RecordID != newRow.RecordID || oldRow.ModID != newRow.ModID {
+			change.Fields = diffRecords(before, after, before.Records[j], newRecord, oldOrder, newOrder)
+
+			if len(change.Fields) > 0 || (oldRow.RecordID == newRow.RecordID && oldRow.ModID != newRow.ModID) {
+				change.Type = ChangeModified
+			}
+		}
+
+		if change.Type == ChangeUnchanged && !opts.IncludeUnchanged {
+			continue
+		}
+
+		out = append(out, change)
+	}
+
+	for j, key := range oldKeys {
+		if seen[key] {
+			continue
+		}
+
+		oldRow := before.ResultSet.Rows[j]
+
+		out = append(out, Change{Type: ChangeDeleted, Key: key, RecordID: oldRow.RecordID, OldModID: oldRow.ModID, Record: OrderRecord(before.Records[j], oldOrder)})
+	}
+
+	return out, nil
+}
+
+// Get the key for every row, making sure no key is used twice
+func (fmp *FMPXMLResult) diffKeys(keyField string) ([]string, error) {
+	if len(fmp.Records) != len(fmp.ResultSet.Rows) {
+		return nil, fmt.Errorf("Records have not been populated")
+	}
+
+	out := make([]string, len(fmp.Records))
+	seen := map[string]bool{}
+
+	for i, record := range fmp.Records {
+		key := fmp.ResultSet.Rows[i].RecordID
+
+		if keyField != "" {
+			value, found := record[keyField]
+
+			if !found {
+				return nil, fmt.Errorf("Key field '%s' not found", keyField)
+			}
+
+			key = keyString(value)
+		}
+
+		if seen[key] {
+			return nil, fmt.Errorf("Duplicate key '%s' in row %d", key, i)
+		}
+
+		seen[key] = true
+		out[i] = key
+	}
+
+	return out, nil
+}
+
+// Keys are compared as strings: null is "null", and anything else is its plain text form
+func keyString(value Value) string {
+	if value.IsNull() {
+		return "null"
+	}
+
+	return value.String()
+}
+
+// Get the field level changes between two versions of a record, leaving out the record and modification IDs.
+// They come in the order the new export writes its keys, followed by any keys only the old one had
+func diffRecords(before, after *FMPXMLResult, oldRecord, newRecord Record, oldOrder, newOrder []string) []FieldChange {
+	ids := map[string]bool{}
+
+	for _, name := range []string{before.RecordIDField, before.ModIDField, after.RecordIDField, after.ModIDField} {
+		ids[name] = true
+	}
+
+	names := []string{}
+	seen := map[string]bool{}
+
+	for _, ordered := range []Value{OrderRecord(newRecord, newOrder), OrderRecord(oldRecord, oldOrder)} {
+		for _, member := range ordered.Members() {
+			if !seen[member.Key] && !ids[member.Key] {
+				seen[member.Key] = true
+				names = append(names, member.Key)
+			}
+		}
+	}
+
+	out := []FieldChange{}
+
+	for _, name := range names {
+		// A missing field is the zero value, which is null
+		oldValue, newValue := oldRecord[name], newRecord[name]
+
+		if !oldValue.Equal(newValue) {
+			out = append(out, FieldChange{Field: name, Old: oldValue, New: newValue})
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,179 @@
+package fmpxmlresult_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_Diff(t *testing.T) {
+	metadata := fmpxmlresult.Metadata{
+		Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Number", Type: "NUMBER"},
+		},
+	}
+
+	database := fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", Records: 3, TimeFormat: "h:mm:ss a"}
+
+	before := &fmpxmlresult.FMPXMLResult{
+		Database: &database,
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Found: 3, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Ann"}}, {Data: []string{"1"}}}},
+			{RecordID: "2", ModID: "9", Cols: []fmpxmlresult.Col{{Data: []string{"Bob"}}, {Data: []string{"2"}}}},
+			{RecordID: "3", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Cat"}}, {Data: []string{"3"}}}},
+		}},
+	}
+
+	after := &fmpxmlresult.FMPXMLResult{
+		Database: &database,
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Found: 3, Rows: []fmpxmlresult.Row{
+			{RecordID: "4", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Dan"}}, {Data: []string{"4"}}}},
+			{RecordID: "2", ModID: "10", Cols: []fmpxmlresult.Col{{Data: []string{"Bobby"}}, {Data: []string{"2"}}}},
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Ann"}}, {Data: []string{"1"}}}},
+		}},
+	}
+
+	for _, fmp := range []*fmpxmlresult.FMPXMLResult{before, after} {
+		fmp.ModIDField = "modID"
+
+		if err := fmp.PopulateRecords(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	changes, err := fmpxmlresult.Diff(before, after, fmpxmlresult.DiffOptions{})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []fmpxmlresult.Change{
+		{Type: "added", Key: "4", RecordID: "4", NewModID: "1", Record: after.OrderedRecord(after.Records[0])},
+		{Type: "modified", Key: "2", RecordID: "2", OldModID: "9", NewModID: "10", Record: after.OrderedRecord(after.Records[1]), Fields: []fmpxmlresult.FieldChange{
+			{Field: "Name", Old: fmpxmlresult.NewString("Bob"), New: fmpxmlresult.NewString("Bobby")},
+		}},
+		{Type: "deleted", Key: "3", RecordID: "3", OldModID: "1", Record: before.OrderedRecord(before.Records[2])},
+	}
+
+	compareJSON(t, changes, expected)
+
+	// Matching on a field instead should give the same result, with the unchanged record included
+	changes, err = fmpxmlresult.Diff(before, after, fmpxmlresult.DiffOptions{KeyField: "Number", IncludeUnchanged: true})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	types := []string{}
+
+	for _, change := range changes {
+		types = append(types, change.Type+" "+change.Key)
+	}
+
+	for _, diff := range deep.Equal(types, []string{"added 4", "modified 2", "unchanged 1", "deleted 3"}) {
+		t.Error(diff)
+	}
+}
+
+func Test_DiffOrder(t *testing.T) {
+	metadata := fmpxmlresult.Metadata{
+		Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Zip", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "City", Type: "TEXT"},
+		},
+	}
+
+	database := fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", Records: 1, TimeFormat: "h:mm:ss a"}
+
+	before := &fmpxmlresult.FMPXMLResult{
+		Database: &database,
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Found: 1, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"02134"}}, {Data: []string{"Allston"}}}},
+		}},
+	}
+
+	after := &fmpxmlresult.FMPXMLResult{
+		Database: &database,
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Found: 1, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "6", Cols: []fmpxmlresult.Col{{Data: []string{"02135"}}, {Data: []string{"Boston"}}}},
+		}},
+	}
+
+	for _, fmp := range []*fmpxmlresult.FMPXMLResult{before, after} {
+		fmp.RecordIDField = "id"
+		fmp.IDFieldsPosition = fmpxmlresult.IDFieldsLast
+
+		if err := fmp.PopulateRecords(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	changes, err := fmpxmlresult.Diff(before, after, fmpxmlresult.DiffOptions{})
+
+	if err != nil || len(changes) != 1 {
+		t.Errorf("Expected one change, got %v, %v", changes, err)
+		return
+	}
+
+	// Both the record and its field changes follow the field order, rather than being sorted
+	compareJSON(t, changes[0].Record, json.RawMessage(`{"Zip":"02135","City":"Boston","id":"1"}`))
+	compareJSON(t, changes[0].Fields, json.RawMessage(`[{"field":"Zip","old":"02134","new":"02135"},{"field":"City","old":"Allston","new":"Boston"}]`))
+}
+
+func Test_DiffErrors(t *testing.T) {
+	metadata := fmpxmlresult.Metadata{
+		Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Name", Type: "TEXT"},
+		},
+	}
+
+	database := fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", Records: 2, TimeFormat: "h:mm:ss a"}
+
+	before := &fmpxmlresult.FMPXMLResult{
+		Database: &database,
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Found: 2, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Ann"}}}},
+			{RecordID: "1", ModID: "6", Cols: []fmpxmlresult.Col{{Data: []string{"Annie"}}}},
+		}},
+	}
+
+	after := &fmpxmlresult.FMPXMLResult{
+		Database: &database,
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Found: 1, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Ann"}}}},
+		}},
+	}
+
+	// Records haven't been populated yet
+	if _, err := fmpxmlresult.Diff(before, after, fmpxmlresult.DiffOptions{}); err == nil {
+		t.Error("Err is nil for unpopulated records")
+	}
+
+	for _, fmp := range []*fmpxmlresult.FMPXMLResult{before, after} {
+		if err := fmp.PopulateRecords(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if _, err := fmpxmlresult.Diff(before, after, fmpxmlresult.DiffOptions{}); err == nil {
+		t.Error("Err is nil for duplicate record IDs")
+	}
+
+	if _, err := fmpxmlresult.Diff(after, after, fmpxmlresult.DiffOptions{KeyField: "Missing"}); err == nil {
+		t.Error("Err is nil for a missing key field")
+	}
+}
@@ -1,19 +1,30 @@
 package fmpxmlresult
 
 import (
-	"encoding/json"
 	"fmt"
 
 	"github.com/hovercross/fmpxml-to-json/pkg/timeconv"
 )
 
 // A fieldEncoder will take an entire column, which may or may not have repeating <DATA> elements,
-// and put it into a single JSON value or an array, based on the MAXREPEAT values
-type fieldEncoder func([]string) (json.RawMessage, error)
+// and put it into a single value or an array, based on the MAXREPEAT values
+type fieldEncoder func([]string) (Value, error)
 
 // PopulateRecords will create all the easy to read record data
 func (fmp *FMPXMLResult) PopulateRecords() error {
-	fmp.populateFieldEncoders()
+	if err := fmp.populateFieldEncoders(); err != nil {
+		return err
+	}
+
+	if err := fmp.filterRows(); err != nil {
+		return err
+	}
+
+	derived, err := fmp.compileDerived()
+
+	if err != nil {
+		return err
+	}
 
 	// Empty out our record destination, and allocate it in a single go
 	fmp.Records = make([]Record, len(fmp.ResultSet.Rows))
@@ -22,13 +33,11 @@ func (fmp *FMPXMLResult) PopulateRecords() error {
 		record := Record{}
 
 		if fmp.RecordIDField != "" {
-			recordID, _ := json.Marshal(row.RecordID) // You can never fail to marshal a string
-			record[fmp.RecordIDField] = recordID
+			record[fmp.RecordIDField] = NewString(row.RecordID)
 		}
 
 		if fmp.ModIDField != "" {
-			modID, _ := json.Marshal(row.ModID) // You can never fail to marshal a string
-			record[fmp.ModIDField] = modID
+			record[fmp.ModIDField] = NewString(row.ModID)
 		}
 
 		if len(row.Cols) != len(fmp.positionalColumnData) {
@@ -41,6 +50,11 @@ func (fmp *FMPXMLResult) PopulateRecords() error {
 			encoder := positionalItem.encoder
 			name := positionalItem.name
 
+			// Projected out, so never even looked at
+			if encoder == nil {
+				continue
+			}
+
 			encoded, err := encoder(col.Data)
 
 			if err != nil {
@@ -50,24 +64,47 @@ func (fmp *FMPXMLResult) PopulateRecords() error {
 			record[name] = encoded
 		}
 
+		if err := addDerived(record, derived, &evalContext{row: row, values: map[int]Value{}}); err != nil {
+			return fmt.Errorf("Unable to derive row %d: %v", i, err)
+		}
+
 		fmp.Records[i] = record
 	}
 
 	return nil
 }
 
-func (fmp *FMPXMLResult) populateFieldEncoders() {
+func (fmp *FMPXMLResult) populateFieldEncoders() error {
 	fmp.populateDataEncoders()
 
+	keys, warnings, err := fmp.fieldKeys()
+
+	if err != nil {
+		return err
+	}
+
+	fmp.Warnings = warnings
 	fmp.positionalColumnData = make([]columnarData, len(fmp.Metadata.Fields))
 
 	// Load each of the encoders
 	for i, field := range fmp.Metadata.Fields {
+		if keys[i] == "" {
+			continue
+		}
+
+		encoder, err := fmp.getEncoder(field)
+
+		if err != nil {
+			return fmt.Errorf("Unable to get encoder for field '%s': %v", field.Name, err)
+		}
+
 		fmp.positionalColumnData[i] = columnarData{
-			fmp.getEncoder(field),
-			field.Name,
+			encoder,
+			keys[i],
 		}
 	}
+
+	return nil
 }
 
 func (fmp *FMPXMLResult) populateDataEncoders() {
@@ -77,15 +114,38 @@ func (fmp *FMPXMLResult) populateDataEncoders() {
 
 	// The specific datum normalizers we will be using for this file
 	fmp.dataEncoders = map[string]dataEncoder{
-		"DATE":      getTimeEncoder(dateFormat, "2006-01-02"),
-		"TIME":      getTimeEncoder(timeFormat, "15:04:05"),
-		"TIMESTAMP": getTimeEncoder(timestampFormat, "2006-01-02T15:04:05"),
+		"DATE":      getTimeEncoder(dateFormat, DateKind),
+		"TIME":      getTimeEncoder(timeFormat, TimeKind),
+		"TIMESTAMP": getTimeEncoder(timestampFormat, TimestampKind),
 		"NUMBER":    encodeNumber,
 	}
+
+	if fmp.Containers != nil {
+		fmp.dataEncoders["CONTAINER"] = getContainerEncoder(*fmp.Containers)
+	}
 }
 
 // Get the encoder for a given field
-func (fmp *FMPXMLResult) getEncoder(f Field) fieldEncoder {
+func (fmp *FMPXMLResult) getEncoder(f Field) (fieldEncoder, error) {
+	dn, err := fmp.getDataEncoder(f)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if f.MaxRepeat == 1 {
+		return getScalarEncoder(dn), nil
+	}
+
+	return getArrayEncoder(dn), nil
+}
+
+// Get the encoder for a single datum of the given field. A type mapping always wins over the declared type
+func (fmp *FMPXMLResult) getDataEncoder(f Field) (dataEncoder, error) {
+	if m, found := fmp.TypeMapping[f.Name]; found {
+		return fmp.getMappedEncoder(m)
+	}
+
 	var dn dataEncoder = encodeString // Just a default
 
 	// Override the string encoder, if we have a more appropriate encoder
@@ -93,9 +153,5 @@ func (fmp *FMPXMLResult) getEncoder(f Field) fieldEncoder {
 		dn = v
 	}
 
-	if f.MaxRepeat == 1 {
-		return getScalarEncoder(dn)
-	}
-
-	return getArrayEncoder(dn)
+	return dn, nil
 }
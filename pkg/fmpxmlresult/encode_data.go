@@ -1,74 +1,116 @@
 package fmpxmlresult
 
 import (
-	"encoding/json"
 	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
-// This file has all the translation functions for strings/numbers/dates/etc to raw JSON
+// This file has all the translation functions for strings/numbers/dates/etc to values
 
-// a dataEncoder will convert a single string stored within a <DATA> element into an appropriate JSON value
-type dataEncoder func(string) (json.RawMessage, error)
+// a dataEncoder will convert a single string stored within a <DATA> element into an appropriate value
+type dataEncoder func(string) (Value, error)
 
 // The default string based encoder
-func encodeString(s string) (json.RawMessage, error) {
-	return json.Marshal(s)
+func encodeString(s string) (Value, error) {
+	return NewString(s), nil
 }
 
-func getTimeEncoder(inFormat, outFormat string) dataEncoder {
-	out := func(s string) (json.RawMessage, error) {
+// getTimeEncoder will parse with the given layout, into a value of the given kind
+func getTimeEncoder(inFormat string, kind Kind) dataEncoder {
+	out := func(s string) (Value, error) {
 		dt, err := time.Parse(inFormat, s)
 
 		if err != nil {
-			return nil, err
+			return Value{}, err
 		}
 
-		output := dt.Format(outFormat)
-
-		// Swallow the error: marshaling a string can never fail
-		encoded, _ := json.Marshal(output)
-
-		return json.RawMessage(encoded), nil
+		return Value{kind: kind, time: dt}, nil
 	}
 
 	return out
 }
 
-// Run through all the available number parsers to try to get a valid value out
-func encodeNumber(s string) (json.RawMessage, error) {
-	type parser func(string) (json.RawMessage, error)
+// A decimal number as Filemaker might export it: an optional sign, digits with an optional decimal point, and an optional exponent
+var numberPattern = regexp.MustCompile(`^([+-]?)([0-9]*)(?:\.([0-9]*))?([eE][+-]?[0-9]+)?$`)
+
+// Numbers keep the digits they were exported with, so they never lose precision. Only what JSON doesn't allow is tidied up:
+// a plus sign, leading zeros, and a decimal point without digits on one side
+func encodeNumber(s string) (Value, error) {
+	match := numberPattern.FindStringSubmatch(s)
+
+	if match == nil || match[2]+match[3] == "" {
+		return Value{}, fmt.Errorf("Could not numerically parse %s", s)
+	}
+
+	sign, whole, fraction, exponent := match[1], strings.TrimLeft(match[2], "0"), match[3], match[4]
+
+	if sign == "+" {
+		sign = ""
+	}
+
+	if whole == "" {
+		whole = "0"
+	}
+
+	decimal := sign + whole
 
-	for _, f := range []parser{encodeInt, encodeFloat} {
-		val, err := f(s)
+	if fraction != "" {
+		decimal += "." + fraction
+	}
+
+	return NewNumber(decimal + exponent)
+}
 
-		if err == nil {
-			return val, nil
+// Wrap a data encoder so empty strings become null rather than a parse failure.
+// This is used for overridden TEXT fields, where an empty value is common and not an error
+func nullIfEmpty(f dataEncoder) dataEncoder {
+	return func(s string) (Value, error) {
+		if s == "" {
+			return Null(), nil
 		}
+
+		return f(s)
 	}
+}
+
+// Filemaker doesn't have a real boolean, so accept the usual suspects along with any number
+func encodeBoolean(s string) (Value, error) {
+	v, err := parseBoolean(s)
 
-	return nil, fmt.Errorf("Could not numerically parse %s", s)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return NewBool(v), nil
 }
 
-// int to raw JSON or error
-func encodeInt(s string) (json.RawMessage, error) {
-	v, err := strconv.ParseInt(s, 0, 64)
+func parseBoolean(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "t", "yes", "y":
+		return true, nil
+	case "false", "f", "no", "n":
+		return false, nil
+	}
+
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
 
 	if err != nil {
-		return nil, err
+		return false, fmt.Errorf("Could not interpret %s as a boolean", s)
 	}
 
-	return json.RawMessage(strconv.FormatInt(v, 10)), nil
+	return v != 0, nil
 }
 
-// float to raw JSON or error
-func encodeFloat(s string) (json.RawMessage, error) {
-	v, err := strconv.ParseFloat(s, 64)
+// Pass a JSON document stored in a text field through, keeping its member order and numbers as written
+func encodeJSON(s string) (Value, error) {
+	out, err := ParseJSON([]byte(s))
 
 	if err != nil {
-		return nil, err
+		return Value{}, fmt.Errorf("Invalid JSON: %v", err)
 	}
 
-	return json.RawMessage(fmt.Sprint(v)), nil
+	return out, nil
 }
@@ -0,0 +1,53 @@
+package fmpxmlresult_test
+
+import (
+	"testing"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_DecimalNumbers(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", Records: 1, TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Number", Type: "NUMBER"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Found: 1, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Ann"}}, {Data: []string{"1"}}}},
+		}},
+	}
+
+	// Leading zeros don't make a number octal
+	// The digits are kept as exported, and only tidied up where JSON needs it
+	for input, want := range map[string]string{
+		"010":                             "10",
+		"08":                              "8",
+		"-007":                            "-7",
+		"+3":                              "3",
+		".5":                              "0.5",
+		"5.":                              "5",
+		"12.50":                           "12.50",
+		"1.5E+3":                          "1.5E+3",
+		"12345678901234567890.0123456789": "12345678901234567890.0123456789",
+	} {
+		sample.ResultSet.Rows[0].Cols[1].Data = []string{input}
+
+		if err := sample.PopulateRecords(); err != nil {
+			t.Errorf("%s: %v", input, err)
+			continue
+		}
+
+		if have := sample.Records[0]["Number"].Decimal(); have != want {
+			t.Errorf("%s: expected %s, got %s", input, want, have)
+		}
+	}
+
+	for _, input := range []string{"0x1F", "0b11", "1_000", "NaN", "Inf", ".", "-", "1e", " 1"} {
+		sample.ResultSet.Rows[0].Cols[1].Data = []string{input}
+
+		if err := sample.PopulateRecords(); err == nil {
+			t.Errorf("Err is nil for %s", input)
+		}
+	}
+}
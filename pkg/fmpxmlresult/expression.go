@@ -0,0 +1,717 @@
+package fmpxmlresult
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file has the expression language used to filter rows and derive fields. Expressions refer to fields by their METADATA name,
+// either bare like Status or Contacts::Email, or in backticks like `First Name`, and are type checked against the
+// METADATA before any rows are read
+
+// Expression is a compiled expression, checked against the fields of an export
+type Expression struct {
+	source string
+	root   node
+	typ    exprType
+}
+
+// The static type of an expression
+type exprType struct {
+	kind Kind // NullKind for the null literal
+	item Kind // The kind of the items, for arrays
+	any  bool // JSON and structured container fields, whose kind is only known once a row is read
+}
+
+var anyType = exprType{any: true}
+
+func typeOf(k Kind) exprType {
+	return exprType{kind: k}
+}
+
+func (t exprType) String() string {
+	if t.any {
+		return "any"
+	}
+
+	if t.kind == ArrayKind {
+		return "array of " + t.item.String()
+	}
+
+	return t.kind.String()
+}
+
+// Whether a value of this type can be used where the kind is expected. Null fits anywhere
+func (t exprType) is(k Kind) bool {
+	return t.any || t.kind == k || t.kind == NullKind
+}
+
+// The type that covers both, for things like the branches of if()
+func unify(a, b exprType) (exprType, bool) {
+	switch {
+	case a.any || b.any:
+		return anyType, true
+	case a.kind == NullKind:
+		return b, true
+	case b.kind == NullKind, a == b:
+		return a, true
+	}
+
+	return exprType{}, false
+}
+
+// The type two sides are compared as. A date can be compared with a timestamp, as midnight at the start of the day
+func comparisonType(a, b exprType) (exprType, bool) {
+	date, timestamp := typeOf(DateKind), typeOf(TimestampKind)
+
+	if (a == date && b == timestamp) || (a == timestamp && b == date) {
+		return timestamp, true
+	}
+
+	return unify(a, b)
+}
+
+// A node of the parsed expression
+type node interface {
+	check(c *checker) (exprType, error)
+	eval(ctx *evalContext) (Value, error)
+}
+
+// The checker resolves field names against the METADATA while types are checked
+type checker struct {
+	fmp *FMPXMLResult
+}
+
+// The evaluation context holds a single row, and the fields already encoded from it
+type evalContext struct {
+	row    Row
+	values map[int]Value
+}
+
+// CompileExpression will parse an expression and check it against the fields of the export
+func (fmp *FMPXMLResult) CompileExpression(source string) (*Expression, error) {
+	if fmp.Metadata == nil {
+		return nil, fmt.Errorf("There is no metadata to check the expression against")
+	}
+
+	root, err := parseExpression(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fmp.populateDataEncoders()
+
+	typ, err := root.check(&checker{fmp: fmp})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Expression{source: source, root: root, typ: typ}, nil
+}
+
+// String will get the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Type will describe the kind of value the expression produces, like "string" or "array of date".
+// It is "any" when the kind is only known once a row is read
+func (e *Expression) Type() string {
+	return e.typ.String()
+}
+
+// Evaluate will get the value of the expression for a row
+func (e *Expression) Evaluate(row Row) (Value, error) {
+	return e.root.eval(&evalContext{row: row, values: map[int]Value{}})
+}
+
+// Keep only the rows the filter is true for, before any records are built from them. The filtered rows get a result set of their own,
+// so the one that was read in is left alone, and filtering again starts over from it
+func (fmp *FMPXMLResult) filterRows() error {
+	if fmp.filtered != nil && fmp.ResultSet == fmp.filtered {
+		fmp.ResultSet = fmp.unfiltered
+	}
+
+	fmp.filteredRows = nil
+	fmp.unfiltered = nil
+	fmp.filtered = nil
+
+	if fmp.Filter == "" {
+		return nil
+	}
+
+	filter, err := fmp.CompileExpression(fmp.Filter)
+
+	if err != nil {
+		return fmt.Errorf("Invalid filter: %v", err)
+	}
+
+	if !filter.typ.is(BoolKind) {
+		return fmt.Errorf("Invalid filter: it must be a boolean, not %s", filter.typ)
+	}
+
+	kept := []Row{}
+	dropped := []Row{}
+
+	for i, row := range fmp.ResultSet.Rows {
+		v, err := filter.Evaluate(row)
+
+		if err != nil {
+			return fmt.Errorf("Unable to filter row %d: %v", i, err)
+		}
+
+		if v.Bool() {
+			kept = append(kept, row)
+		} else {
+			dropped = append(dropped, row)
+		}
+	}
+
+	fmp.unfiltered = fmp.ResultSet
+	fmp.filtered = &ResultSet{Found: len(kept), Rows: kept}
+	fmp.ResultSet = fmp.filtered
+	fmp.filteredRows = dropped
+
+	return nil
+}
+
+// The type a field has in expressions, which follows the type it is written as
+func (fmp *FMPXMLResult) fieldType(f Field) exprType {
+	var k Kind
+
+	switch fmp.OutputType(f) {
+	case "NUMBER":
+		k = NumberKind
+	case "DATE":
+		k = DateKind
+	case "TIME":
+		k = TimeKind
+	case "TIMESTAMP":
+		k = TimestampKind
+	case "BOOLEAN":
+		k = BoolKind
+	case "JSON":
+		return anyType
+	case "CONTAINER":
+		if fmp.StructuredContainer(f) {
+			return anyType
+		}
+
+		k = StringKind
+	default:
+		k = StringKind
+	}
+
+	if f.MaxRepeat > 1 {
+		return exprType{kind: ArrayKind, item: k}
+	}
+
+	return typeOf(k)
+}
+
+type literalNode struct {
+	value Value
+}
+
+func (n *literalNode) check(c *checker) (exprType, error) {
+	return typeOf(n.value.Kind()), nil
+}
+
+func (n *literalNode) eval(ctx *evalContext) (Value, error) {
+	return n.value, nil
+}
+
+type fieldNode struct {
+	name    string
+	pos     int
+	index   int
+	encoder fieldEncoder
+}
+
+func (n *fieldNode) check(c *checker) (exprType, error) {
+	found := -1
+
+	for i, field := range c.fmp.Metadata.Fields {
+		if field.Name != n.name {
+			continue
+		}
+
+		if found >= 0 {
+			return exprType{}, fmt.Errorf("Field '%s' at column %d is on the layout more than once", n.name, n.pos+1)
+		}
+
+		found = i
+	}
+
+	if found < 0 {
+		return exprType{}, fmt.Errorf("Unknown field '%s' at column %d", n.name, n.pos+1)
+	}
+
+	field := c.fmp.Metadata.Fields[found]
+	encoder, err := c.fmp.getEncoder(field)
+
+	if err != nil {
+		return exprType{}, fmt.Errorf("Unable to get encoder for field '%s': %v", field.Name, err)
+	}
+
+	n.index = found
+	n.encoder = encoder
+
+	return c.fmp.fieldType(field), nil
+}
+
+// Fields are encoded the first time they are used in a row, so excluded fields can still be referenced
+func (n *fieldNode) eval(ctx *evalContext) (Value, error) {
+	if v, found := ctx.values[n.index]; found {
+		return v, nil
+	}
+
+	if n.index >= len(ctx.row.Cols) {
+		return Value{}, fmt.Errorf("Row has no column for field '%s'", n.name)
+	}
+
+	v, err := n.encoder(ctx.row.Cols[n.index].Data)
+
+	if err != nil {
+		return Value{}, fmt.Errorf("Unable to encode field '%s': %v", n.name, err)
+	}
+
+	ctx.values[n.index] = v
+
+	return v, nil
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+	pos     int
+}
+
+func (n *unaryNode) check(c *checker) (exprType, error) {
+	t, err := n.operand.check(c)
+
+	if err != nil {
+		return exprType{}, err
+	}
+
+	want := NumberKind
+
+	if n.op == "!" {
+		want = BoolKind
+	}
+
+	if !t.is(want) {
+		return exprType{}, fmt.Errorf("Operator '%s' at column %d needs a %s, not %s", n.op, n.pos+1, want, t)
+	}
+
+	return typeOf(want), nil
+}
+
+func (n *unaryNode) eval(ctx *evalContext) (Value, error) {
+	v, err := n.operand.eval(ctx)
+
+	if err != nil || v.IsNull() {
+		return v, err
+	}
+
+	if n.op == "!" {
+		return NewBool(!v.Bool()), nil
+	}
+
+	return arithmetic("-", NewInt(0), v)
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+	pos         int
+}
+
+// The kinds that can be ordered with < and >
+var orderedKinds = map[Kind]bool{StringKind: true, NumberKind: true, DateKind: true, TimeKind: true, TimestampKind: true}
+
+func (n *binaryNode) check(c *checker) (exprType, error) {
+	l, err := n.left.check(c)
+
+	if err != nil {
+		return exprType{}, err
+	}
+
+	r, err := n.right.check(c)
+
+	if err != nil {
+		return exprType{}, err
+	}
+
+	mismatch := fmt.Errorf("Operator '%s' at column %d cannot be used with %s and %s", n.op, n.pos+1, l, r)
+
+	switch n.op {
+	case "&&", "||":
+		if !l.is(BoolKind) || !r.is(BoolKind) {
+			return exprType{}, mismatch
+		}
+	case "==", "!=":
+		if _, ok := comparisonType(l, r); !ok {
+			return exprType{}, mismatch
+		}
+	case "<", "<=", ">", ">=":
+		t, ok := comparisonType(l, r)
+
+		if !ok || (!t.any && t.kind != NullKind && !orderedKinds[t.kind]) {
+			return exprType{}, mismatch
+		}
+	case "+":
+		t, ok := unify(l, r)
+
+		if !ok || !(t.is(NumberKind) || t.is(StringKind)) {
+			return exprType{}, mismatch
+		}
+
+		return t, nil
+	default:
+		if !l.is(NumberKind) || !r.is(NumberKind) {
+			return exprType{}, mismatch
+		}
+
+		return typeOf(NumberKind), nil
+	}
+
+	return typeOf(BoolKind), nil
+}
+
+func (n *binaryNode) eval(ctx *evalContext) (Value, error) {
+	l, err := n.left.eval(ctx)
+
+	if err != nil {
+		return Value{}, err
+	}
+
+	// Only look at the right side when it matters, and treat null as false
+	switch n.op {
+	case "&&":
+		if !l.Bool() {
+			return NewBool(false), nil
+		}
+
+		r, err := n.right.eval(ctx)
+
+		return NewBool(r.Bool()), err
+	case "||":
+		if l.Bool() {
+			return NewBool(true), nil
+		}
+
+		r, err := n.right.eval(ctx)
+
+		return NewBool(r.Bool()), err
+	}
+
+	r, err := n.right.eval(ctx)
+
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch n.op {
+	case "==":
+		return NewBool(equalValues(l, r)), nil
+	case "!=":
+		return NewBool(!equalValues(l, r)), nil
+	case "<", "<=", ">", ">=":
+		cmp, ok := compareValues(l, r)
+
+		if !ok {
+			return NewBool(false), nil
+		}
+
+		switch n.op {
+		case "<":
+			return NewBool(cmp < 0), nil
+		case "<=":
+			return NewBool(cmp <= 0), nil
+		case ">":
+			return NewBool(cmp > 0), nil
+		}
+
+		return NewBool(cmp >= 0), nil
+	}
+
+	if l.IsNull() || r.IsNull() {
+		return Null(), nil
+	}
+
+	if n.op == "+" && l.Kind() == StringKind && r.Kind() == StringKind {
+		return NewString(l.String() + r.String()), nil
+	}
+
+	return arithmetic(n.op, l, r)
+}
+
+// Values are equal when they are the same kind with the same value, and numbers are compared by their value, so 1 == 1.0.
+// A date is equal to a timestamp at midnight on that day
+func equalValues(l, r Value) bool {
+	l, r = promoteDate(l, r), promoteDate(r, l)
+
+	if l.Kind() == NumberKind && r.Kind() == NumberKind {
+		cmp, _ := compareValues(l, r)
+		return cmp == 0
+	}
+
+	return l.Equal(r)
+}
+
+// Compare two values of the same ordered kind, or a date with a timestamp. Anything else, including null, can't be compared
+func compareValues(l, r Value) (int, bool) {
+	l, r = promoteDate(l, r), promoteDate(r, l)
+
+	if l.Kind() != r.Kind() || !orderedKinds[l.Kind()] {
+		return 0, false
+	}
+
+	switch l.Kind() {
+	case StringKind:
+		return strings.Compare(l.String(), r.String()), true
+	case NumberKind:
+		lr, lok := exactNumber(l)
+		rr, rok := exactNumber(r)
+
+		if lok && rok {
+			return lr.Cmp(rr), true
+		}
+
+		// Only numbers with huge exponents get here, which floats can still put in order
+		lf, _ := l.Float64()
+		rf, _ := r.Float64()
+
+		switch {
+		case lf < rf:
+			return -1, true
+		case lf > rf:
+			return 1, true
+		}
+
+		return 0, true
+	}
+
+	switch lt, rt := l.Time(), r.Time(); {
+	case lt.Before(rt):
+		return -1, true
+	case lt.After(rt):
+		return 1, true
+	}
+
+	return 0, true
+}
+
+// The largest exponent a number can have to be worked on exactly. No real export has anything close,
+// and a bigger one would need an enormous big.Rat, so those go through floats instead
+const maxExactExponent = 1000
+
+// Get a number as an exact fraction. ok is false if it isn't a number, or its exponent is too big to be exact about
+func exactNumber(v Value) (*big.Rat, bool) {
+	text := v.Decimal()
+
+	if e := strings.IndexAny(text, "eE"); e >= 0 {
+		exponent, err := strconv.Atoi(text[e+1:])
+
+		if err != nil || exponent > maxExactExponent || exponent < -maxExactExponent {
+			return nil, false
+		}
+	}
+
+	return new(big.Rat).SetString(text)
+}
+
+// Get the number value for an exact result. Whole numbers are written exactly, and anything else as the nearest float
+func ratValue(r *big.Rat) (Value, bool) {
+	if r.IsInt() {
+		return Value{kind: NumberKind, text: r.Num().String()}, true
+	}
+
+	f, _ := r.Float64()
+
+	if math.IsInf(f, 0) {
+		return Value{}, false
+	}
+
+	return NewFloat(f), true
+}
+
+// Arithmetic on two exact numbers
+func ratArithmetic(op string, l, r *big.Rat) (*big.Rat, error) {
+	out := new(big.Rat)
+
+	switch op {
+	case "+":
+		out.Add(l, r)
+	case "-":
+		out.Sub(l, r)
+	case "*":
+		out.Mul(l, r)
+	case "/", "%":
+		if r.Sign() == 0 {
+			return nil, fmt.Errorf("Division by zero")
+		}
+
+		out.Quo(l, r)
+
+		// The remainder has the sign of the left side, like Go's % and math.Mod
+		if op == "%" {
+			whole := new(big.Int).Quo(out.Num(), out.Denom())
+			out.Sub(l, new(big.Rat).Mul(r, new(big.Rat).SetInt(whole)))
+		}
+	}
+
+	return out, nil
+}
+
+// Turn a date into a timestamp at midnight when it is being compared with a timestamp
+func promoteDate(v, other Value) Value {
+	if v.Kind() != DateKind || other.Kind() != TimestampKind {
+		return v
+	}
+
+	t := v.Time()
+
+	return NewTimestamp(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()))
+}
+
+// Arithmetic on two numbers. It is done exactly, so 0.1 + 0.2 is 0.3, and only the result is turned into a float if it isn't whole
+func arithmetic(op string, l, r Value) (Value, error) {
+	// Only fields whose kind isn't known until a row is read can get here with anything else
+	if l.Kind() != NumberKind || r.Kind() != NumberKind {
+		return Value{}, fmt.Errorf("Operator '%s' cannot be used with %s and %s", op, l.Kind(), r.Kind())
+	}
+
+	lr, lok := exactNumber(l)
+	rr, rok := exactNumber(r)
+
+	if lok && rok {
+		out, err := ratArithmetic(op, lr, rr)
+
+		if err != nil {
+			return Value{}, err
+		}
+
+		if v, ok := ratValue(out); ok {
+			return v, nil
+		}
+
+		return Value{}, fmt.Errorf("Result of '%s' is out of range", op)
+	}
+
+	lf, _ := l.Float64()
+	rf, _ := r.Float64()
+
+	var out float64
+
+	switch op {
+	case "+":
+		out = lf + rf
+	case "-":
+		out = lf - rf
+	case "*":
+		out = lf * rf
+	case "/", "%":
+		if rf == 0 {
+			return Value{}, fmt.Errorf("Division by zero")
+		}
+
+		if op == "/" {
+			out = lf / rf
+		} else {
+			out = math.Mod(lf, rf)
+		}
+	}
+
+	if math.IsInf(out, 0) || math.IsNaN(out) {
+		return Value{}, fmt.Errorf("Result of '%s' is out of range", op)
+	}
+
+	return NewFloat(out), nil
+}
+
+type callNode struct {
+	name     string
+	args     []node
+	pos      int
+	function function
+}
+
+func (n *callNode) check(c *checker) (exprType, error) {
+	function, found := functions[n.name]
+
+	if !found {
+		return exprType{}, fmt.Errorf("Unknown function '%s' at column %d", n.name, n.pos+1)
+	}
+
+	types := make([]exprType, len(n.args))
+	constant := true
+
+	for i, arg := range n.args {
+		t, err := arg.check(c)
+
+		if err != nil {
+			return exprType{}, err
+		}
+
+		types[i] = t
+
+		if _, literal := arg.(*literalNode); !literal {
+			constant = false
+		}
+	}
+
+	out, err := function.check(types)
+
+	if err != nil {
+		return exprType{}, fmt.Errorf("%s() at column %d: %v", n.name, n.pos+1, err)
+	}
+
+	n.function = function
+
+	// Calls on literals, like date("2024-01-01"), are tried now so mistakes show up before any rows are read
+	if constant && len(n.args) > 0 {
+		if _, err := n.eval(&evalContext{}); err != nil {
+			return exprType{}, err
+		}
+	}
+
+	return out, nil
+}
+
+func (n *callNode) eval(ctx *evalContext) (Value, error) {
+	args := make([]Value, len(n.args))
+
+	for i, arg := range n.args {
+		v, err := arg.eval(ctx)
+
+		if err != nil {
+			return Value{}, err
+		}
+
+		args[i] = v
+	}
+
+	if !n.function.nulls {
+		for _, arg := range args {
+			if arg.IsNull() {
+				return Null(), nil
+			}
+		}
+	}
+
+	out, err := n.function.call(ctx, args)
+
+	if err != nil {
+		return Value{}, fmt.Errorf("%s() at column %d: %v", n.name, n.pos+1, err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,537 @@
+package fmpxmlresult
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/timeconv"
+)
+
+// This file has the functions that can be called in expressions
+
+type function struct {
+	check func(args []exprType) (exprType, error)
+	call  func(ctx *evalContext, args []Value) (Value, error)
+	nulls bool // Whether the function is given null arguments. Otherwise, any null argument makes the result null
+}
+
+var functions = map[string]function{
+	// Strings
+	"lower":      {check: signature(StringKind, StringKind), call: stringFunction(strings.ToLower)},
+	"upper":      {check: signature(StringKind, StringKind), call: stringFunction(strings.ToUpper)},
+	"trim":       {check: signature(StringKind, StringKind), call: stringFunction(strings.TrimSpace)},
+	"length":     {check: checkLength, call: callLength},
+	"contains":   {check: checkContains, call: callContains},
+	"startsWith": {check: signature(BoolKind, StringKind, StringKind), call: callStartsWith},
+	"endsWith":   {check: signature(BoolKind, StringKind, StringKind), call: callEndsWith},
+	"replace":    {check: signature(StringKind, StringKind, StringKind, StringKind), call: callReplace},
+	"substring":  {check: signature(StringKind, StringKind, NumberKind, NumberKind), call: callSubstring},
+	"string":     {check: checkString, call: callString},
+	"concat":     {check: checkConcat, call: callConcat, nulls: true},
+
+	// Numbers
+	"number": {check: signature(NumberKind, StringKind), call: callNumber},
+	"round":  {check: checkRound, call: callRound},
+	"floor":  {check: signature(NumberKind, NumberKind), call: ratFunction(ratFloor)},
+	"ceil":   {check: signature(NumberKind, NumberKind), call: ratFunction(ratCeil)},
+	"abs":    {check: signature(NumberKind, NumberKind), call: ratFunction(ratAbs)},
+
+	// Dates and times
+	"date":        {check: signature(DateKind, StringKind), call: parseFunction(DateLayout, DateKind)},
+	"time":        {check: signature(TimeKind, StringKind), call: parseFunction(TimeLayout, TimeKind)},
+	"timestamp":   {check: signature(TimestampKind, StringKind), call: parseFunction(TimestampLayout, TimestampKind)},
+	"today":       {check: signature(DateKind), call: callToday},
+	"now":         {check: signature(TimestampKind), call: callNow},
+	"year":        {check: checkDatePart, call: datePart(func(t time.Time) int { return t.Year() })},
+	"month":       {check: checkDatePart, call: datePart(func(t time.Time) int { return int(t.Month()) })},
+	"day":         {check: checkDatePart, call: datePart(func(t time.Time) int { return t.Day() })},
+	"addDays":     {check: checkAddDays, call: callAddDays},
+	"daysBetween": {check: checkDaysBetween, call: callDaysBetween},
+	"format":      {check: checkFormat, call: callFormat},
+
+	// Conditionals
+	"if":       {check: checkIf, call: callIf, nulls: true},
+	"coalesce": {check: checkCoalesce, call: callCoalesce, nulls: true},
+	"isEmpty":  {check: checkIsEmpty, call: callIsEmpty, nulls: true},
+
+	// The row itself
+	"recordID": {check: signature(StringKind), call: func(ctx *evalContext, args []Value) (Value, error) { return NewString(ctx.row.RecordID), nil }},
+	"modID":    {check: signature(StringKind), call: func(ctx *evalContext, args []Value) (Value, error) { return NewString(ctx.row.ModID), nil }},
+}
+
+// A check for a function that takes a fixed set of kinds
+func signature(result Kind, params ...Kind) func([]exprType) (exprType, error) {
+	return func(args []exprType) (exprType, error) {
+		if err := argumentCount(args, len(params), len(params)); err != nil {
+			return exprType{}, err
+		}
+
+		for i, param := range params {
+			if err := argumentKind(args, i, param); err != nil {
+				return exprType{}, err
+			}
+		}
+
+		return typeOf(result), nil
+	}
+}
+
+func argumentCount(args []exprType, min, max int) error {
+	switch {
+	case min == max && len(args) != min:
+		return fmt.Errorf("expects %d arguments, not %d", min, len(args))
+	case len(args) < min:
+		return fmt.Errorf("expects at least %d arguments, not %d", min, len(args))
+	case max >= 0 && len(args) > max:
+		return fmt.Errorf("expects at most %d arguments, not %d", max, len(args))
+	}
+
+	return nil
+}
+
+// Make sure an argument is one of the given kinds
+func argumentKind(args []exprType, i int, kinds ...Kind) error {
+	names := []string{}
+
+	for _, k := range kinds {
+		if args[i].is(k) {
+			return nil
+		}
+
+		names = append(names, k.String())
+	}
+
+	return fmt.Errorf("argument %d must be a %s, not %s", i+1, strings.Join(names, " or "), args[i])
+}
+
+func stringFunction(f func(string) string) func(*evalContext, []Value) (Value, error) {
+	return func(ctx *evalContext, args []Value) (Value, error) {
+		return NewString(f(args[0].String())), nil
+	}
+}
+
+// Number functions work on the exact number, like arithmetic does
+func ratFunction(f func(*big.Rat) *big.Rat) func(*evalContext, []Value) (Value, error) {
+	return func(ctx *evalContext, args []Value) (Value, error) {
+		r, ok := exactNumber(args[0])
+
+		if !ok {
+			return Value{}, fmt.Errorf("%s is out of range", args[0].Decimal())
+		}
+
+		if v, ok := ratValue(f(r)); ok {
+			return v, nil
+		}
+
+		return Value{}, fmt.Errorf("result is out of range")
+	}
+}
+
+// The largest whole number no more than r
+func ratFloor(r *big.Rat) *big.Rat {
+	// The denominator is always positive, so Euclidean division rounds down
+	whole := new(big.Int).Div(r.Num(), r.Denom())
+
+	return new(big.Rat).SetInt(whole)
+}
+
+// The size of r, without its sign
+func ratAbs(r *big.Rat) *big.Rat {
+	return new(big.Rat).Abs(r)
+}
+
+// The smallest whole number no less than r
+func ratCeil(r *big.Rat) *big.Rat {
+	floor := ratFloor(new(big.Rat).Neg(r))
+
+	return floor.Neg(floor)
+}
+
+// length() counts the characters of a string, or the items of an array
+func checkLength(args []exprType) (exprType, error) {
+	if err := argumentCount(args, 1, 1); err != nil {
+		return exprType{}, err
+	}
+
+	return typeOf(NumberKind), argumentKind(args, 0, StringKind, ArrayKind)
+}
+
+func callLength(ctx *evalContext, args []Value) (Value, error) {
+	if args[0].Kind() == ArrayKind {
+		return NewInt(int64(len(args[0].Items()))), nil
+	}
+
+	return NewInt(int64(len([]rune(args[0].String())))), nil
+}
+
+// contains() looks for a substring, or for an item of an array
+func checkContains(args []exprType) (exprType, error) {
+	if err := argumentCount(args, 2, 2); err != nil {
+		return exprType{}, err
+	}
+
+	if args[0].kind == ArrayKind {
+		if _, ok := comparisonType(typeOf(args[0].item), args[1]); !ok {
+			return exprType{}, fmt.Errorf("cannot look for %s in %s", args[1], args[0])
+		}
+
+		return typeOf(BoolKind), nil
+	}
+
+	if err := argumentKind(args, 0, StringKind); err != nil {
+		return exprType{}, err
+	}
+
+	return typeOf(BoolKind), argumentKind(args, 1, StringKind)
+}
+
+func callContains(ctx *evalContext, args []Value) (Value, error) {
+	if args[0].Kind() == ArrayKind {
+		for _, item := range args[0].Items() {
+			if equalValues(item, args[1]) {
+				return NewBool(true), nil
+			}
+		}
+
+		return NewBool(false), nil
+	}
+
+	return NewBool(strings.Contains(args[0].String(), args[1].String())), nil
+}
+
+func callStartsWith(ctx *evalContext, args []Value) (Value, error) {
+	return NewBool(strings.HasPrefix(args[0].String(), args[1].String())), nil
+}
+
+func callEndsWith(ctx *evalContext, args []Value) (Value, error) {
+	return NewBool(strings.HasSuffix(args[0].String(), args[1].String())), nil
+}
+
+func callReplace(ctx *evalContext, args []Value) (Value, error) {
+	return NewString(strings.ReplaceAll(args[0].String(), args[1].String(), args[2].String())), nil
+}
+
+// substring(s, start, length) counts characters from zero, and stops at the end of the string
+func callSubstring(ctx *evalContext, args []Value) (Value, error) {
+	runes := []rune(args[0].String())
+	start, startOK := args[1].Int64()
+	length, lengthOK := args[2].Int64()
+
+	if !startOK || !lengthOK || start < 0 || length < 0 {
+		return Value{}, fmt.Errorf("needs a start and length that are whole numbers of at least zero")
+	}
+
+	if start > int64(len(runes)) {
+		start = int64(len(runes))
+	}
+
+	end := start + length
+
+	if end > int64(len(runes)) {
+		end = int64(len(runes))
+	}
+
+	return NewString(string(runes[start:end])), nil
+}
+
+// string() writes anything as text, the same way the text writers do
+func checkString(args []exprType) (exprType, error) {
+	return typeOf(StringKind), argumentCount(args, 1, 1)
+}
+
+func callString(ctx *evalContext, args []Value) (Value, error) {
+	return NewString(args[0].String()), nil
+}
+
+// concat(a, b, ...) joins anything as text, where null is empty, so a missing middle name doesn't lose the whole name
+func checkConcat(args []exprType) (exprType, error) {
+	return typeOf(StringKind), argumentCount(args, 1, -1)
+}
+
+func callConcat(ctx *evalContext, args []Value) (Value, error) {
+	out := ""
+
+	for _, arg := range args {
+		out += arg.String()
+	}
+
+	return NewString(out), nil
+}
+
+// number() is null for text that isn't a number, so it can be used on messy fields
+func callNumber(ctx *evalContext, args []Value) (Value, error) {
+	v, err := encodeNumber(strings.TrimSpace(args[0].String()))
+
+	if err != nil {
+		return Null(), nil
+	}
+
+	return v, nil
+}
+
+// round(n) rounds to a whole number, and round(n, places) to that many decimal places
+func checkRound(args []exprType) (exprType, error) {
+	if err := argumentCount(args, 1, 2); err != nil {
+		return exprType{}, err
+	}
+
+	for i := range args {
+		if err := argumentKind(args, i, NumberKind); err != nil {
+			return exprType{}, err
+		}
+	}
+
+	return typeOf(NumberKind), nil
+}
+
+func callRound(ctx *evalContext, args []Value) (Value, error) {
+	r, ok := exactNumber(args[0])
+
+	if !ok {
+		return Value{}, fmt.Errorf("%s is out of range", args[0].Decimal())
+	}
+
+	places := int64(0)
+
+	if len(args) > 1 {
+		if places, ok = args[1].Int64(); !ok {
+			return Value{}, fmt.Errorf("needs a whole number of places")
+		}
+	}
+
+	if places > maxExactExponent || places < -maxExactExponent {
+		return Value{}, fmt.Errorf("can't round to %d places", places)
+	}
+
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(abs(places)), nil))
+
+	if places < 0 {
+		scale.Inv(scale)
+	}
+
+	// Halves round away from zero, so round the size and put the sign back
+	scaled := new(big.Rat).Mul(r, scale)
+	rounded := ratFloor(scaled.Abs(scaled).Add(scaled, big.NewRat(1, 2)))
+
+	if r.Sign() < 0 {
+		rounded.Neg(rounded)
+	}
+
+	if v, ok := ratValue(rounded.Quo(rounded, scale)); ok {
+		return v, nil
+	}
+
+	return Value{}, fmt.Errorf("result is out of range")
+}
+
+func abs(i int64) int64 {
+	if i < 0 {
+		return -i
+	}
+
+	return i
+}
+
+// Parse text in one of the layouts the values are written with
+func parseFunction(layout string, kind Kind) func(*evalContext, []Value) (Value, error) {
+	return func(ctx *evalContext, args []Value) (Value, error) {
+		t, err := time.Parse(layout, args[0].String())
+
+		if err != nil {
+			return Value{}, fmt.Errorf("'%s' is not a %s like %s", args[0].String(), kind, layout)
+		}
+
+		return Value{kind: kind, time: t}, nil
+	}
+}
+
+// Dates and timestamps from FileMaker have no time zone, so the current time is the local wall clock
+func callToday(ctx *evalContext, args []Value) (Value, error) {
+	y, m, d := time.Now().Date()
+
+	return NewDate(time.Date(y, m, d, 0, 0, 0, 0, time.UTC)), nil
+}
+
+func callNow(ctx *evalContext, args []Value) (Value, error) {
+	now := time.Now()
+	y, m, d := now.Date()
+
+	return NewTimestamp(time.Date(y, m, d, now.Hour(), now.Minute(), now.Second(), 0, time.UTC)), nil
+}
+
+func checkDatePart(args []exprType) (exprType, error) {
+	if err := argumentCount(args, 1, 1); err != nil {
+		return exprType{}, err
+	}
+
+	return typeOf(NumberKind), argumentKind(args, 0, DateKind, TimestampKind)
+}
+
+func datePart(f func(time.Time) int) func(*evalContext, []Value) (Value, error) {
+	return func(ctx *evalContext, args []Value) (Value, error) {
+		return NewInt(int64(f(args[0].Time()))), nil
+	}
+}
+
+// addDays(d, n) keeps the kind it was given, so a timestamp stays a timestamp
+func checkAddDays(args []exprType) (exprType, error) {
+	if err := argumentCount(args, 2, 2); err != nil {
+		return exprType{}, err
+	}
+
+	if err := argumentKind(args, 0, DateKind, TimestampKind); err != nil {
+		return exprType{}, err
+	}
+
+	if err := argumentKind(args, 1, NumberKind); err != nil {
+		return exprType{}, err
+	}
+
+	if args[0].kind == NullKind {
+		return typeOf(DateKind), nil
+	}
+
+	return args[0], nil
+}
+
+func callAddDays(ctx *evalContext, args []Value) (Value, error) {
+	days, ok := args[1].Int64()
+
+	if !ok {
+		return Value{}, fmt.Errorf("needs a whole number of days")
+	}
+
+	return Value{kind: args[0].Kind(), time: args[0].Time().AddDate(0, 0, int(days))}, nil
+}
+
+// daysBetween(a, b) is the number of calendar days from a to b, which is negative if b is earlier. The time of day is ignored
+func checkDaysBetween(args []exprType) (exprType, error) {
+	if err := argumentCount(args, 2, 2); err != nil {
+		return exprType{}, err
+	}
+
+	for i := range args {
+		if err := argumentKind(args, i, DateKind, TimestampKind); err != nil {
+			return exprType{}, err
+		}
+	}
+
+	return typeOf(NumberKind), nil
+}
+
+func callDaysBetween(ctx *evalContext, args []Value) (Value, error) {
+	return NewInt(dayNumber(args[1].Time()) - dayNumber(args[0].Time())), nil
+}
+
+// Count the days from 1970-01-01 to the calendar date of t, ignoring the time of day
+func dayNumber(t time.Time) int64 {
+	y, m, d := t.Date()
+
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60)
+}
+
+// format(d, pattern) writes a date or time with a FileMaker format, like "MM/dd/yyyy"
+func checkFormat(args []exprType) (exprType, error) {
+	if err := argumentCount(args, 2, 2); err != nil {
+		return exprType{}, err
+	}
+
+	if err := argumentKind(args, 0, DateKind, TimeKind, TimestampKind); err != nil {
+		return exprType{}, err
+	}
+
+	return typeOf(StringKind), argumentKind(args, 1, StringKind)
+}
+
+func callFormat(ctx *evalContext, args []Value) (Value, error) {
+	var layout string
+
+	switch args[0].Kind() {
+	case DateKind:
+		layout = timeconv.ParseDateFormat(args[1].String())
+	case TimeKind:
+		layout = timeconv.ParseTimeFormat(args[1].String())
+	case TimestampKind:
+		layout = timeconv.ParseTimestampFormat(args[1].String())
+	default:
+		return Value{}, fmt.Errorf("cannot be used with %s", args[0].Kind())
+	}
+
+	return NewString(args[0].Time().Format(layout)), nil
+}
+
+// if(condition, then, else) is the else value when the condition is false or null
+func checkIf(args []exprType) (exprType, error) {
+	if err := argumentCount(args, 3, 3); err != nil {
+		return exprType{}, err
+	}
+
+	if err := argumentKind(args, 0, BoolKind); err != nil {
+		return exprType{}, err
+	}
+
+	out, ok := unify(args[1], args[2])
+
+	if !ok {
+		return exprType{}, fmt.Errorf("the branches must be the same type, not %s and %s", args[1], args[2])
+	}
+
+	return out, nil
+}
+
+func callIf(ctx *evalContext, args []Value) (Value, error) {
+	if args[0].Bool() {
+		return args[1], nil
+	}
+
+	return args[2], nil
+}
+
+// coalesce(a, b, ...) is the first value that isn't null
+func checkCoalesce(args []exprType) (exprType, error) {
+	if err := argumentCount(args, 1, -1); err != nil {
+		return exprType{}, err
+	}
+
+	out := args[0]
+
+	for _, t := range args[1:] {
+		var ok bool
+
+		if out, ok = unify(out, t); !ok {
+			return exprType{}, fmt.Errorf("the arguments must be the same type, not %s and %s", args[0], t)
+		}
+	}
+
+	return out, nil
+}
+
+func callCoalesce(ctx *evalContext, args []Value) (Value, error) {
+	for _, arg := range args {
+		if !arg.IsNull() {
+			return arg, nil
+		}
+	}
+
+	return Null(), nil
+}
+
+// isEmpty(x) is true for null, empty text, and empty arrays
+func checkIsEmpty(args []exprType) (exprType, error) {
+	return typeOf(BoolKind), argumentCount(args, 1, 1)
+}
+
+func callIsEmpty(ctx *evalContext, args []Value) (Value, error) {
+	switch args[0].Kind() {
+	case NullKind:
+		return NewBool(true), nil
+	case StringKind:
+		return NewBool(args[0].String() == ""), nil
+	case ArrayKind:
+		return NewBool(len(args[0].Items()) == 0), nil
+	}
+
+	return NewBool(false), nil
+}
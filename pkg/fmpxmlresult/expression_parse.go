@@ -0,0 +1,329 @@
+package fmpxmlresult
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This file has the lexer and parser for expressions
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenQuotedIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string // The identifier, the unquoted string, the number, or the operator
+	pos  int
+}
+
+// Describe a token for an error message
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.text)
+	case tokenQuotedIdent:
+		return "`" + t.text + "`"
+	}
+
+	return "'" + t.text + "'"
+}
+
+// The operators, longest first so "<=" isn't read as "<" and "="
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "+", "-", "*", "/", "%", "!", "(", ")", ","}
+
+// Binary operators and how tightly they bind
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+func lexExpression(source string) ([]token, error) {
+	out := []token{}
+	i := 0
+
+	for i < len(source) {
+		r, size := utf8.DecodeRuneInString(source[i:])
+
+		if unicode.IsSpace(r) {
+			i += size
+			continue
+		}
+
+		start := i
+
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(source) {
+				r, size := utf8.DecodeRuneInString(source[i:])
+
+				if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+					i += size
+				} else if strings.HasPrefix(source[i:], "::") {
+					i += 2
+				} else {
+					break
+				}
+			}
+
+			out = append(out, token{tokenIdent, source[start:i], start})
+		case unicode.IsDigit(r):
+			for i < len(source) && (isDigit(source[i]) || source[i] == '.') {
+				i++
+			}
+
+			// An exponent, like 1e6 or 2.5E-3
+			if i < len(source) && (source[i] == 'e' || source[i] == 'E') {
+				i++
+
+				if i < len(source) && (source[i] == '+' || source[i] == '-') {
+					i++
+				}
+
+				for i < len(source) && isDigit(source[i]) {
+					i++
+				}
+			}
+
+			out = append(out, token{tokenNumber, source[start:i], start})
+		case r == '`':
+			end := strings.IndexByte(source[i+1:], '`')
+
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated field name at column %d", start+1)
+			}
+
+			i += end + 2
+			out = append(out, token{tokenQuotedIdent, source[start+1 : i-1], start})
+		case r == '"':
+			i++
+
+			for i < len(source) && source[i] != '"' {
+				if source[i] == '\\' {
+					i++
+				}
+
+				i++
+			}
+
+			if i >= len(source) {
+				return nil, fmt.Errorf("Unterminated string at column %d", start+1)
+			}
+
+			i++
+			text, err := strconv.Unquote(source[start:i])
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid string at column %d: %v", start+1, err)
+			}
+
+			out = append(out, token{tokenString, text, start})
+		default:
+			found := false
+
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					out = append(out, token{tokenOperator, op, start})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return nil, fmt.Errorf("Unexpected '%c' at column %d", r, start+1)
+			}
+		}
+	}
+
+	return append(out, token{tokenEOF, "", len(source)}), nil
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func parseExpression(source string) (node, error) {
+	tokens, err := lexExpression(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	out, err := p.parseBinary(0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("Unexpected %s at column %d", t, t.pos+1)
+	}
+
+	return out, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+
+	if t.kind != tokenEOF {
+		p.i++
+	}
+
+	return t
+}
+
+func (p *parser) isOperator(op string) bool {
+	t := p.peek()
+
+	return t.kind == tokenOperator && t.text == op
+}
+
+func (p *parser) expect(op string) error {
+	if t := p.next(); t.kind != tokenOperator || t.text != op {
+		return fmt.Errorf("Expected '%s' at column %d, found %s", op, t.pos+1, t)
+	}
+
+	return nil
+}
+
+// Parse operators that bind tighter than the minimum, so equal precedence groups to the left
+func (p *parser) parseBinary(minPrecedence int) (node, error) {
+	left, err := p.parseUnary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		prec, found := precedence[t.text]
+
+		if t.kind != tokenOperator || !found || prec <= minPrecedence {
+			return left, nil
+		}
+
+		p.next()
+
+		right, err := p.parseBinary(prec)
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binaryNode{op: t.text, left: left, right: right, pos: t.pos}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOperator("!") || p.isOperator("-") {
+		t := p.next()
+		operand, err := p.parseUnary()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &unaryNode{op: t.text, operand: operand, pos: t.pos}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenNumber:
+		value, err := NewNumber(t.text)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number '%s' at column %d", t.text, t.pos+1)
+		}
+
+		return &literalNode{value: value}, nil
+	case tokenString:
+		return &literalNode{value: NewString(t.text)}, nil
+	case tokenQuotedIdent:
+		return &fieldNode{name: t.text, pos: t.pos}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true", "false":
+			return &literalNode{value: NewBool(t.text == "true")}, nil
+		case "null":
+			return &literalNode{value: Null()}, nil
+		}
+
+		if p.isOperator("(") {
+			return p.parseCall(t)
+		}
+
+		return &fieldNode{name: t.text, pos: t.pos}, nil
+	case tokenOperator:
+		if t.text == "(" {
+			inner, err := p.parseBinary(0)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return inner, p.expect(")")
+		}
+	}
+
+	return nil, fmt.Errorf("Unexpected %s at column %d", t, t.pos+1)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	p.next() // The opening parenthesis
+
+	out := &callNode{name: name.text, pos: name.pos}
+
+	if p.isOperator(")") {
+		p.next()
+		return out, nil
+	}
+
+	for {
+		arg, err := p.parseBinary(0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		out.args = append(out.args, arg)
+
+		if !p.isOperator(",") {
+			return out, p.expect(")")
+		}
+
+		p.next()
+	}
+}
@@ -0,0 +1,316 @@
+package fmpxmlresult_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_ExpressionEvaluate(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Name: "test.fmp12", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Status", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "First Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Modified", Type: "DATE"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Amount", Type: "NUMBER"},
+			{EmptyOK: true, MaxRepeat: 2, Name: "Tags", Type: "TEXT"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Found: 1, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Active"}}, {Data: []string{"Ann"}}, {Data: []string{"3/15/2024"}}, {Data: []string{"12.5"}}, {Data: []string{"red", "blue"}}}},
+		}},
+	}
+
+	var tests = []struct {
+		source string
+		want   string
+	}{
+		{`Status == "Active" && Modified >= date("2024-01-01")`, `true`},
+		{`Status != "Active" || Amount > 20`, `false`},
+		{`!(Amount < 12.50)`, `true`},
+		{`Amount == 12.50`, `true`},
+		{`Amount * 2 + 1`, `26`},
+		{`7 % 3 - -1`, `2`},
+		{`1 + 2 * 3`, `7`},
+		{"`First Name` + \" \" + lower(Status)", `"Ann active"`},
+		{`upper(substring(Status, 1, 3))`, `"CTI"`},
+		{`contains(Tags, "blue") && !contains(Tags, "green")`, `true`},
+		{`length(Tags)`, `2`},
+		{`year(Modified) * 100 + month(Modified)`, `202403`},
+		{`addDays(Modified, 20)`, `"2024-04-04"`},
+		{`daysBetween(date("2024-03-01"), Modified)`, `14`},
+		{`format(Modified, "MM/dd/yyyy")`, `"03/15/2024"`},
+		{`if(Amount > 10, "big", "small")`, `"big"`},
+		{`coalesce(number("n/a"), round(Amount))`, `13`},
+		{`isEmpty(number("n/a")) && !isEmpty(Status)`, `true`},
+		{`null == number("n/a")`, `true`},
+		{`Amount > null`, `false`},
+		{`recordID() + "/" + modID()`, `"1/1"`},
+		{`string(Modified)`, `"2024-03-15"`},
+		{`9223372036854775807 + 1`, `9223372036854775808`},
+		{`-9223372036854775807 - 2`, `-9223372036854775809`},
+		{`4611686018427387904 * 2`, `9223372036854775808`},
+		{`-9223372036854775807 - 1`, `-9223372036854775808`},
+		{`(-9223372036854775807 - 1) * -1`, `9223372036854775808`},
+		{`0.1 + 0.2`, `0.3`},
+		{`0.1 + 0.2 == 0.3`, `true`},
+		{`1 / 3`, `0.3333333333333333`},
+		{`-7.5 % 2`, `-1.5`},
+		{`round(2.675, 2)`, `2.68`},
+		{`round(-2.5)`, `-3`},
+		{`round(1250, -2)`, `1300`},
+		{`round(1e30, 2)`, `1000000000000000000000000000000`},
+		{`floor(-1.5) + ceil(-1.5)`, `-3`},
+		{`abs(-0.10)`, `0.1`},
+		{`daysBetween(date("1600-01-01"), date("2024-01-01"))`, `154863`},
+		{`daysBetween(timestamp("2024-03-01T23:00:00"), timestamp("2024-03-02T01:00:00"))`, `1`},
+	}
+
+	for _, tt := range tests {
+		expression, err := sample.CompileExpression(tt.source)
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.source, err)
+			continue
+		}
+
+		have, err := expression.Evaluate(sample.ResultSet.Rows[0])
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.source, err)
+			continue
+		}
+
+		compareJSON(t, have, json.RawMessage(tt.want))
+	}
+}
+
+func Test_ExpressionErrors(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Name: "test.fmp12", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Status", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "First Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Modified", Type: "DATE"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Amount", Type: "NUMBER"},
+			{EmptyOK: true, MaxRepeat: 2, Name: "Tags", Type: "TEXT"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{},
+	}
+
+	var tests = []struct {
+		source string
+		want   string
+	}{
+		{`Status == `, "Unexpected end of expression at column 11"},
+		{`(Status == "Active"`, "Expected ')' at column 20, found end of expression"},
+		{`Status = "Active"`, "Unexpected '=' at column 8"},
+		{`"Active`, "Unterminated string at column 1"},
+		{"`First Name", "Unterminated field name at column 1"},
+		{`Stat == "Active"`, "Unknown field 'Stat' at column 1"},
+		{`Status > 3`, "Operator '>' at column 8 cannot be used with string and number"},
+		{`Modified >= "2024-01-01"`, "Operator '>=' at column 10 cannot be used with date and string"},
+		{`!Amount`, "Operator '!' at column 1 needs a boolean, not number"},
+		{`lower(Amount)`, "lower() at column 1: argument 1 must be a string, not number"},
+		{`date("January")`, "date() at column 1: 'January' is not a date like 2006-01-02"},
+		{`shout(Status)`, "Unknown function 'shout' at column 1"},
+		{`if(true, Status)`, "if() at column 1: expects 3 arguments, not 2"},
+		{`if(true, Status, Amount)`, "if() at column 1: the branches must be the same type, not string and number"},
+	}
+
+	for _, tt := range tests {
+		_, err := sample.CompileExpression(tt.source)
+
+		if err == nil {
+			t.Errorf("%s: err is nil", tt.source)
+			continue
+		}
+
+		if err.Error() != tt.want {
+			t.Errorf("%s: have error '%v', want '%s'", tt.source, err, tt.want)
+		}
+	}
+}
+
+func Test_ExpressionEvaluateErrors(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Name: "test.fmp12", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Amount", Type: "NUMBER"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Huge", Type: "NUMBER"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Found: 1, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"12.5"}}, {Data: []string{"1e2000"}}}},
+		}},
+	}
+
+	// Results that can't be written as a JSON number are errors, not infinity
+	for _, source := range []string{
+		`Amount / 0`,
+		`1e400 + 0.5`,
+		`round(Huge)`,
+		`floor(Huge)`,
+		`round(Amount, 5000)`,
+	} {
+		expression, err := sample.CompileExpression(source)
+
+		if err != nil {
+			t.Errorf("%s: %v", source, err)
+			continue
+		}
+
+		if have, err := expression.Evaluate(sample.ResultSet.Rows[0]); err == nil {
+			t.Errorf("%s: err is nil, have %v", source, have)
+		}
+	}
+}
+
+func Test_PopulateFiltered(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Name: "test.fmp12", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Status", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "First Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Modified", Type: "DATE"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Amount", Type: "NUMBER"},
+			{EmptyOK: true, MaxRepeat: 2, Name: "Tags", Type: "TEXT"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Found: 3, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Active"}}, {Data: []string{"Ann"}}, {Data: []string{"3/15/2024"}}, {Data: []string{"12.5"}}, {Data: []string{"", ""}}}},
+			{RecordID: "2", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Inactive"}}, {Data: []string{"Bob"}}, {Data: []string{"3/15/2024"}}, {Data: []string{"3"}}, {Data: []string{"", ""}}}},
+			{RecordID: "3", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Active"}}, {Data: []string{"Cat"}}, {Data: []string{"12/1/2023"}}, {}, {Data: []string{"", ""}}}},
+		}},
+	}
+
+	sample.RecordIDField = "id"
+	sample.Projection = fmpxmlresult.Projection{Include: []string{"First Name"}}
+	sample.Filter = `Status == "Active" && Modified >= date("2024-01-01")`
+
+	input := sample.ResultSet
+
+	// Populating again filters the rows that were read in, not the ones the last filter kept
+	for i := 0; i < 2; i++ {
+		if err := sample.PopulateRecords(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// Status and Modified are excluded from the records, but can still be filtered on
+		compareJSON(t, sample.Records, json.RawMessage(`[{"First Name": "Ann", "id": "1"}]`))
+
+		for _, diff := range deep.Equal(sample.ResultSet.Found, 1) {
+			t.Error(diff)
+		}
+
+		for _, diff := range deep.Equal(sample.State(), fmpxmlresult.State{"1": "1", "2": "1", "3": "1"}) {
+			t.Error(diff)
+		}
+	}
+
+	for _, diff := range deep.Equal([]int{input.Found, len(input.Rows)}, []int{3, 3}) {
+		t.Error(diff)
+	}
+
+	// Checked before any rows are read, so a bad row doesn't matter
+	input.Rows = append(input.Rows, fmpxmlresult.Row{})
+	sample.Filter = `Amount`
+
+	if err := sample.PopulateRecords(); err == nil || !strings.Contains(err.Error(), "must be a boolean") {
+		t.Errorf("Err is %v for a filter that isn't a boolean", err)
+	}
+}
+
+func Test_FilteredState(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Name: "test.fmp12", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Status", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "First Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Modified", Type: "DATE"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Amount", Type: "NUMBER"},
+			{EmptyOK: true, MaxRepeat: 2, Name: "Tags", Type: "TEXT"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Found: 2, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Active"}}, {Data: []string{"Ann"}}, {Data: []string{"3/15/2024"}}, {Data: []string{"12.5"}}, {Data: []string{"", ""}}}},
+			{RecordID: "2", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Inactive"}}, {Data: []string{"Bob"}}, {Data: []string{"3/15/2024"}}, {Data: []string{"3"}}, {Data: []string{"", ""}}}},
+		}},
+	}
+
+	sample.Filter = `Status == "Active"`
+
+	if err := sample.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Bob is filtered out but still in the export, so the record is in the state and isn't a tombstone. Cat is gone
+	for _, diff := range deep.Equal(sample.State(), fmpxmlresult.State{"1": "1", "2": "1"}) {
+		t.Error(diff)
+	}
+
+	previous := fmpxmlresult.State{"1": "1", "2": "1", "3": "1"}
+
+	compareJSON(t, sample.Tombstones(previous), json.RawMessage(`[{"_deleted": true, "modID": "1", "recordID": "3"}]`))
+
+	if err := sample.FilterIncremental(previous); err != nil {
+		t.Error(err)
+		return
+	}
+
+	compareJSON(t, sample.Records, json.RawMessage(`[{"_deleted": true, "modID": "1", "recordID": "3"}]`))
+}
+
+func Test_ExpressionTimestampDates(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Name: "test.fmp12", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Status", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "First Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Modified", Type: "TIMESTAMP"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Amount", Type: "NUMBER"},
+			{EmptyOK: true, MaxRepeat: 2, Name: "Tags", Type: "TEXT"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Found: 1, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Active"}}, {Data: []string{"Ann"}}, {Data: []string{"3/15/2024 1:30:00 PM"}}, {Data: []string{"12.5"}}, {Data: []string{"", ""}}}},
+		}},
+	}
+
+	// A date is midnight at the start of the day when it is compared with a timestamp
+	var tests = []struct {
+		source string
+		want   string
+	}{
+		{`Modified >= date("2024-01-01")`, `true`},
+		{`Modified > date("2024-03-15")`, `true`},
+		{`date("2024-03-16") > Modified`, `true`},
+		{`Modified == date("2024-03-15")`, `false`},
+		{`timestamp("2024-03-15T00:00:00") == date("2024-03-15")`, `true`},
+		{`Modified < date("2024-03-15")`, `false`},
+	}
+
+	for _, tt := range tests {
+		expression, err := sample.CompileExpression(tt.source)
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.source, err)
+			continue
+		}
+
+		have, err := expression.Evaluate(sample.ResultSet.Rows[0])
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.source, err)
+			continue
+		}
+
+		compareJSON(t, have, json.RawMessage(tt.want))
+	}
+
+	// Times still can't be compared with dates
+	if _, err := sample.CompileExpression(`time("13:30:00") < date("2024-03-15")`); err == nil {
+		t.Error("Err is nil for comparing a time with a date")
+	}
+}
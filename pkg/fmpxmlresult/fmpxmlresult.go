@@ -1,9 +1,5 @@
 package fmpxmlresult
 
-import (
-	"encoding/json"
-)
-
 // This is the internal FMPXMLResult data format, but with sane data types after conversion
 
 type Product struct {
@@ -48,7 +44,7 @@ type Row struct {
 }
 
 // Record is the normalized output for easy JSON work. The value may be a scalar or an array, based on the field repeat
-type Record map[string]json.RawMessage
+type Record map[string]Value
 
 type FMPXMLResult struct {
 	ErrorCode int        `json:"errorCode"`
@@ -62,11 +58,44 @@ type FMPXMLResult struct {
 	RecordIDField string `json:"-"`
 	ModIDField    string `json:"-"`
 
+	// Whether the ID fields come first or last when records are written, either IDFieldsFirst or IDFieldsLast. Defaults to first
+	IDFieldsPosition string `json:"-"`
+
+	// Which fields are written. The zero value keeps every field
+	Projection Projection `json:"-"`
+
+	// If set, only rows this expression is true for are kept. It is checked against the METADATA before any rows are read
+	Filter string `json:"-"`
+
+	// Values computed from the other fields of each row, written after them in each record
+	Derived []DerivedField `json:"-"`
+
+	// How field names become record keys. The zero value keeps the METADATA names
+	Naming NamingOptions `json:"-"`
+
+	// What to do when two fields would be written under the same key, one of CollisionPolicies. Defaults to CollisionLastWins
+	KeyCollisions string `json:"-"`
+
+	// Keys no field may be written under, such as the columns a database keeps the IDs in. They collide like the ID fields do
+	ReservedKeys []string `json:"-"`
+
+	// If set, these take priority over the type Filemaker declared for the named fields
+	TypeMapping TypeMapping `json:"-"`
+
+	// If set, CONTAINER fields are parsed into structured references instead of being passed through as strings
+	Containers *ContainerOptions `json:"-"`
+
 	Records []Record `json:"records,omitempty"`
 
+	// Problems PopulateRecords worked around, such as key collisions left to CollisionLastWins
+	Warnings []string `json:"-"`
+
 	// These are used while populating the records
 	dataEncoders         map[string]dataEncoder // The data encoders are how we change a DATE into a date, or a NUMBER into a number
 	positionalColumnData []columnarData         // The positional column data includes both the column name and how to translate that particular field into a JSON object
+	filteredRows         []Row                  // The rows the Filter dropped, which are still part of the State, so they aren't taken for deleted records
+	unfiltered           *ResultSet             // The result set the Filter was run on
+	filtered             *ResultSet             // The result set the Filter made, which is put back to the unfiltered one when filtering again
 }
 
 // This will hold the information we need to reference by field order when iterating through the columns of a row
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"testing"
 
-	"github.com/go-test/deep"
 	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
 )
 
@@ -60,7 +59,7 @@ func Test_Populate(t *testing.T) {
 	}
 
 	// Records should be quoted as raw messages
-	expectedRecords := []fmpxmlresult.Record{
+	expectedRecords := []map[string]json.RawMessage{
 		{
 			"First":           json.RawMessage(`"Adam"`),
 			"Last":            json.RawMessage(`"Peacock"`),
@@ -75,9 +74,7 @@ func Test_Populate(t *testing.T) {
 		},
 	}
 
-	for _, diff := range deep.Equal(sample.Records, expectedRecords) {
-		t.Error(diff)
-	}
+	compareJSON(t, sample.Records, expectedRecords)
 }
 
 func Test_InvalidNumber(t *testing.T) {
@@ -0,0 +1,159 @@
+package fmpxmlresult
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/timeconv"
+)
+
+// This file has the type inference for TEXT fields, for when nobody has had time to write a type mapping
+
+// TypeInference is the proposed type for a single TEXT field
+type TypeInference struct {
+	Name       string  `json:"name"`
+	Kind       string  `json:"kind"`       // One of integer, decimal, date, timestamp, boolean, or text
+	Confidence float64 `json:"confidence"` // The fraction of non-empty values that matched the kind
+	Values     int     `json:"values"`     // The number of non-empty values that were examined
+}
+
+// The mapping type each inferred kind turns into. Text isn't here, since it doesn't need a mapping
+var inferredMappingTypes = map[string]string{
+	"integer":   "NUMBER",
+	"decimal":   "NUMBER",
+	"date":      "DATE",
+	"timestamp": "TIMESTAMP",
+	"boolean":   "BOOLEAN",
+}
+
+var (
+	integerPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+	decimalPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)?\.[0-9]+$`)
+)
+
+// A kind checker says if a single value could be of the given kind
+type kindChecker struct {
+	kind    string
+	matches func(string) bool
+}
+
+// InferTypes will scan the TEXT fields without a type mapping, and propose a type for each one.
+// If maxRows is more than zero, only that many rows are examined.
+func (fmp *FMPXMLResult) InferTypes(maxRows int) []TypeInference {
+	checkers := fmp.getKindCheckers()
+
+	rows := fmp.ResultSet.Rows
+
+	if maxRows > 0 && maxRows < len(rows) {
+		rows = rows[:maxRows]
+	}
+
+	out := []TypeInference{}
+
+	for i, field := range fmp.Metadata.Fields {
+		if field.Type != "TEXT" || !fmp.Projection.Includes(field.Name) {
+			continue
+		}
+
+		if _, found := fmp.TypeMapping[field.Name]; found {
+			continue // Somebody already told us what this is
+		}
+
+		matches := make([]int, len(checkers))
+		values := 0
+
+		for _, row := range rows {
+			if i >= len(row.Cols) {
+				continue // Column mismatches are reported when the records are populated
+			}
+
+			for _, datum := range row.Cols[i].Data {
+				if datum == "" {
+					continue
+				}
+
+				values++
+
+				for j, checker := range checkers {
+					if checker.matches(datum) {
+						matches[j]++
+					}
+				}
+			}
+		}
+
+		inference := TypeInference{Name: field.Name, Kind: "text", Values: values}
+
+		// Checkers are ordered most specific first, so only a strictly better match replaces an earlier one
+		best := 0
+
+		for j, checker := range checkers {
+			if matches[j] > best {
+				best = matches[j]
+				inference.Kind = checker.kind
+			}
+		}
+
+		if values > 0 {
+			inference.Confidence = float64(best) / float64(values)
+		}
+
+		if inference.Kind == "text" {
+			inference.Confidence = 1
+		}
+
+		out = append(out, inference)
+	}
+
+	return out
+}
+
+// InferredTypeMapping will turn the inferred types into a type mapping, leaving out text fields and anything below the minimum confidence
+func InferredTypeMapping(inferences []TypeInference, minConfidence float64) TypeMapping {
+	out := TypeMapping{}
+
+	for _, inference := range inferences {
+		mappedType, found := inferredMappingTypes[inference.Kind]
+
+		if !found || inference.Confidence < minConfidence {
+			continue
+		}
+
+		out[inference.Name] = FieldMapping{Type: mappedType}
+	}
+
+	return out
+}
+
+// Get the kind checkers, most specific first, using the date and time formats from the file
+func (fmp *FMPXMLResult) getKindCheckers() []kindChecker {
+	dateFormat := timeconv.ParseDateFormat(fmp.Database.DateFormat)
+	timestampFormat := dateFormat + " " + timeconv.ParseTimeFormat(fmp.Database.TimeFormat)
+
+	return []kindChecker{
+		{"boolean", isBooleanWord},
+		{"integer", integerPattern.MatchString},
+		{"decimal", func(s string) bool { return integerPattern.MatchString(s) || decimalPattern.MatchString(s) }},
+		{"date", getTimeChecker(dateFormat)},
+		{"timestamp", getTimeChecker(timestampFormat)},
+	}
+}
+
+// Only the words count here: a column of ones and zeros is more likely a number than a boolean
+func isBooleanWord(s string) bool {
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no":
+		return true
+	}
+
+	return false
+}
+
+func getTimeChecker(format string) func(string) bool {
+	return func(s string) bool {
+		_, err := time.Parse(format, s)
+
+		return err == nil
+	}
+}
@@ -0,0 +1,128 @@
+package fmpxmlresult_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_InferTypes(t *testing.T) {
+	metadata := fmpxmlresult.Metadata{
+		Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Count", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Price", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Zip", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 2, Name: "Hired", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Updated", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Active", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Mostly", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Declared", Type: "NUMBER"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Mapped", Type: "TEXT"},
+		},
+	}
+
+	database := fmpxmlresult.Database{
+		DateFormat: "M/d/yyyy",
+		Layout:     "Overview",
+		Name:       "test.fmp12",
+		Records:    2,
+		TimeFormat: "h:mm:ss a",
+	}
+
+	resultSet := fmpxmlresult.ResultSet{
+		Found: 2,
+		Rows: []fmpxmlresult.Row{
+			{ModID: "1", RecordID: "1", Cols: []fmpxmlresult.Col{
+				{Data: []string{"Adam"}},
+				{Data: []string{"42"}},
+				{Data: []string{"4"}},
+				{Data: []string{"02134"}},
+				{Data: []string{"1/11/1986", ""}},
+				{Data: []string{"7/4/2019 8:09:21 PM"}},
+				{Data: []string{"Yes"}},
+				{Data: []string{"12"}},
+				{Data: []string{"1"}},
+				{Data: []string{"1"}},
+			}},
+			{ModID: "1", RecordID: "2", Cols: []fmpxmlresult.Col{
+				{Data: []string{"Brian"}},
+				{Data: []string{""}},
+				{Data: []string{"4.25"}},
+				{Data: []string{"10001"}},
+				{Data: []string{"2/3/2001", "4/5/2006"}},
+				{Data: []string{"7/5/2019 9:00:00 AM"}},
+				{Data: []string{"no"}},
+				{Data: []string{"many"}},
+				{Data: []string{"2"}},
+				{Data: []string{"2"}},
+			}},
+		},
+	}
+
+	sample := fmpxmlresult.FMPXMLResult{
+		Database:    &database,
+		Metadata:    &metadata,
+		ResultSet:   &resultSet,
+		TypeMapping: fmpxmlresult.TypeMapping{"Mapped": {Type: "STRING"}},
+	}
+
+	inferred := sample.InferTypes(0)
+
+	expected := []fmpxmlresult.TypeInference{
+		{Name: "Name", Kind: "text", Confidence: 1, Values: 2},
+		{Name: "Count", Kind: "integer", Confidence: 1, Values: 1},
+		{Name: "Price", Kind: "decimal", Confidence: 1, Values: 2},
+		{Name: "Zip", Kind: "integer", Confidence: 0.5, Values: 2},
+		{Name: "Hired", Kind: "date", Confidence: 1, Values: 3},
+		{Name: "Updated", Kind: "timestamp", Confidence: 1, Values: 2},
+		{Name: "Active", Kind: "boolean", Confidence: 1, Values: 2},
+		{Name: "Mostly", Kind: "integer", Confidence: 0.5, Values: 2},
+	}
+
+	for _, diff := range deep.Equal(inferred, expected) {
+		t.Error(diff)
+	}
+
+	mapping := fmpxmlresult.InferredTypeMapping(inferred, 1)
+
+	expectedMapping := fmpxmlresult.TypeMapping{
+		"Count":   {Type: "NUMBER"},
+		"Price":   {Type: "NUMBER"},
+		"Hired":   {Type: "DATE"},
+		"Updated": {Type: "TIMESTAMP"},
+		"Active":  {Type: "BOOLEAN"},
+	}
+
+	for _, diff := range deep.Equal(mapping, expectedMapping) {
+		t.Error(diff)
+	}
+
+	// The written mapping has to be readable as an override file
+	buf := &bytes.Buffer{}
+
+	if err := fmpxmlresult.WriteTypeMapping(buf, mapping); err != nil {
+		t.Error(err)
+		return
+	}
+
+	reread, err := fmpxmlresult.ReadTypeMapping(buf)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal(reread, expectedMapping) {
+		t.Error(diff)
+	}
+
+	// Only looking at the first row makes the mostly numeric field look like an integer
+	for _, inference := range sample.InferTypes(1) {
+		if inference.Name == "Mostly" && (inference.Kind != "integer" || inference.Confidence != 1) {
+			t.Errorf("Unexpected sampled inference: %+v", inference)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package fmpxmlresult
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// This file works out the key each field is written under, and what happens when two of them want the same one
+
+// What to do when two fields, or a field and an ID field, would be written under the same key
+const (
+	CollisionError    = "error"   // Fail, naming the fields involved
+	CollisionSuffix   = "suffix"  // Later fields get a numbered suffix, like Name_2
+	CollisionQualify  = "qualify" // The colliding fields are qualified with their table occurrence, like Contacts::Name, and suffixed if that isn't enough
+	CollisionLastWins = "last"    // The last field written wins, with a warning, but fields still move off the ID fields' keys
+)
+
+// CollisionPolicies are all the valid key collision policies
+var CollisionPolicies = []string{CollisionError, CollisionSuffix, CollisionQualify, CollisionLastWins}
+
+// OutputField is a METADATA field along with the key its values are written under
+type OutputField struct {
+	Key   string
+	Field Field
+}
+
+// FieldKeys will get the key each METADATA field is written under, in field order. Fields left out by the Projection have an empty key.
+// The names are changed according to Naming, and then any collisions are resolved according to KeyCollisions.
+// The record and modification ID fields and any ReservedKeys always keep their names, so unless the policy is CollisionError,
+// a field that collides with one of them is the one that moves. With CollisionLastWins, fields can still share a key with each other
+func (fmp *FMPXMLResult) FieldKeys() ([]string, error) {
+	keys, _, err := fmp.fieldKeys()
+
+	return keys, err
+}
+
+// OutputFields will get the fields with the keys they're written under, in field order.
+// A key is only used once: with CollisionLastWins, the last field for a key takes the place of the first
+func (fmp *FMPXMLResult) OutputFields() ([]OutputField, error) {
+	keys, err := fmp.FieldKeys()
+
+	if err != nil {
+		return nil, err
+	}
+
+	out := []OutputField{}
+	positions := map[string]int{}
+
+	for i, field := range fmp.Metadata.Fields {
+		if keys[i] == "" {
+			continue
+		}
+
+		if position, found := positions[keys[i]]; found {
+			out[position].Field = field
+			continue
+		}
+
+		positions[keys[i]] = len(out)
+		out = append(out, OutputField{Key: keys[i], Field: field})
+	}
+
+	return out, nil
+}
+
+// Get the keys, along with a warning for each collision that was left in place
+func (fmp *FMPXMLResult) fieldKeys() ([]string, []string, error) {
+	if fmp.Metadata == nil {
+		return nil, nil, nil
+	}
+
+	if err := fmp.Naming.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := fmp.Projection.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	fields := fmp.Metadata.Fields
+	keys := make([]string, len(fields))
+
+	for i, field := range fields {
+		if !fmp.Projection.Includes(field.Name) {
+			continue
+		}
+
+		keys[i] = fmp.Naming.Key(field.Name)
+
+		if keys[i] == "" {
+			return nil, nil, fmt.Errorf("Field %d '%s' has an empty key after renaming", i, field.Name)
+		}
+	}
+
+	policy := fmp.KeyCollisions
+
+	// Letting the last field win, with a warning, is what happened before there were any other policies
+	if policy == "" {
+		policy = CollisionLastWins
+	}
+
+	switch policy {
+	case CollisionError:
+		if collisions := fmp.collisions(keys); len(collisions) > 0 {
+			return nil, nil, fmt.Errorf("%s", collisions[0])
+		}
+	case CollisionLastWins:
+		fmp.moveFromIDKeys(keys)
+
+		return keys, fmp.collisions(keys), nil
+	case CollisionQualify:
+		counts := fmp.keyCounts(keys)
+
+		for i, field := range fields {
+			if keys[i] != "" && counts[keys[i]] > 1 {
+				keys[i] = fmp.qualifiedKey(field, keys[i])
+
+				if keys[i] == "" {
+					return nil, nil, fmt.Errorf("Field %d '%s' has an empty key after qualifying", i, field.Name)
+				}
+			}
+		}
+
+		fmp.suffixCollisions(keys)
+	case CollisionSuffix:
+		fmp.suffixCollisions(keys)
+	default:
+		return nil, nil, fmt.Errorf("Unknown key collision policy '%s'", policy)
+	}
+
+	return keys, nil, nil
+}
+
+// A key that no field can have, such as a set ID field, and what to call it in messages
+type idKey struct {
+	key   string
+	label string
+}
+
+func (fmp *FMPXMLResult) idKeys() []idKey {
+	out := []idKey{}
+
+	if fmp.RecordIDField != "" {
+		out = append(out, idKey{fmp.RecordIDField, "the record ID"})
+	}
+
+	if fmp.ModIDField != "" {
+		out = append(out, idKey{fmp.ModIDField, "the modification ID"})
+	}
+
+	// An ID field can have a reserved key of its own, since the ID fields aren't written as fields
+	for _, key := range fmp.ReservedKeys {
+		if key != "" && key != fmp.RecordIDField && key != fmp.ModIDField {
+			out = append(out, idKey{key, "a reserved key"})
+		}
+	}
+
+	return out
+}
+
+// How many times each key is used, including by the ID fields
+func (fmp *FMPXMLResult) keyCounts(keys []string) map[string]int {
+	out := map[string]int{}
+
+	for _, id := range fmp.idKeys() {
+		out[id.key]++
+	}
+
+	for _, key := range keys {
+		if key != "" {
+			out[key]++
+		}
+	}
+
+	return out
+}
+
+// Describe every key that is used more than once, in the order the keys are written
+func (fmp *FMPXMLResult) collisions(keys []string) []string {
+	counts := fmp.keyCounts(keys)
+	ids := fmp.idKeys()
+	ordered := []string{}
+
+	for _, id := range ids {
+		ordered = append(ordered, id.key)
+	}
+
+	out := []string{}
+	described := map[string]bool{}
+
+	for _, key := range append(ordered, keys...) {
+		if counts[key] < 2 || described[key] {
+			continue
+		}
+
+		described[key] = true
+		users := []string{}
+
+		for _, id := range ids {
+			if id.key == key {
+				users = append(users, id.label)
+			}
+		}
+
+		for i, other := range keys {
+			if other == key {
+				users = append(users, fmt.Sprintf("field %d '%s'", i, fmp.Metadata.Fields[i].Name))
+			}
+		}
+
+		out = append(out, fmt.Sprintf("Key '%s' is used by %s", key, strings.Join(users, " and ")))
+	}
+
+	return out
+}
+
+// Give every key after the first one that collides a numbered suffix, skipping any that another field already has
+func (fmp *FMPXMLResult) suffixCollisions(keys []string) {
+	taken := map[string]bool{}
+	wanted := map[string]bool{}
+
+	for _, id := range fmp.idKeys() {
+		taken[id.key] = true
+	}
+
+	for _, key := range keys {
+		wanted[key] = true
+	}
+
+	for i, key := range keys {
+		if key == "" {
+			continue
+		}
+
+		if !taken[key] {
+			taken[key] = true
+			continue
+		}
+
+		for n := 2; ; n++ {
+			suffixed := key + "_" + strconv.Itoa(n)
+
+			if !taken[suffixed] && !wanted[suffixed] {
+				keys[i] = suffixed
+				taken[suffixed] = true
+				break
+			}
+		}
+	}
+}
+
+// Give every key that an ID field or reserved key already has a numbered suffix, leaving any other collisions alone.
+// Fields that shared one of those keys share the suffixed key too, so the last of them still wins
+func (fmp *FMPXMLResult) moveFromIDKeys(keys []string) {
+	taken := map[string]bool{}
+	ids := map[string]bool{}
+
+	for _, id := range fmp.idKeys() {
+		taken[id.key] = true
+		ids[id.key] = true
+	}
+
+	for _, key := range keys {
+		taken[key] = true
+	}
+
+	moved := map[string]string{}
+
+	for i, key := range keys {
+		if !ids[key] {
+			continue
+		}
+
+		if _, found := moved[key]; !found {
+			for n := 2; ; n++ {
+				suffixed := key + "_" + strconv.Itoa(n)
+
+				if !taken[suffixed] {
+					moved[key] = suffixed
+					taken[suffixed] = true
+					break
+				}
+			}
+		}
+
+		keys[i] = moved[key]
+	}
+}
+
+// Qualify a key with the field's table occurrence. Fields on the layout's own table don't have one in the export,
+// so the database name is used instead, which is what Filemaker calls the first table in a file
+func (fmp *FMPXMLResult) qualifiedKey(f Field, key string) string {
+	occurrence := tableOccurrence(f.Name)
+
+	if occurrence == "" && fmp.Database != nil {
+		occurrence = strings.TrimSuffix(fmp.Database.Name, filepath.Ext(fmp.Database.Name))
+	}
+
+	if occurrence == "" {
+		return key
+	}
+
+	return fmp.Naming.qualifiedKey(occurrence, f.Name)
+}
+
+// Related fields are exported as TableOccurrence::Field
+func tableOccurrence(name string) string {
+	if i := strings.Index(name, "::"); i >= 0 {
+		return name[:i]
+	}
+
+	return ""
+}
@@ -0,0 +1,165 @@
+package fmpxmlresult_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_FieldKeys(t *testing.T) {
+	database := fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", TimeFormat: "h:mm:ss a"}
+
+	// Two copies of a field, a field named like the record ID field, and a field that already looks suffixed
+	metadata := fmpxmlresult.Metadata{
+		Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Number", Type: "NUMBER"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "id", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Name_2", Type: "TEXT"},
+		},
+	}
+
+	var tests = []struct {
+		policy string
+		want   []string
+	}{
+		{fmpxmlresult.CollisionSuffix, []string{"Name", "Number", "Name_3", "id_2", "Name_2"}},
+		{fmpxmlresult.CollisionQualify, []string{"test::Name", "Number", "test::Name_2", "test::id", "Name_2"}},
+		{fmpxmlresult.CollisionLastWins, []string{"Name", "Number", "Name", "id_2", "Name_2"}},
+		{"", []string{"Name", "Number", "Name", "id_2", "Name_2"}},
+	}
+
+	for _, tt := range tests {
+		sample := fmpxmlresult.FMPXMLResult{Database: &database, Metadata: &metadata, RecordIDField: "id", KeyCollisions: tt.policy}
+		keys, err := sample.FieldKeys()
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.policy, err)
+			continue
+		}
+
+		for _, diff := range deep.Equal(keys, tt.want) {
+			t.Errorf("%s: %s", tt.policy, diff)
+		}
+	}
+
+	for _, policy := range []string{fmpxmlresult.CollisionError, "pie"} {
+		sample := fmpxmlresult.FMPXMLResult{Database: &database, Metadata: &metadata, RecordIDField: "id", KeyCollisions: policy}
+
+		if _, err := sample.FieldKeys(); err == nil {
+			t.Errorf("Err is nil for policy '%s'", policy)
+		}
+	}
+
+	// Reserved keys move fields out of the way like the ID fields do, even when an ID field has the same key
+	reserved := fmpxmlresult.FMPXMLResult{
+		Database:      &database,
+		Metadata:      &metadata,
+		RecordIDField: "id",
+		KeyCollisions: fmpxmlresult.CollisionSuffix,
+		ReservedKeys:  []string{"Number", "id"},
+	}
+
+	keys, err := reserved.FieldKeys()
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, diff := range deep.Equal(keys, []string{"Name", "Number_2", "Name_3", "id_2", "Name_2"}) {
+		t.Error(diff)
+	}
+
+	// Letting the last field win only applies between fields
+	reserved.KeyCollisions = fmpxmlresult.CollisionLastWins
+
+	if keys, err = reserved.FieldKeys(); err != nil {
+		t.Error(err)
+	}
+
+	for _, diff := range deep.Equal(keys, []string{"Name", "Number_2", "Name", "id_2", "Name_2"}) {
+		t.Error(diff)
+	}
+
+	// Qualifying uses the table occurrence a related field already has
+	related := fmpxmlresult.FMPXMLResult{
+		Database: &database,
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Invoices::Total", Type: "NUMBER"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Total", Type: "NUMBER"},
+		}},
+		RecordIDField: "Total",
+		KeyCollisions: fmpxmlresult.CollisionQualify,
+	}
+
+	keys, err = related.FieldKeys()
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal(keys, []string{"Invoices::Total", "test::Total"}) {
+		t.Error(diff)
+	}
+}
+
+func Test_PopulateCollisions(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", Records: 1, TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Name", Type: "NUMBER"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Found: 1, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Ann"}}, {Data: []string{"1"}}}},
+		}},
+	}
+
+	// Without a policy, the last field wins like it always has, but with a warning
+	if err := sample.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	compareJSON(t, sample.Records, []map[string]json.RawMessage{{"Name": json.RawMessage(`1`)}})
+
+	if len(sample.Warnings) != 1 {
+		t.Errorf("Expected a warning, got %v", sample.Warnings)
+	}
+
+	sample.KeyCollisions = fmpxmlresult.CollisionError
+
+	if err := sample.PopulateRecords(); err == nil {
+		t.Error("Err is nil for colliding keys")
+	}
+
+	sample.KeyCollisions = fmpxmlresult.CollisionSuffix
+
+	if err := sample.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	compareJSON(t, sample.Records, []map[string]json.RawMessage{{"Name": json.RawMessage(`"Ann"`), "Name_2": json.RawMessage(`1`)}})
+
+	if len(sample.Warnings) != 0 {
+		t.Errorf("Unexpected warnings: %v", sample.Warnings)
+	}
+
+	sample.KeyCollisions = fmpxmlresult.CollisionLastWins
+
+	if err := sample.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	compareJSON(t, sample.Records, []map[string]json.RawMessage{{"Name": json.RawMessage(`1`)}})
+
+	for _, diff := range deep.Equal(sample.Warnings, []string{"Key 'Name' is used by field 0 'Name' and field 1 'Name'"}) {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,160 @@
+package fmpxmlresult
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/timeconv"
+	"gopkg.in/yaml.v3"
+)
+
+// This file has the per-field type overrides, for when the type declared in Filemaker isn't the type we actually want
+
+// FieldMapping overrides the type Filemaker declared for a single field.
+// Format is a Filemaker style date, time, or timestamp format, used to parse TEXT fields holding dates.
+// If it is empty, the DATEFORMAT and TIMEFORMAT from the file are used.
+type FieldMapping struct {
+	Type   string `json:"type" yaml:"type"`
+	Format string `json:"format,omitempty" yaml:"format,omitempty"`
+}
+
+// TypeMapping is the set of field overrides, keyed by the Filemaker field name
+type TypeMapping map[string]FieldMapping
+
+// The types that can be given in a field mapping
+var mappableTypes = map[string]bool{
+	"TEXT":      true,
+	"STRING":    true,
+	"NUMBER":    true,
+	"DATE":      true,
+	"TIME":      true,
+	"TIMESTAMP": true,
+	"BOOLEAN":   true,
+	"JSON":      true,
+	"CONTAINER": true,
+}
+
+// The types that use the format
+var formattedTypes = map[string]bool{
+	"DATE":      true,
+	"TIME":      true,
+	"TIMESTAMP": true,
+}
+
+// ReadTypeMapping will read a JSON type mapping, in the form of {"Field Name": {"type": "NUMBER"}}
+func ReadTypeMapping(r io.Reader) (TypeMapping, error) {
+	out := TypeMapping{}
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&out); err != nil {
+		return nil, fmt.Errorf("Could not decode type mapping: %v", err)
+	}
+
+	return out.normalize()
+}
+
+// ReadYAMLTypeMapping will read a type mapping written as YAML, with the same structure as the JSON one
+func ReadYAMLTypeMapping(r io.Reader) (TypeMapping, error) {
+	out := TypeMapping{}
+
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&out); err != nil {
+		return nil, fmt.Errorf("Could not decode type mapping: %v", err)
+	}
+
+	return out.normalize()
+}
+
+// Normalize the type names so "number" and "NUMBER" are the same thing, and make sure they're all known
+func (tm TypeMapping) normalize() (TypeMapping, error) {
+	for name, m := range tm {
+		m.Type = strings.ToUpper(m.Type)
+		tm[name] = m
+	}
+
+	if err := tm.Validate(); err != nil {
+		return nil, err
+	}
+
+	return tm, nil
+}
+
+// WriteTypeMapping will write the type mapping in the same format ReadTypeMapping reads
+func WriteTypeMapping(w io.Writer, tm TypeMapping) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(tm)
+}
+
+// Validate will make sure every mapping has a type we know how to produce
+func (tm TypeMapping) Validate() error {
+	for name, m := range tm {
+		if err := m.validate(); err != nil {
+			return fmt.Errorf("Invalid mapping for field '%s': %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (m FieldMapping) validate() error {
+	if !mappableTypes[m.Type] {
+		return fmt.Errorf("Unknown type '%s'", m.Type)
+	}
+
+	if m.Format != "" && !formattedTypes[m.Type] {
+		return fmt.Errorf("A format cannot be used with type %s", m.Type)
+	}
+
+	return nil
+}
+
+// OutputType will get the type a field is converted as, which is the mapped type if there is one, or the declared type otherwise
+func (fmp *FMPXMLResult) OutputType(f Field) string {
+	if m, found := fmp.TypeMapping[f.Name]; found {
+		return m.Type
+	}
+
+	return f.Type
+}
+
+// Get the data encoder for a mapped field
+func (fmp *FMPXMLResult) getMappedEncoder(m FieldMapping) (dataEncoder, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	switch m.Type {
+	case "TEXT", "STRING":
+		return encodeString, nil
+	case "NUMBER":
+		return nullIfEmpty(encodeNumber), nil
+	case "BOOLEAN":
+		return nullIfEmpty(encodeBoolean), nil
+	case "JSON":
+		return nullIfEmpty(encodeJSON), nil
+	case "CONTAINER":
+		return getContainerEncoder(fmp.getContainerOptions()), nil
+	}
+
+	// Everything left is a date or time of some sort - if there is no explicit format, use the one the file declared
+	if m.Format == "" {
+		return nullIfEmpty(fmp.dataEncoders[m.Type]), nil
+	}
+
+	switch m.Type {
+	case "DATE":
+		return nullIfEmpty(getTimeEncoder(timeconv.ParseDateFormat(m.Format), DateKind)), nil
+	case "TIME":
+		return nullIfEmpty(getTimeEncoder(timeconv.ParseTimeFormat(m.Format), TimeKind)), nil
+	default:
+		return nullIfEmpty(getTimeEncoder(timeconv.ParseTimestampFormat(m.Format), TimestampKind)), nil
+	}
+}
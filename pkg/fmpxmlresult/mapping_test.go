@@ -0,0 +1,232 @@
+package fmpxmlresult_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_ReadTypeMapping(t *testing.T) {
+	input := strings.NewReader(`{
+		"Zip": {"type": "string"},
+		"Started": {"type": "DATE", "format": "yyyy-MM-dd"}
+	}`)
+
+	mapping, err := fmpxmlresult.ReadTypeMapping(input)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := fmpxmlresult.TypeMapping{
+		"Zip":     {Type: "STRING"},
+		"Started": {Type: "DATE", Format: "yyyy-MM-dd"},
+	}
+
+	for _, diff := range deep.Equal(mapping, expected) {
+		t.Error(diff)
+	}
+}
+
+func Test_ReadYAMLTypeMapping(t *testing.T) {
+	input := strings.NewReader(`
+Zip:
+  type: string
+"Started":
+  type: DATE
+  format: yyyy-MM-dd
+`)
+
+	mapping, err := fmpxmlresult.ReadYAMLTypeMapping(input)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := fmpxmlresult.TypeMapping{
+		"Zip":     {Type: "STRING"},
+		"Started": {Type: "DATE", Format: "yyyy-MM-dd"},
+	}
+
+	for _, diff := range deep.Equal(mapping, expected) {
+		t.Error(diff)
+	}
+
+	for _, input := range []string{"Zip: {type: PIE}", "Zip: {kind: NUMBER}", "- Zip"} {
+		if _, err := fmpxmlresult.ReadYAMLTypeMapping(strings.NewReader(input)); err == nil {
+			t.Errorf("No error for %s", input)
+		}
+	}
+}
+
+func Test_ReadTypeMappingInvalid(t *testing.T) {
+	inputs := []string{
+		`{"Zip": {"type": "PIE"}}`,
+		`{"Zip": {"type": "NUMBER", "format": "yyyy"}}`,
+		`{"Zip": {"kind": "NUMBER"}}`,
+		`[]`,
+	}
+
+	for _, input := range inputs {
+		if _, err := fmpxmlresult.ReadTypeMapping(strings.NewReader(input)); err == nil {
+			t.Errorf("No error for %s", input)
+		}
+	}
+}
+
+func Test_PopulateMapped(t *testing.T) {
+	metadata := fmpxmlresult.Metadata{
+		Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Zip", Type: "NUMBER"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Amount", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Started", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Hired", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Updated", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 2, Name: "Active", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Extra", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Missing", Type: "TEXT"},
+		},
+	}
+
+	database := fmpxmlresult.Database{
+		DateFormat: "M/d/yyyy",
+		Layout:     "Overview",
+		Name:       "test.fmp12",
+		Records:    1,
+		TimeFormat: "h:mm:ss a",
+	}
+
+	resultSet := fmpxmlresult.ResultSet{
+		Found: 1,
+		Rows: []fmpxmlresult.Row{
+			{ModID: "196", RecordID: "683", Cols: []fmpxmlresult.Col{
+				{Data: []string{"02134"}},
+				{Data: []string{"12.50"}},
+				{Data: []string{"1/11/1986"}},
+				{Data: []string{"2019-07-04"}},
+				{Data: []string{"7/4/2019 8:09:21 PM"}},
+				{Data: []string{"Yes", "0"}},
+				{Data: []string{`{"a": [1, 2]}`}},
+				{Data: []string{""}},
+			}},
+		},
+	}
+
+	sample := fmpxmlresult.FMPXMLResult{
+		Database:  &database,
+		Metadata:  &metadata,
+		ResultSet: &resultSet,
+
+		TypeMapping: fmpxmlresult.TypeMapping{
+			"Zip":     {Type: "STRING"},
+			"Amount":  {Type: "NUMBER"},
+			"Started": {Type: "DATE"},
+			"Hired":   {Type: "DATE", Format: "yyyy-MM-dd"},
+			"Updated": {Type: "TIMESTAMP"},
+			"Active":  {Type: "BOOLEAN"},
+			"Extra":   {Type: "JSON"},
+			"Missing": {Type: "NUMBER"},
+		},
+	}
+
+	if err := sample.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	expectedRecords := []map[string]json.RawMessage{
+		{
+			"Zip":     json.RawMessage(`"02134"`),
+			"Amount":  json.RawMessage(`12.50`),
+			"Started": json.RawMessage(`"1986-01-11"`),
+			"Hired":   json.RawMessage(`"2019-07-04"`),
+			"Updated": json.RawMessage(`"2019-07-04T20:09:21"`),
+			"Active":  json.RawMessage(`[true,false]`),
+			"Extra":   json.RawMessage(`{"a": [1, 2]}`),
+			"Missing": json.RawMessage(`null`),
+		},
+	}
+
+	compareJSON(t, sample.Records, expectedRecords)
+}
+
+func Test_PopulateMappedInvalid(t *testing.T) {
+	metadata := fmpxmlresult.Metadata{
+		Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "First", Type: "TEXT"},
+		},
+	}
+
+	database := fmpxmlresult.Database{
+		DateFormat: "M/d/yyyy",
+		Layout:     "Overview",
+		Name:       "test.fmp12",
+		Records:    1,
+		TimeFormat: "h:mm:ss a",
+	}
+
+	resultSet := fmpxmlresult.ResultSet{
+		Found: 1,
+		Rows: []fmpxmlresult.Row{
+			{ModID: "196", RecordID: "683", Cols: []fmpxmlresult.Col{
+				{Data: []string{"Adam"}},
+			}},
+		},
+	}
+
+	for _, mapping := range []fmpxmlresult.FieldMapping{{Type: "NUMBER"}, {Type: "BOOLEAN"}, {Type: "JSON"}, {Type: "PIE"}} {
+		sample := fmpxmlresult.FMPXMLResult{
+			Database:    &database,
+			Metadata:    &metadata,
+			ResultSet:   &resultSet,
+			TypeMapping: fmpxmlresult.TypeMapping{"First": mapping},
+		}
+
+		if err := sample.PopulateRecords(); err == nil {
+			t.Errorf("Err is nil for %s", mapping.Type)
+		}
+	}
+}
+
+func Test_MappedContainerSchema(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Name: "test.fmp12", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Photo", Type: "TEXT"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Found: 1, Rows: []fmpxmlresult.Row{
+			{ModID: "1", RecordID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"image:Photos/a.jpg"}}}},
+		}},
+
+		// Mapping a field to CONTAINER parses it even without container options, so the schema has to say so too
+		TypeMapping: fmpxmlresult.TypeMapping{"Photo": {Type: "CONTAINER"}},
+	}
+
+	if err := sample.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if kind := sample.Records[0]["Photo"].Kind(); kind != fmpxmlresult.ObjectKind {
+		t.Errorf("Photo is %s, not an object", kind)
+	}
+
+	if !sample.StructuredContainer(sample.Metadata.Fields[0]) {
+		t.Error("Photo is not a structured container")
+	}
+
+	schema := sample.FieldSchema(sample.Metadata.Fields[0])
+
+	for _, diff := range deep.Equal(schema.Type, []string{"object", "null"}) {
+		t.Error(diff)
+	}
+
+	for _, diff := range deep.Equal(schema.Title, "ContainerReference") {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,129 @@
+package fmpxmlresult
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// This file has the merging of several exports of the same table, such as found set slices, into one
+
+// CheckCompatible will return an error describing how two exports differ, if they can't be merged.
+// The fields must match in name, type, and repetitions, and the date and time formats must be the same
+func (fmp *FMPXMLResult) CheckCompatible(other *FMPXMLResult) error {
+	if fmp.Database == nil || other.Database == nil {
+		return fmt.Errorf("Missing DATABASE element")
+	}
+
+	if fmp.Metadata == nil || other.Metadata == nil {
+		return fmt.Errorf("Missing METADATA element")
+	}
+
+	if fmp.Database.DateFormat != other.Database.DateFormat {
+		return fmt.Errorf("Date format mismatch: have '%s', got '%s'", fmp.Database.DateFormat, other.Database.DateFormat)
+	}
+
+	if fmp.Database.TimeFormat != other.Database.TimeFormat {
+		return fmt.Errorf("Time format mismatch: have '%s', got '%s'", fmp.Database.TimeFormat, other.Database.TimeFormat)
+	}
+
+	fields := fmp.Metadata.Fields
+	otherFields := other.Metadata.Fields
+
+	if len(fields) != len(otherFields) {
+		return fmt.Errorf("Field count mismatch: have %d, got %d", len(fields), len(otherFields))
+	}
+
+	for i, field := range fields {
+		otherField := otherFields[i]
+
+		if field.Name != otherField.Name {
+			return fmt.Errorf("Field %d name mismatch: have '%s', got '%s'", i, field.Name, otherField.Name)
+		}
+
+		if field.Type != otherField.Type {
+			return fmt.Errorf("Field '%s' type mismatch: have %s, got %s", field.Name, field.Type, otherField.Type)
+		}
+
+		if field.MaxRepeat != otherField.MaxRepeat {
+			return fmt.Errorf("Field '%s' repetition mismatch: have %d, got %d", field.Name, field.MaxRepeat, otherField.MaxRepeat)
+		}
+	}
+
+	return nil
+}
+
+// Merge will combine several exports of the same table into one, with the rows in the order given.
+// The product, database and metadata come from the first export, with the database record count set to the merged rows.
+// If dropDuplicates is set, only one row is kept for each record ID: the one with the highest modification ID, or the first one on a tie
+func Merge(parts []*FMPXMLResult, dropDuplicates bool) (*FMPXMLResult, error) {
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("Nothing to merge")
+	}
+
+	first := parts[0]
+
+	// Checking the first export against itself catches a missing element before it gets copied
+	if err := first.CheckCompatible(first); err != nil {
+		return nil, fmt.Errorf("Export 0 cannot be merged: %v", err)
+	}
+
+	database := *first.Database
+
+	out := FMPXMLResult{
+		ErrorCode: first.ErrorCode,
+		Product:   first.Product,
+		Database:  &database,
+		Metadata:  first.Metadata,
+		ResultSet: &ResultSet{},
+	}
+
+	// Where each record ID ended up in the output rows, for duplicate removal
+	positions := map[string]int{}
+
+	for i, part := range parts {
+		if err := first.CheckCompatible(part); err != nil {
+			return nil, fmt.Errorf("Export %d does not match export 0: %v", i, err)
+		}
+
+		// An export without a RESULTSET has no rows to add
+		if part.ResultSet == nil {
+			continue
+		}
+
+		for _, row := range part.ResultSet.Rows {
+			if !dropDuplicates {
+				out.ResultSet.Rows = append(out.ResultSet.Rows, row)
+				continue
+			}
+
+			position, found := positions[row.RecordID]
+
+			if !found {
+				positions[row.RecordID] = len(out.ResultSet.Rows)
+				out.ResultSet.Rows = append(out.ResultSet.Rows, row)
+				continue
+			}
+
+			if idGreater(row.ModID, out.ResultSet.Rows[position].ModID) {
+				out.ResultSet.Rows[position] = row
+			}
+		}
+	}
+
+	out.ResultSet.Found = len(out.ResultSet.Rows)
+	out.Database.Records = len(out.ResultSet.Rows)
+
+	return &out, nil
+}
+
+// Record and modification IDs are numbers in practice, but they're stored as strings, so fall back to a string comparison
+func idGreater(a, b string) bool {
+	aInt, aErr := strconv.ParseInt(a, 10, 64)
+	bInt, bErr := strconv.ParseInt(b, 10, 64)
+
+	if aErr == nil && bErr == nil {
+		return aInt > bInt
+	}
+
+	return a > b
+}
@@ -0,0 +1,131 @@
+package fmpxmlresult_test
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_Merge(t *testing.T) {
+	metadata := fmpxmlresult.Metadata{
+		Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Number", Type: "NUMBER"},
+		},
+	}
+
+	first := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", Records: 10, TimeFormat: "h:mm:ss a"},
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Found: 2, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Ann"}}, {Data: []string{"1"}}}},
+			{RecordID: "2", ModID: "9", Cols: []fmpxmlresult.Col{{Data: []string{"Bob"}}, {Data: []string{"2"}}}},
+		}},
+	}
+
+	second := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", Records: 10, TimeFormat: "h:mm:ss a"},
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Found: 3, Rows: []fmpxmlresult.Row{
+			{RecordID: "2", ModID: "10", Cols: []fmpxmlresult.Col{{Data: []string{"Bobby"}}, {Data: []string{"2"}}}},
+			{RecordID: "3", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Cat"}}, {Data: []string{"3"}}}},
+			{RecordID: "1", ModID: "4", Cols: []fmpxmlresult.Col{{Data: []string{"Annie"}}, {Data: []string{"1"}}}},
+		}},
+	}
+
+	parts := []*fmpxmlresult.FMPXMLResult{&first, &second}
+
+	merged, err := fmpxmlresult.Merge(parts, false)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if merged.ResultSet.Found != 5 || len(merged.ResultSet.Rows) != 5 {
+		t.Errorf("Expected 5 rows, got %d found and %d rows", merged.ResultSet.Found, len(merged.ResultSet.Rows))
+	}
+
+	deduped, err := fmpxmlresult.Merge(parts, true)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Record 2 takes the later modification, but record 1 keeps the earlier row since it has the higher modification ID
+	expectedRows := []fmpxmlresult.Row{
+		first.ResultSet.Rows[0],
+		second.ResultSet.Rows[0],
+		second.ResultSet.Rows[1],
+	}
+
+	for _, diff := range deep.Equal(deduped.ResultSet.Rows, expectedRows) {
+		t.Error(diff)
+	}
+
+	if deduped.ResultSet.Found != 3 || deduped.Database.Records != 3 {
+		t.Errorf("Expected 3 found and 3 database records, got %d and %d", deduped.ResultSet.Found, deduped.Database.Records)
+	}
+
+	// The first export keeps its own record count
+	if first.Database.Records != 10 {
+		t.Errorf("The first export's record count changed to %d", first.Database.Records)
+	}
+
+	// The merged result should convert just like a single export
+	if err := deduped.PopulateRecords(); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_MergeMismatch(t *testing.T) {
+	database := fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", Records: 1, TimeFormat: "h:mm:ss a"}
+	name := fmpxmlresult.Field{EmptyOK: true, MaxRepeat: 1, Name: "Name", Type: "TEXT"}
+	number := fmpxmlresult.Field{EmptyOK: true, MaxRepeat: 1, Name: "Number", Type: "NUMBER"}
+
+	first := fmpxmlresult.FMPXMLResult{
+		Database:  &database,
+		Metadata:  &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{name, number}},
+		ResultSet: &fmpxmlresult.ResultSet{},
+	}
+
+	otherDates := database
+	otherDates.DateFormat = "d.M.yyyy"
+
+	mismatches := []fmpxmlresult.FMPXMLResult{
+		{Database: &database, Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{name, {EmptyOK: true, MaxRepeat: 1, Name: "Count", Type: "NUMBER"}}}},
+		{Database: &database, Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{name, {EmptyOK: true, MaxRepeat: 1, Name: "Number", Type: "TEXT"}}}},
+		{Database: &database, Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{name, {EmptyOK: true, MaxRepeat: 2, Name: "Number", Type: "NUMBER"}}}},
+		{Database: &database, Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{name}}},
+		{Database: &otherDates, Metadata: first.Metadata},
+	}
+
+	for i := range mismatches {
+		mismatches[i].ResultSet = &fmpxmlresult.ResultSet{}
+		parts := []*fmpxmlresult.FMPXMLResult{&first, &mismatches[i]}
+
+		if _, err := fmpxmlresult.Merge(parts, false); err == nil {
+			t.Errorf("Mismatch %d: err is nil", i)
+		}
+	}
+
+	// Exports without their DATABASE or METADATA can't be checked, so they can't be merged
+	noDatabase := fmpxmlresult.FMPXMLResult{Metadata: first.Metadata, ResultSet: &fmpxmlresult.ResultSet{}}
+	noMetadata := fmpxmlresult.FMPXMLResult{Database: &database, ResultSet: &fmpxmlresult.ResultSet{}}
+
+	for i, incomplete := range []*fmpxmlresult.FMPXMLResult{&noDatabase, &noMetadata} {
+		if _, err := fmpxmlresult.Merge([]*fmpxmlresult.FMPXMLResult{&first, incomplete}, false); err == nil {
+			t.Errorf("Incomplete %d: err is nil", i)
+		}
+
+		if _, err := fmpxmlresult.Merge([]*fmpxmlresult.FMPXMLResult{incomplete}, false); err == nil {
+			t.Errorf("Incomplete %d first: err is nil", i)
+		}
+	}
+
+	if _, err := fmpxmlresult.Merge(nil, false); err == nil {
+		t.Error("Empty merge: err is nil")
+	}
+}
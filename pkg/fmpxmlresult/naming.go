@@ -0,0 +1,205 @@
+package fmpxmlresult
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// This file turns field names into record keys, like "Contacts::First Name" into "first_name"
+
+// The case transforms for record keys
+const (
+	CaseSnake = "snake" // first_name
+	CaseCamel = "camel" // firstName
+	CaseKebab = "kebab" // first-name
+	CaseLower = "lower" // first name
+)
+
+// Cases are all the valid case transforms
+var Cases = []string{CaseSnake, CaseCamel, CaseKebab, CaseLower}
+
+// NamingOptions control how field names become record keys. The zero value keeps the names from the METADATA
+type NamingOptions struct {
+	Renames              map[string]string // Keys for particular fields, by their METADATA name. These are used as given
+	StripTableOccurrence bool              // Drop the TableOccurrence:: prefix from related fields
+	Case                 string            // One of Cases, or empty to keep the case as it is
+	Identifiers          bool              // Remove anything that isn't a letter, digit or underscore, and start with an underscore rather than a digit
+}
+
+// Validate will make sure the case transform is known
+func (n NamingOptions) Validate() error {
+	if n.Case == "" {
+		return nil
+	}
+
+	for _, c := range Cases {
+		if n.Case == c {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Unknown case '%s'", n.Case)
+}
+
+// ReadRenames will read a JSON rename map, in the form of {"Field Name": "field_name"}
+func ReadRenames(r io.Reader) (map[string]string, error) {
+	out := map[string]string{}
+
+	if err := json.NewDecoder(r).Decode(&out); err != nil {
+		return nil, fmt.Errorf("Could not decode renames: %v", err)
+	}
+
+	for name, key := range out {
+		if key == "" {
+			return nil, fmt.Errorf("Field '%s' is renamed to an empty key", name)
+		}
+	}
+
+	return out, nil
+}
+
+// Key will get the record key for a field name, before any collisions are resolved
+func (n NamingOptions) Key(name string) string {
+	if rename, found := n.Renames[name]; found {
+		return rename
+	}
+
+	if n.StripTableOccurrence {
+		name = unqualifiedName(name)
+	}
+
+	return n.transform(name)
+}
+
+// The key for a field qualified with a table occurrence. The occurrence is transformed like any other key,
+// but a renamed field keeps its new name as given, so only the occurrence and its separator change
+func (n NamingOptions) qualifiedKey(occurrence, name string) string {
+	rename, found := n.Renames[name]
+
+	if !found {
+		return n.transform(occurrence + "::" + unqualifiedName(name))
+	}
+
+	return n.transform(occurrence) + n.separator() + rename
+}
+
+// What the transform puts between words, and so between a table occurrence and the field name
+func (n NamingOptions) separator() string {
+	switch n.Case {
+	case CaseSnake:
+		return "_"
+	case CaseKebab:
+		return "-"
+	case CaseCamel:
+		return ""
+	}
+
+	if n.Identifiers {
+		return ""
+	}
+
+	return "::"
+}
+
+func (n NamingOptions) transform(name string) string {
+	words := SplitWords(name)
+
+	switch n.Case {
+	case CaseSnake:
+		name = strings.ToLower(strings.Join(words, "_"))
+	case CaseKebab:
+		name = strings.ToLower(strings.Join(words, "-"))
+	case CaseCamel:
+		name = camelCase(words)
+	case CaseLower:
+		name = strings.ToLower(name)
+	}
+
+	if n.Identifiers {
+		name = identifier(name)
+	}
+
+	return name
+}
+
+// SplitWords will split a name into words, on anything that isn't a letter or digit, and on case changes.
+// A run of capitals is one word, so "HTTPServer ID" is "HTTP", "Server", and "ID"
+func SplitWords(name string) []string {
+	out := []string{}
+	current := []rune{}
+
+	flush := func() {
+		if len(current) > 0 {
+			out = append(out, string(current))
+			current = []rune{}
+		}
+	}
+
+	runes := []rune(name)
+
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+
+		if i > 0 && unicode.IsUpper(r) {
+			previous := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+			if unicode.IsLower(previous) || (unicode.IsUpper(previous) && nextLower) {
+				flush()
+			}
+		}
+
+		current = append(current, r)
+	}
+
+	flush()
+
+	return out
+}
+
+func camelCase(words []string) string {
+	out := ""
+
+	for i, word := range words {
+		runes := []rune(strings.ToLower(word))
+
+		if i > 0 {
+			runes[0] = unicode.ToUpper(runes[0])
+		}
+
+		out += string(runes)
+	}
+
+	return out
+}
+
+func identifier(name string) string {
+	out := []rune{}
+
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			out = append(out, r)
+		}
+	}
+
+	if len(out) > 0 && unicode.IsDigit(out[0]) {
+		out = append([]rune{'_'}, out...)
+	}
+
+	return string(out)
+}
+
+// The field name without its table occurrence
+func unqualifiedName(name string) string {
+	if i := strings.Index(name, "::"); i >= 0 {
+		return name[i+2:]
+	}
+
+	return name
+}
@@ -0,0 +1,139 @@
+package fmpxmlresult_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_NamingKey(t *testing.T) {
+	var tests = []struct {
+		naming fmpxmlresult.NamingOptions
+		input  string
+		want   string
+	}{
+		{fmpxmlresult.NamingOptions{}, "Contacts::First Name", "Contacts::First Name"},
+		{fmpxmlresult.NamingOptions{Case: fmpxmlresult.CaseSnake}, "First Name", "first_name"},
+		{fmpxmlresult.NamingOptions{Case: fmpxmlresult.CaseSnake}, "Contacts::First Name", "contacts_first_name"},
+		{fmpxmlresult.NamingOptions{Case: fmpxmlresult.CaseSnake, StripTableOccurrence: true}, "Contacts::First Name", "first_name"},
+		{fmpxmlresult.NamingOptions{Case: fmpxmlresult.CaseCamel}, "HTTPServer ID", "httpServerId"},
+		{fmpxmlresult.NamingOptions{Case: fmpxmlresult.CaseKebab}, "firstName (legal)", "first-name-legal"},
+		{fmpxmlresult.NamingOptions{Case: fmpxmlresult.CaseLower}, "First Name", "first name"},
+		{fmpxmlresult.NamingOptions{Identifiers: true}, "Amount ($)", "Amount"},
+		{fmpxmlresult.NamingOptions{Identifiers: true}, "2nd Phone", "_2ndPhone"},
+		{fmpxmlresult.NamingOptions{Case: fmpxmlresult.CaseSnake, Renames: map[string]string{"First Name": "Given"}}, "First Name", "Given"},
+	}
+
+	for _, tt := range tests {
+		if have := tt.naming.Key(tt.input); have != tt.want {
+			t.Errorf("Key of '%s': have '%s', want '%s'", tt.input, have, tt.want)
+		}
+	}
+
+	if err := (fmpxmlresult.NamingOptions{Case: "shouting"}).Validate(); err == nil {
+		t.Error("Err is nil for an unknown case")
+	}
+}
+
+func Test_PopulateRenamed(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", Records: 1, TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Contacts::First Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Invoices::First Name", Type: "NUMBER"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Found: 1, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Ann"}}, {Data: []string{"1"}}}},
+		}},
+	}
+
+	sample.RecordIDField = "record_id"
+	sample.Naming = fmpxmlresult.NamingOptions{Case: fmpxmlresult.CaseSnake, StripTableOccurrence: true}
+	sample.KeyCollisions = fmpxmlresult.CollisionError
+
+	// Stripping the table occurrences makes the names collide
+	if err := sample.PopulateRecords(); err == nil {
+		t.Error("Err is nil for colliding renamed keys")
+	}
+
+	sample.KeyCollisions = fmpxmlresult.CollisionQualify
+
+	if err := sample.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	compareJSON(t, sample.OrderedRecord(sample.Records[0]), json.RawMessage(`{"record_id":"1","contacts_first_name":"Ann","invoices_first_name":1}`))
+
+	// The schema uses the same keys
+	schema, err := sample.RecordSchema(fmpxmlresult.SchemaOptions{})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal(schema.Required, []string{"record_id", "contacts_first_name", "invoices_first_name"}) {
+		t.Error(diff)
+	}
+}
+
+func Test_QualifyRenamed(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Contacts::First Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Invoices::First Name", Type: "NUMBER"},
+		}},
+		KeyCollisions: fmpxmlresult.CollisionQualify,
+	}
+
+	// A rename is used as given, so only the table occurrence goes through the case transform
+	cases := []struct {
+		naming   fmpxmlresult.NamingOptions
+		expected []string
+	}{
+		{fmpxmlresult.NamingOptions{Case: fmpxmlresult.CaseSnake}, []string{"contacts_Given Name", "invoices_Given Name"}},
+		{fmpxmlresult.NamingOptions{Case: fmpxmlresult.CaseKebab}, []string{"contacts-Given Name", "invoices-Given Name"}},
+		{fmpxmlresult.NamingOptions{Case: fmpxmlresult.CaseCamel}, []string{"contactsGiven Name", "invoicesGiven Name"}},
+		{fmpxmlresult.NamingOptions{}, []string{"Contacts::Given Name", "Invoices::Given Name"}},
+	}
+
+	for _, c := range cases {
+		c.naming.Renames = map[string]string{"Contacts::First Name": "Given Name", "Invoices::First Name": "Given Name"}
+		sample.Naming = c.naming
+
+		keys, err := sample.FieldKeys()
+
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		for _, diff := range deep.Equal(keys, c.expected) {
+			t.Errorf("%s: %s", c.naming.Case, diff)
+		}
+	}
+}
+
+func Test_ReadRenames(t *testing.T) {
+	renames, err := fmpxmlresult.ReadRenames(strings.NewReader(`{"First Name": "first"}`))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal(renames, map[string]string{"First Name": "first"}) {
+		t.Error(diff)
+	}
+
+	for _, input := range []string{`{"First Name": ""}`, `[]`, `{"First Name": 1}`} {
+		if _, err := fmpxmlresult.ReadRenames(strings.NewReader(input)); err == nil {
+			t.Errorf("No error for %s", input)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package fmpxmlresult
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// This file keeps record keys in the layout's field order when writing JSON, instead of the sorted order maps get
+
+// Where the record and modification ID fields go among the record keys
+const (
+	IDFieldsFirst = "first"
+	IDFieldsLast  = "last"
+)
+
+// RecordKeys will get the keys records are written with, in order: the fields in METADATA order and then the derived fields,
+// with the record and modification ID fields first or last according to IDFieldsPosition
+func (fmp *FMPXMLResult) RecordKeys() []string {
+	ids := []string{}
+
+	for _, name := range []string{fmp.RecordIDField, fmp.ModIDField} {
+		if name != "" {
+			ids = append(ids, name)
+		}
+	}
+
+	fields := []string{}
+
+	if len(fmp.positionalColumnData) > 0 {
+		for _, column := range fmp.positionalColumnData {
+			if column.encoder != nil {
+				fields = append(fields, column.name)
+			}
+		}
+	} else if keys, err := fmp.FieldKeys(); err == nil {
+		// Without populated records, an unresolved collision leaves the fields in sorted order
+		for _, key := range keys {
+			if key != "" {
+				fields = append(fields, key)
+			}
+		}
+	}
+
+	fields = append(fields, fmp.derivedKeys()...)
+
+	var candidates []string
+
+	if fmp.IDFieldsPosition == IDFieldsLast {
+		candidates = append(fields, ids...)
+	} else {
+		candidates = append(ids, fields...)
+	}
+
+	// A field could share its name with an ID field, and it only gets written once
+	out := []string{}
+	seen := map[string]bool{}
+
+	for _, name := range candidates {
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+
+	return out
+}
+
+// OrderedRecord will get a record as an object value with its keys in RecordKeys order.
+// Keys that aren't fields, such as the deleted marker on a tombstone, come after the rest in sorted order.
+// The keys are worked out on every call, so ordering a lot of records is better done with OrderRecord
+func (fmp *FMPXMLResult) OrderedRecord(r Record) Value {
+	return OrderRecord(r, fmp.RecordKeys())
+}
+
+// OrderRecord will get a record as an object value with its keys in the given order, like from RecordKeys,
+// followed by any other keys in sorted order
+func OrderRecord(r Record, keys []string) Value {
+	members := make([]Member, 0, len(r))
+	seen := map[string]bool{}
+
+	for _, key := range keys {
+		if value, found := r[key]; found {
+			seen[key] = true
+			members = append(members, Member{Key: key, Value: value})
+		}
+	}
+
+	extra := []string{}
+
+	for key := range r {
+		if !seen[key] {
+			extra = append(extra, key)
+		}
+	}
+
+	sort.Strings(extra)
+
+	for _, key := range extra {
+		members = append(members, Member{Key: key, Value: r[key]})
+	}
+
+	return NewObject(members...)
+}
+
+// MarshalJSON will write the export with each record's keys in RecordKeys order
+func (fmp FMPXMLResult) MarshalJSON() ([]byte, error) {
+	// The plain type has the same fields without this method, so marshaling it doesn't recurse
+	type plain FMPXMLResult
+
+	var records []Value
+
+	if fmp.Records != nil {
+		keys := fmp.RecordKeys()
+		records = make([]Value, len(fmp.Records))
+
+		for i, record := range fmp.Records {
+			records[i] = OrderRecord(record, keys)
+		}
+	}
+
+	out := plain(fmp)
+	out.Metadata, out.ResultSet = fmp.projectedOriginal()
+
+	return json.Marshal(struct {
+		plain
+		Records []Value `json:"records,omitempty"`
+	}{out, records})
+}
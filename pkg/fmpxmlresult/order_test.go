@@ -0,0 +1,69 @@
+package fmpxmlresult_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_RecordOrder(t *testing.T) {
+	metadata := fmpxmlresult.Metadata{
+		Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Zebra", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Apple", Type: "NUMBER"},
+		},
+	}
+
+	var tests = []struct {
+		position string
+		want     string
+	}{
+		{"", `{"recordID":"1","modID":"5","Zebra":"Stripes","Apple":1}`},
+		{fmpxmlresult.IDFieldsFirst, `{"recordID":"1","modID":"5","Zebra":"Stripes","Apple":1}`},
+		{fmpxmlresult.IDFieldsLast, `{"Zebra":"Stripes","Apple":1,"recordID":"1","modID":"5"}`},
+	}
+
+	for _, tt := range tests {
+		sample := fmpxmlresult.FMPXMLResult{
+			Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", Records: 1, TimeFormat: "h:mm:ss a"},
+			Metadata: &metadata,
+			ResultSet: &fmpxmlresult.ResultSet{Found: 1, Rows: []fmpxmlresult.Row{
+				{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Stripes"}}, {Data: []string{"1"}}}},
+			}},
+
+			RecordIDField:    "recordID",
+			ModIDField:       "modID",
+			IDFieldsPosition: tt.position,
+		}
+
+		if err := sample.PopulateRecords(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		compareJSON(t, sample.OrderedRecord(sample.Records[0]), json.RawMessage(tt.want))
+		compareJSON(t, fmpxmlresult.OrderRecord(sample.Records[0], sample.RecordKeys()), json.RawMessage(tt.want))
+
+		// Tombstones only have some of the keys, and the deleted marker goes last
+		sample.Records = append(sample.Records, sample.Tombstone("3", ""))
+
+		// Only the records matter here
+		sample.Database = nil
+		sample.Metadata = nil
+		sample.ResultSet = nil
+
+		encoded, err := json.Marshal(sample)
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		want := `{"errorCode":0,"records":[` + tt.want + `,{"recordID":"3","_deleted":true}]}`
+
+		if string(encoded) != want {
+			t.Errorf("Position '%s': have %s, want %s", tt.position, encoded, want)
+		}
+	}
+}
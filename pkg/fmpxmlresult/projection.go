@@ -0,0 +1,97 @@
+package fmpxmlresult
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// This file has the field projection, which chooses the fields that are written
+
+// Projection chooses which fields are written, by their METADATA name. The zero value keeps every field.
+// A pattern is a glob, where * matches anything and ? matches a single character, or a regular expression between slashes, like /^g_/
+type Projection struct {
+	Include  []string // If any are given, only fields matching one of these are kept
+	Exclude  []string // Fields matching any of these are dropped, even if they were included
+	Metadata bool     // Whether the projection also applies to the metadata and result set in the full output
+}
+
+// Validate will make sure every pattern compiles
+func (p Projection) Validate() error {
+	for _, pattern := range append(append([]string{}, p.Include...), p.Exclude...) {
+		if _, err := compilePattern(pattern); err != nil {
+			return fmt.Errorf("Invalid pattern '%s': %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// Includes will get whether a field is written. Invalid patterns never match
+func (p Projection) Includes(name string) bool {
+	if len(p.Include) > 0 && !matchAny(p.Include, name) {
+		return false
+	}
+
+	return !matchAny(p.Exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if re, err := compilePattern(pattern); err == nil && re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Regular expressions are between slashes, and anything else is a glob that has to match the whole name
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return regexp.Compile(pattern[1 : len(pattern)-1])
+	}
+
+	glob := regexp.QuoteMeta(pattern)
+	glob = strings.ReplaceAll(glob, `\*`, ".*")
+	glob = strings.ReplaceAll(glob, `\?`, ".")
+
+	return regexp.Compile("^" + glob + "$")
+}
+
+// The metadata and result set with only the projected fields, for the full output
+func (fmp *FMPXMLResult) projectedOriginal() (*Metadata, *ResultSet) {
+	if fmp.Metadata == nil || !fmp.Projection.Metadata {
+		return fmp.Metadata, fmp.ResultSet
+	}
+
+	kept := []int{}
+	metadata := &Metadata{}
+
+	for i, field := range fmp.Metadata.Fields {
+		if fmp.Projection.Includes(field.Name) {
+			kept = append(kept, i)
+			metadata.Fields = append(metadata.Fields, field)
+		}
+	}
+
+	if fmp.ResultSet == nil {
+		return metadata, nil
+	}
+
+	resultSet := &ResultSet{Found: fmp.ResultSet.Found, Rows: make([]Row, len(fmp.ResultSet.Rows))}
+
+	for i, row := range fmp.ResultSet.Rows {
+		cols := []Col{}
+
+		for _, j := range kept {
+			if j < len(row.Cols) {
+				cols = append(cols, row.Cols[j])
+			}
+		}
+
+		resultSet.Rows[i] = Row{ModID: row.ModID, RecordID: row.RecordID, Cols: cols}
+	}
+
+	return metadata, resultSet
+}
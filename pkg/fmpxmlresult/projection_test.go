@@ -0,0 +1,76 @@
+package fmpxmlresult_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_ProjectionIncludes(t *testing.T) {
+	var tests = []struct {
+		projection fmpxmlresult.Projection
+		name       string
+		want       bool
+	}{
+		{fmpxmlresult.Projection{}, "Anything", true},
+		{fmpxmlresult.Projection{Include: []string{"Name*"}}, "Name First", true},
+		{fmpxmlresult.Projection{Include: []string{"Name*"}}, "First Name", false},
+		{fmpxmlresult.Projection{Include: []string{"Nam?"}}, "Name", true},
+		{fmpxmlresult.Projection{Include: []string{"Name"}}, "Name.First", false},
+		{fmpxmlresult.Projection{Exclude: []string{"/^g_/"}}, "g_User", false},
+		{fmpxmlresult.Projection{Exclude: []string{"/^g_/"}}, "Flag_g_", true},
+		{fmpxmlresult.Projection{Include: []string{"*"}, Exclude: []string{"Contacts::*"}}, "Contacts::Photo", false},
+	}
+
+	for _, tt := range tests {
+		if have := tt.projection.Includes(tt.name); have != tt.want {
+			t.Errorf("%v includes '%s': have %v, want %v", tt.projection, tt.name, have, tt.want)
+		}
+	}
+
+	if err := (fmpxmlresult.Projection{Exclude: []string{"/(/"}}).Validate(); err == nil {
+		t.Error("Err is nil for an invalid regular expression")
+	}
+}
+
+func Test_PopulateProjected(t *testing.T) {
+	// The second column holds "1", which isn't a date
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", Records: 1, TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Kept", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Broken", Type: "DATE"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Found: 1, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Ann"}}, {Data: []string{"1"}}}},
+		}},
+
+		RecordIDField: "id",
+	}
+
+	if err := sample.PopulateRecords(); err == nil {
+		t.Error("Err is nil for an invalid date")
+	}
+
+	// Once it's excluded, it's never looked at
+	sample.Projection = fmpxmlresult.Projection{Include: []string{"Kept"}}
+
+	if err := sample.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	compareJSON(t, sample.OrderedRecord(sample.Records[0]), json.RawMessage(`{"id":"1","Kept":"Ann"}`))
+
+	// The full output only loses the field when asked to
+	sample.Projection.Metadata = true
+	sample.Database = nil
+
+	compareJSON(t, sample, json.RawMessage(`{
+		"errorCode": 0,
+		"metadata": {"fields": [{"emptyOK": true, "maxRepeat": 1, "name": "Kept", "type": "TEXT"}]},
+		"resultSet": {"found": 1, "rows": [{"modID": "5", "recordID": "1", "cols": [{"data": ["Ann"]}]}]},
+		"records": [{"id": "1", "Kept": "Ann"}]
+	}`))
+}
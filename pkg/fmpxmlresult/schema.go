@@ -0,0 +1,299 @@
+package fmpxmlresult
+
+// This file has the JSON Schema generation, describing the output for the fields of an export and the conversion options set on it
+
+// SchemaDialect is the JSON Schema draft the generated schemas use
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// The patterns times and timestamps are written in. They have no time zone, so they can't use the RFC 3339 "time" and "date-time" formats
+const (
+	TimePattern      = `^[0-9]{2}:[0-9]{2}:[0-9]{2}$`
+	TimestampPattern = `^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}$`
+)
+
+// Schema is the subset of JSON Schema needed to describe the output
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 interface{}        `json:"type,omitempty"` // A single type name, or a list of them
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Const                interface{}        `json:"const,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// SchemaOptions are the conversion options that change the output, but aren't kept on the export itself
+type SchemaOptions struct {
+	Full       bool // Whether the metadata and result set are kept in the envelope
+	Tombstones bool // Whether records can be tombstones, such as from an incremental export
+}
+
+// RecordSchema will get the schema for a single record, such as a line of NDJSON output.
+// It fails if the field keys collide and the collision policy doesn't resolve it
+func (fmp *FMPXMLResult) RecordSchema(opts SchemaOptions) (*Schema, error) {
+	defs, err := fmp.recordDefinitions(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.Tombstones {
+		out := defs["record"]
+		out.Schema = SchemaDialect
+
+		return out, nil
+	}
+
+	return &Schema{
+		Schema: SchemaDialect,
+		Title:  fmp.schemaTitle(),
+		AnyOf:  []*Schema{{Ref: "#/$defs/record"}, {Ref: "#/$defs/tombstone"}},
+		Defs:   defs,
+	}, nil
+}
+
+// EnvelopeSchema will get the schema for the whole JSON output, with the record schema in its definitions
+func (fmp *FMPXMLResult) EnvelopeSchema(opts SchemaOptions) (*Schema, error) {
+	defs, err := fmp.recordDefinitions(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	items := &Schema{Ref: "#/$defs/record"}
+
+	if opts.Tombstones {
+		items = &Schema{AnyOf: []*Schema{{Ref: "#/$defs/record"}, {Ref: "#/$defs/tombstone"}}}
+	}
+
+	out := &Schema{
+		Schema: SchemaDialect,
+		Title:  fmp.schemaTitle(),
+		Type:   "object",
+		Properties: map[string]*Schema{
+			"errorCode": {Type: "integer"},
+			"product":   objectSchema(map[string]*Schema{"build": {Type: "string"}, "name": {Type: "string"}, "version": {Type: "string"}}),
+			"database": objectSchema(map[string]*Schema{
+				"dateFormat": {Type: "string"},
+				"timeFormat": {Type: "string"},
+				"layout":     {Type: "string"},
+				"name":       {Type: "string"},
+				"records":    {Type: "integer"},
+			}),
+			"records": {Type: "array", Items: items},
+		},
+		Required: []string{"errorCode"},
+		Defs:     defs,
+	}
+
+	if opts.Full {
+		out.Properties["metadata"] = objectSchema(map[string]*Schema{
+			"fields": {Type: "array", Items: objectSchema(map[string]*Schema{
+				"emptyOK":   {Type: "boolean"},
+				"maxRepeat": {Type: "integer"},
+				"name":      {Type: "string"},
+				"type":      {Type: "string"},
+			})},
+		})
+
+		out.Properties["resultSet"] = objectSchema(map[string]*Schema{
+			"found": {Type: "integer"},
+			"rows": {Type: "array", Items: objectSchema(map[string]*Schema{
+				"modID":    {Type: "string"},
+				"recordID": {Type: "string"},
+				"cols": {Type: "array", Items: objectSchema(map[string]*Schema{
+					"data": {Type: "array", Items: &Schema{Type: "string"}},
+				})},
+			})},
+		})
+	}
+
+	return out, nil
+}
+
+func (fmp *FMPXMLResult) recordDefinitions(opts SchemaOptions) (map[string]*Schema, error) {
+	record, err := fmp.recordDefinition()
+
+	if err != nil {
+		return nil, err
+	}
+
+	out := map[string]*Schema{"record": record}
+
+	if opts.Tombstones {
+		out["tombstone"] = fmp.tombstoneDefinition()
+	}
+
+	return out, nil
+}
+
+// Every field is always in a record under its key, along with the record and modification IDs if they are set
+func (fmp *FMPXMLResult) recordDefinition() (*Schema, error) {
+	properties := map[string]*Schema{}
+
+	for _, name := range []string{fmp.RecordIDField, fmp.ModIDField} {
+		if name != "" {
+			properties[name] = &Schema{Type: "string"}
+		}
+	}
+
+	// Only CollisionLastWins can leave a field on an ID key, and then the field is what gets written
+	if fmp.Metadata != nil {
+		fields, err := fmp.OutputFields()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range fields {
+			properties[f.Key] = fmp.FieldSchema(f.Field)
+		}
+
+		derived, err := fmp.compileDerived()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range derived {
+			properties[d.key] = fmp.derivedSchema(d.expression.typ)
+		}
+	}
+
+	required := []string{}
+
+	for _, key := range fmp.RecordKeys() {
+		if properties[key] != nil {
+			required = append(required, key)
+		}
+	}
+
+	closed := false
+
+	return &Schema{
+		Title:                fmp.schemaTitle(),
+		Type:                 "object",
+		Properties:           properties,
+		Required:             required,
+		AdditionalProperties: &closed,
+	}, nil
+}
+
+// The modification ID is left out of a tombstone when it isn't known
+func (fmp *FMPXMLResult) tombstoneDefinition() *Schema {
+	recordIDField, modIDField := fmp.tombstoneFields()
+	closed := false
+
+	return &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			DeletedField:  {Const: true},
+			recordIDField: {Type: "string"},
+			modIDField:    {Type: "string"},
+		},
+		Required:             []string{DeletedField, recordIDField},
+		AdditionalProperties: &closed,
+	}
+}
+
+// FieldSchema will get the schema for the output of a single field. Repeating fields are arrays.
+// A single value is null when the export has no DATA for it, which can happen whether or not the field allows empty values.
+// A repetition is only null when it is empty and the field's converted type turns that into null, like an overridden NUMBER
+func (fmp *FMPXMLResult) FieldSchema(f Field) *Schema {
+	out := fmp.valueSchema(fmp.OutputType(f))
+
+	if fmp.StructuredContainer(f) {
+		out = containerSchema()
+	}
+
+	if f.MaxRepeat <= 1 {
+		return nullable(out)
+	}
+
+	if fmp.emptyIsNull(f) {
+		out = nullable(out)
+	}
+
+	maxItems := f.MaxRepeat
+
+	return &Schema{Type: "array", Items: out, MaxItems: &maxItems}
+}
+
+// Whether an empty DATA element becomes null. Declared types other than text fail on it instead, apart from parsed containers
+func (fmp *FMPXMLResult) emptyIsNull(f Field) bool {
+	if m, found := fmp.TypeMapping[f.Name]; found {
+		return m.Type != "TEXT" && m.Type != "STRING"
+	}
+
+	return fmp.StructuredContainer(f)
+}
+
+// Let a schema with a single type be null too
+func nullable(s *Schema) *Schema {
+	if name, ok := s.Type.(string); ok {
+		s.Type = []string{name, "null"}
+	}
+
+	return s
+}
+
+// Get the schema for a single value of a type
+func (fmp *FMPXMLResult) valueSchema(fmType string) *Schema {
+	switch fmType {
+	case "NUMBER":
+		return &Schema{Type: "number"}
+	case "BOOLEAN":
+		return &Schema{Type: "boolean"}
+	case "DATE":
+		return &Schema{Type: "string", Format: "date"}
+	case "TIME":
+		return &Schema{Type: "string", Pattern: TimePattern}
+	case "TIMESTAMP":
+		return &Schema{Type: "string", Pattern: TimestampPattern}
+	case "JSON":
+		// Anything goes, so there's no type to make nullable
+		return &Schema{}
+	}
+
+	return &Schema{Type: "string"}
+}
+
+func containerSchema() *Schema {
+	out := objectSchema(map[string]*Schema{
+		"kind":     {Type: "string", Enum: []string{"image", "file", "movie"}},
+		"filename": {Type: "string"},
+		"path":     {Type: "string"},
+		"url":      {Type: "string"},
+		"width":    {Type: "integer"},
+		"height":   {Type: "integer"},
+		"size":     {Type: "integer"},
+		"sha256":   {Type: "string"},
+		"data":     {Type: "string"},
+		"missing":  {Type: "boolean"},
+	})
+
+	out.Title = "ContainerReference"
+	out.Required = []string{"kind", "filename"}
+
+	return out
+}
+
+func objectSchema(properties map[string]*Schema) *Schema {
+	return &Schema{Type: "object", Properties: properties}
+}
+
+func (fmp *FMPXMLResult) schemaTitle() string {
+	if fmp.Database == nil {
+		return ""
+	}
+
+	return fmp.Database.Name
+}
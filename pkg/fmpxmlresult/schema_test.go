@@ -0,0 +1,173 @@
+package fmpxmlresult_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+// Compare a schema with the expected JSON, regardless of formatting
+func compareSchema(t *testing.T, schema *fmpxmlresult.Schema, expected string) {
+	encoded, err := json.Marshal(schema)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var have, want interface{}
+
+	if err := json.Unmarshal(encoded, &have); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := json.Unmarshal([]byte(expected), &want); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal(have, want) {
+		t.Error(diff)
+	}
+}
+
+func Test_RecordSchema(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "Name", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Age", Type: "NUMBER", MaxRepeat: 1, EmptyOK: true},
+			{Name: "Born", Type: "DATE", MaxRepeat: 1},
+			{Name: "Updated", Type: "TIMESTAMP", MaxRepeat: 1},
+			{Name: "Phones", Type: "TEXT", MaxRepeat: 3, EmptyOK: true},
+			{Name: "Active", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Alarm", Type: "TIME", MaxRepeat: 1},
+			{Name: "Scores", Type: "TEXT", MaxRepeat: 2},
+		}},
+		RecordIDField: "id",
+		TypeMapping:   fmpxmlresult.TypeMapping{"Active": {Type: "BOOLEAN"}, "Scores": {Type: "NUMBER"}},
+	}
+
+	schema, err := sample.RecordSchema(fmpxmlresult.SchemaOptions{})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	compareSchema(t, schema, `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "Contacts.fmp12",
+		"type": "object",
+		"properties": {
+			"id": {"type": "string"},
+			"Name": {"type": ["string", "null"]},
+			"Age": {"type": ["number", "null"]},
+			"Born": {"type": ["string", "null"], "format": "date"},
+			"Updated": {"type": ["string", "null"], "pattern": "^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}$"},
+			"Phones": {"type": "array", "items": {"type": "string"}, "maxItems": 3},
+			"Active": {"type": ["boolean", "null"]},
+			"Alarm": {"type": ["string", "null"], "pattern": "^[0-9]{2}:[0-9]{2}:[0-9]{2}$"},
+			"Scores": {"type": "array", "items": {"type": ["number", "null"]}, "maxItems": 2}
+		},
+		"required": ["id", "Name", "Age", "Born", "Updated", "Phones", "Active", "Alarm", "Scores"],
+		"additionalProperties": false
+	}`)
+}
+
+func Test_EnvelopeSchema(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "Name", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Age", Type: "NUMBER", MaxRepeat: 1, EmptyOK: true},
+			{Name: "Born", Type: "DATE", MaxRepeat: 1},
+			{Name: "Updated", Type: "TIMESTAMP", MaxRepeat: 1},
+			{Name: "Phones", Type: "TEXT", MaxRepeat: 3, EmptyOK: true},
+			{Name: "Active", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Alarm", Type: "TIME", MaxRepeat: 1},
+			{Name: "Scores", Type: "TEXT", MaxRepeat: 2},
+		}},
+		RecordIDField: "id",
+		TypeMapping:   fmpxmlresult.TypeMapping{"Active": {Type: "BOOLEAN"}, "Scores": {Type: "NUMBER"}},
+	}
+
+	schema, err := sample.EnvelopeSchema(fmpxmlresult.SchemaOptions{Tombstones: true})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	compareSchema(t, schema.Properties["records"], `{
+		"type": "array",
+		"items": {"anyOf": [{"$ref": "#/$defs/record"}, {"$ref": "#/$defs/tombstone"}]}
+	}`)
+
+	compareSchema(t, schema.Defs["tombstone"], `{
+		"type": "object",
+		"properties": {
+			"_deleted": {"const": true},
+			"id": {"type": "string"},
+			"modID": {"type": "string"}
+		},
+		"required": ["_deleted", "id"],
+		"additionalProperties": false
+	}`)
+
+	if _, found := schema.Properties["resultSet"]; found {
+		t.Error("The result set is only in the full output")
+	}
+
+	full, err := sample.EnvelopeSchema(fmpxmlresult.SchemaOptions{Full: true})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, name := range []string{"metadata", "resultSet"} {
+		if _, found := full.Properties[name]; !found {
+			t.Errorf("%s is missing from the full output", name)
+		}
+	}
+}
+
+func Test_SchemaCollisions(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "Name", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Age", Type: "NUMBER", MaxRepeat: 1, EmptyOK: true},
+			{Name: "Born", Type: "DATE", MaxRepeat: 1},
+			{Name: "Updated", Type: "TIMESTAMP", MaxRepeat: 1},
+			{Name: "Phones", Type: "TEXT", MaxRepeat: 3, EmptyOK: true},
+			{Name: "Active", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Alarm", Type: "TIME", MaxRepeat: 1},
+			{Name: "Scores", Type: "TEXT", MaxRepeat: 2},
+		}},
+		RecordIDField: "id",
+		TypeMapping:   fmpxmlresult.TypeMapping{"Active": {Type: "BOOLEAN"}, "Scores": {Type: "NUMBER"}},
+	}
+
+	sample.Metadata.Fields = append(sample.Metadata.Fields, fmpxmlresult.Field{Name: "Name", Type: "NUMBER", MaxRepeat: 1})
+	sample.KeyCollisions = fmpxmlresult.CollisionError
+
+	if _, err := sample.RecordSchema(fmpxmlresult.SchemaOptions{}); err == nil {
+		t.Error("Err is nil for colliding keys")
+	}
+
+	sample.KeyCollisions = fmpxmlresult.CollisionSuffix
+
+	schema, err := sample.RecordSchema(fmpxmlresult.SchemaOptions{})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	compareSchema(t, schema.Properties["Name_2"], `{"type": ["number", "null"]}`)
+}
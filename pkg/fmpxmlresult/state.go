@@ -0,0 +1,382 @@
+package fmpxmlresult
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// This file has the incremental export support, which remembers the last modification ID seen for each record
+
+// State is the last modification ID seen for each record ID
+type State map[string]string
+
+// DeletedField is the key the deleted marker goes in on a tombstone record
+const DeletedField = "_deleted"
+
+// ReadState will read a state in the format written by WriteState
+func ReadState(r io.Reader) (State, error) {
+	out := State{}
+
+	if err := json.NewDecoder(r).Decode(&out); err != nil {
+		return nil, fmt.Errorf("Could not decode state: %v", err)
+	}
+
+	return out, nil
+}
+
+// WriteState will write a state as a JSON object of record IDs to modification IDs
+func WriteState(w io.Writer, s State) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(s)
+}
+
+// ReadIDList will read a plain list of record IDs, one per line, into a state.
+// Each ID can be followed by whitespace and the last known modification ID. Blank lines and lines starting with # are skipped
+func ReadIDList(r io.Reader) (State, error) {
+	out := State{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Fields(line)
+
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("Line %d: expected a record ID and an optional modification ID, got '%s'", lineNumber, line)
+		}
+
+		modID := ""
+
+		if len(parts) == 2 {
+			modID = parts[1]
+		}
+
+		out[parts[0]] = modID
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// ReadSnapshot will read the state from JSON written by this package: a state, a converted document, an array of documents,
+// or one record per line. The IDs are read from the given record and modification ID fields, or "recordID" and "modID" if those
+// aren't set, as with tombstones. Tombstones in the snapshot are skipped, since those records were already gone
+func ReadSnapshot(r io.Reader, recordIDField, modIDField string) (State, error) {
+	ids := &FMPXMLResult{RecordIDField: recordIDField, ModIDField: modIDField}
+	recordIDField, modIDField = ids.tombstoneFields()
+
+	out := State{}
+	decoder := json.NewDecoder(r)
+
+	for i := 0; ; i++ {
+		var raw json.RawMessage
+
+		if err := decoder.Decode(&raw); err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("Could not decode snapshot: %v", err)
+		}
+
+		records, isState, err := snapshotRecords(raw, recordIDField)
+
+		if err != nil {
+			return nil, fmt.Errorf("Could not decode snapshot: %v", err)
+		}
+
+		// A state file is a single object of record IDs to modification IDs
+		if isState {
+			if i > 0 || decoder.More() {
+				return nil, fmt.Errorf("Value %d is neither a document nor a record with a '%s' field", i, recordIDField)
+			}
+
+			return ReadState(bytes.NewReader(raw))
+		}
+
+		for _, record := range records {
+			if record[DeletedField].Bool() {
+				continue
+			}
+
+			recordID := record[recordIDField]
+
+			if recordID.IsNull() {
+				return nil, fmt.Errorf("Record without a '%s' field", recordIDField)
+			}
+
+			modID := ""
+
+			if value := record[modIDField]; !value.IsNull() {
+				modID = value.String()
+			}
+
+			out[recordID.String()] = modID
+		}
+	}
+}
+
+// Get the records from a single value of a snapshot, or whether it is a state instead
+func snapshotRecords(raw json.RawMessage, recordIDField string) (records []Record, isState bool, err error) {
+	type document struct {
+		Records []Record `json:"records"`
+	}
+
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+		documents := []document{}
+
+		if err := json.Unmarshal(raw, &documents); err != nil {
+			return nil, false, err
+		}
+
+		for _, d := range documents {
+			records = append(records, d.Records...)
+		}
+
+		return records, false, nil
+	}
+
+	keys := map[string]json.RawMessage{}
+
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return nil, false, err
+	}
+
+	if _, found := keys["records"]; found {
+		d := document{}
+		err := json.Unmarshal(raw, &d)
+
+		return d.Records, false, err
+	}
+
+	_, hasID := keys[recordIDField]
+	_, deleted := keys[DeletedField]
+
+	if !hasID && !deleted {
+		return nil, true, nil
+	}
+
+	record := Record{}
+	err = json.Unmarshal(raw, &record)
+
+	return []Record{record}, false, err
+}
+
+// ReadStateFile will read a state file, or return an empty state if the file doesn't exist yet
+func ReadStateFile(fileName string) (State, error) {
+	f, err := os.Open(fileName)
+
+	if os.IsNotExist(err) {
+		return State{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return ReadState(f)
+}
+
+// WriteStateFile will replace a state file atomically: the state is written to a temporary file
+// in the same directory, which is only renamed over the original once it is safely on disk
+func WriteStateFile(fileName string, s State) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*")
+
+	if err != nil {
+		return err
+	}
+
+	// Once the rename happens this fails harmlessly
+	defer os.Remove(tmp.Name())
+
+	if err := WriteState(tmp, s); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// Temporary files are only readable by their owner, which would stick after the rename
+	mode := os.FileMode(0644)
+
+	if existing, err := os.Stat(fileName); err == nil {
+		mode = existing.Mode().Perm()
+	}
+
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fileName)
+}
+
+// State will get the current state of the export. Records don't need to be populated.
+// Rows the Filter dropped are still included, since those records weren't deleted
+func (fmp *FMPXMLResult) State() State {
+	out := State{}
+
+	for _, row := range fmp.ResultSet.Rows {
+		out[row.RecordID] = row.ModID
+	}
+
+	for _, row := range fmp.filteredRows {
+		out[row.RecordID] = row.ModID
+	}
+
+	return out
+}
+
+// Tombstone will build the record that takes the place of a deleted one.
+// The IDs go in the record and modification ID fields, or "recordID" and "modID" if those aren't set.
+// If the modification ID isn't known, it is left out
+func (fmp *FMPXMLResult) Tombstone(recordID, modID string) Record {
+	recordIDField, modIDField := fmp.tombstoneFields()
+
+	out := Record{
+		DeletedField:  NewBool(true),
+		recordIDField: NewString(recordID),
+	}
+
+	if modID != "" {
+		out[modIDField] = NewString(modID)
+	}
+
+	return out
+}
+
+// TombstoneRecordID will get the record ID from a tombstone built by Tombstone. If the record isn't a tombstone, ok is false
+func (fmp *FMPXMLResult) TombstoneRecordID(r Record) (recordID string, ok bool) {
+	if !r[DeletedField].Bool() {
+		return "", false
+	}
+
+	recordIDField, _ := fmp.tombstoneFields()
+	value := r[recordIDField]
+
+	if value.Kind() != StringKind {
+		return "", false
+	}
+
+	return value.String(), true
+}
+
+// Get the fields the IDs go in on a tombstone
+func (fmp *FMPXMLResult) tombstoneFields() (recordIDField, modIDField string) {
+	recordIDField, modIDField = fmp.RecordIDField, fmp.ModIDField
+
+	if recordIDField == "" {
+		recordIDField = "recordID"
+	}
+
+	if modIDField == "" {
+		modIDField = "modID"
+	}
+
+	return recordIDField, modIDField
+}
+
+// Tombstones will build a tombstone for every record ID in the previous state that is no longer in the export, in record ID order
+func (fmp *FMPXMLResult) Tombstones(previous State) []Record {
+	seen := fmp.filteredIDs()
+
+	for _, row := range fmp.ResultSet.Rows {
+		seen[row.RecordID] = true
+	}
+
+	return fmp.tombstones(previous, seen)
+}
+
+// FilterIncremental will keep only the rows and records that are new or have a higher modification ID than in the previous state,
+// and add a tombstone record for every record ID in the previous state that is gone. Records must already be populated.
+// The tombstones come after the records, so the rows no longer line up with the records.
+func (fmp *FMPXMLResult) FilterIncremental(previous State) error {
+	if len(fmp.Records) != len(fmp.ResultSet.Rows) {
+		return fmt.Errorf("Records have not been populated")
+	}
+
+	rows := []Row{}
+	records := []Record{}
+	seen := fmp.filteredIDs()
+
+	for i, row := range fmp.ResultSet.Rows {
+		seen[row.RecordID] = true
+
+		if lastModID, found := previous[row.RecordID]; found && !idGreater(row.ModID, lastModID) {
+			continue
+		}
+
+		rows = append(rows, row)
+		records = append(records, fmp.Records[i])
+	}
+
+	records = append(records, fmp.tombstones(previous, seen)...)
+
+	fmp.ResultSet.Rows = rows
+	fmp.Records = records
+
+	return nil
+}
+
+// The record IDs of the rows the Filter dropped, which are in the export even though they aren't written
+func (fmp *FMPXMLResult) filteredIDs() map[string]bool {
+	out := map[string]bool{}
+
+	for _, row := range fmp.filteredRows {
+		out[row.RecordID] = true
+	}
+
+	return out
+}
+
+// Get the tombstones for every record in the previous state that wasn't seen, in record ID order
+func (fmp *FMPXMLResult) tombstones(previous State, seen map[string]bool) []Record {
+	deleted := []string{}
+
+	for recordID := range previous {
+		if !seen[recordID] {
+			deleted = append(deleted, recordID)
+		}
+	}
+
+	sortRecordIDs(deleted)
+
+	out := make([]Record, len(deleted))
+
+	for i, recordID := range deleted {
+		out[i] = fmp.Tombstone(recordID, previous[recordID])
+	}
+
+	return out
+}
+
+func sortRecordIDs(ids []string) {
+	sort.Slice(ids, func(i, j int) bool {
+		return idGreater(ids[j], ids[i])
+	})
+}
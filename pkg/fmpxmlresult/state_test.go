@@ -0,0 +1,204 @@
+package fmpxmlresult_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_FilterIncremental(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", Records: 3, TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Number", Type: "NUMBER"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Found: 3, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Ann"}}, {Data: []string{"1"}}}},
+			{RecordID: "2", ModID: "10", Cols: []fmpxmlresult.Col{{Data: []string{"Bob"}}, {Data: []string{"2"}}}},
+			{RecordID: "4", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Dan"}}, {Data: []string{"4"}}}},
+		}},
+
+		RecordIDField: "id",
+	}
+
+	if err := sample.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	current := sample.State()
+
+	previous := fmpxmlresult.State{"1": "5", "2": "9", "3": "7", "10": "2"}
+
+	if err := sample.FilterIncremental(previous); err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []map[string]json.RawMessage{
+		{"id": json.RawMessage(`"2"`), "Name": json.RawMessage(`"Bob"`), "Number": json.RawMessage(`2`)},
+		{"id": json.RawMessage(`"4"`), "Name": json.RawMessage(`"Dan"`), "Number": json.RawMessage(`4`)},
+		{"id": json.RawMessage(`"3"`), "modID": json.RawMessage(`"7"`), "_deleted": json.RawMessage(`true`)},
+		{"id": json.RawMessage(`"10"`), "modID": json.RawMessage(`"2"`), "_deleted": json.RawMessage(`true`)},
+	}
+
+	compareJSON(t, sample.Records, expected)
+
+	for _, diff := range deep.Equal(current, fmpxmlresult.State{"1": "5", "2": "10", "4": "1"}) {
+		t.Error(diff)
+	}
+}
+
+func Test_StateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "state.json")
+
+	// A missing state file is just the first run
+	empty, err := fmpxmlresult.ReadStateFile(fileName)
+
+	if err != nil || len(empty) != 0 {
+		t.Errorf("Expected an empty state, got %v, %v", empty, err)
+	}
+
+	for _, state := range []fmpxmlresult.State{{"1": "5"}, {"1": "6", "2": "1"}} {
+		if err := fmpxmlresult.WriteStateFile(fileName, state); err != nil {
+			t.Error(err)
+			return
+		}
+
+		reread, err := fmpxmlresult.ReadStateFile(fileName)
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		for _, diff := range deep.Equal(reread, state) {
+			t.Error(diff)
+		}
+	}
+
+	// The temporary files should all be gone
+	entries, err := ioutil.ReadDir(dir)
+
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Expected only the state file, got %d entries, %v", len(entries), err)
+	}
+
+	// A new state file is readable by everyone, and a replaced one keeps its permissions
+	stat, err := os.Stat(fileName)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stat.Mode().Perm() != 0644 {
+		t.Errorf("Expected a new state file to be 0644, got %v", stat.Mode().Perm())
+	}
+
+	if err := os.Chmod(fileName, 0640); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := fmpxmlresult.WriteStateFile(fileName, fmpxmlresult.State{"1": "7"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stat, err = os.Stat(fileName); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stat.Mode().Perm() != 0640 {
+		t.Errorf("Expected the replaced state file to stay 0640, got %v", stat.Mode().Perm())
+	}
+}
+
+func Test_Tombstones(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", Layout: "Overview", Name: "test.fmp12", Records: 2, TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{EmptyOK: true, MaxRepeat: 1, Name: "Name", Type: "TEXT"},
+			{EmptyOK: true, MaxRepeat: 1, Name: "Number", Type: "NUMBER"},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Found: 2, Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Ann"}}, {Data: []string{"1"}}}},
+			{RecordID: "4", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Dan"}}, {Data: []string{"4"}}}},
+		}},
+	}
+
+	previous, err := fmpxmlresult.ReadIDList(strings.NewReader("# Yesterday's IDs\n1 5\n\n3\n2 9\n"))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []map[string]json.RawMessage{
+		{"recordID": json.RawMessage(`"2"`), "modID": json.RawMessage(`"9"`), "_deleted": json.RawMessage(`true`)},
+		{"recordID": json.RawMessage(`"3"`), "_deleted": json.RawMessage(`true`)},
+	}
+
+	compareJSON(t, sample.Tombstones(previous), expected)
+
+	if _, err := fmpxmlresult.ReadIDList(strings.NewReader("1 5 6\n")); err == nil {
+		t.Error("Err is nil for a line with too many values")
+	}
+}
+
+func Test_ReadSnapshot(t *testing.T) {
+	inputs := map[string]string{
+		"document": `{"errorCode":0,"records":[{"id":"1","mod":"5","Name":"A"},{"id":"2","mod":"9"},{"_deleted":true,"id":"3"}]}`,
+		"array":    `[{"records":[{"id":"1","mod":"5"}]},{"records":[{"id":"2","mod":"9"}]}]`,
+		"ndjson":   "{\"id\":\"1\",\"mod\":\"5\"}\n{\"id\":\"2\",\"mod\":\"9\",\"Name\":null}\n{\"_deleted\":true,\"id\":\"3\",\"mod\":\"1\"}\n",
+		"state":    `{"1":"5","2":"9"}`,
+	}
+
+	for name, input := range inputs {
+		have, err := fmpxmlresult.ReadSnapshot(strings.NewReader(input), "id", "mod")
+
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		for _, diff := range deep.Equal(have, fmpxmlresult.State{"1": "5", "2": "9"}) {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+
+	// Without the ID fields, the defaults are the same as for tombstones, and a missing modification ID is left empty
+	have, err := fmpxmlresult.ReadSnapshot(strings.NewReader(`{"recordID":"1"}`), "", "")
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, diff := range deep.Equal(have, fmpxmlresult.State{"1": ""}) {
+		t.Error(diff)
+	}
+
+	for _, input := range []string{`{"records":[{"Name":"A"}]}`, "{\"id\":\"1\"}\n{\"Name\":\"A\"}\n", `{"1":"5"}{"2":"9"}`, `{"records":`} {
+		if _, err := fmpxmlresult.ReadSnapshot(strings.NewReader(input), "id", "mod"); err == nil {
+			t.Errorf("Err is nil for %s", input)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package fmpxmlresult
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/timeconv"
+)
+
+// This file has the decoding of rows straight into structs, without going through JSON.
+// Values are converted from the original DATA strings, using the file's date and time formats and any type mapping
+
+var timeType = reflect.TypeOf(time.Time{})
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// How a single struct field gets its value
+type fieldDecoder struct {
+	index  []int  // The struct field
+	name   string // The struct field name, for errors
+	column int    // The column in each row, or -1 for the record or modification ID
+	id     string // "recordid" or "modid", for the IDs
+	field  Field
+	fmType string // The type the field is converted as
+	layout string // The Go time layout, for dates and times
+}
+
+// Unmarshal will decode every row into the slice v points to, which can hold structs or struct pointers.
+// Struct fields are matched with tags like `fmp:"First Name"`, or by their own name if they have no tag.
+// The `fmp:",recordid"` and `fmp:",modid"` tags get the record and modification IDs, and `fmp:"-"` skips a field.
+// Supported types are strings, numbers, bools, time.Time, and anything implementing sql.Scanner, such as the sql.Null types.
+// Repeating fields go in slices, and pointers are nil for empty values
+func (fmp *FMPXMLResult) Unmarshal(v interface{}) error {
+	target := reflect.ValueOf(v)
+
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("Unmarshal needs a pointer to a slice, got %T", v)
+	}
+
+	if fmp.ResultSet == nil {
+		return fmt.Errorf("There is no result set to unmarshal")
+	}
+
+	slice := target.Elem()
+	elemType := slice.Type().Elem()
+	structType := elemType
+
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+
+	decoders, err := fmp.structDecoders(structType)
+
+	if err != nil {
+		return err
+	}
+
+	out := reflect.MakeSlice(slice.Type(), 0, len(fmp.ResultSet.Rows))
+
+	for i, row := range fmp.ResultSet.Rows {
+		item := reflect.New(structType)
+
+		if err := fmp.decodeRow(item.Elem(), row, decoders); err != nil {
+			return fmt.Errorf("Row %d: %v", i, err)
+		}
+
+		if elemType.Kind() == reflect.Ptr {
+			out = reflect.Append(out, item)
+		} else {
+			out = reflect.Append(out, item.Elem())
+		}
+	}
+
+	slice.Set(out)
+
+	return nil
+}
+
+// UnmarshalEach will decode each row in turn into the struct v points to, and call fn after each one.
+// The struct is reset before every row. Decoding stops at the first error, including one returned by fn
+func (fmp *FMPXMLResult) UnmarshalEach(v interface{}, fn func() error) error {
+	target := reflect.ValueOf(v)
+
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("UnmarshalEach needs a pointer to a struct, got %T", v)
+	}
+
+	if fmp.ResultSet == nil {
+		return fmt.Errorf("There is no result set to unmarshal")
+	}
+
+	item := target.Elem()
+	decoders, err := fmp.structDecoders(item.Type())
+
+	if err != nil {
+		return err
+	}
+
+	for i, row := range fmp.ResultSet.Rows {
+		item.Set(reflect.Zero(item.Type()))
+
+		if err := fmp.decodeRow(item, row, decoders); err != nil {
+			return fmt.Errorf("Row %d: %v", i, err)
+		}
+
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Match the struct fields up with the export's fields
+func (fmp *FMPXMLResult) structDecoders(t reflect.Type) ([]fieldDecoder, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Cannot unmarshal into %s, which is not a struct", t)
+	}
+
+	if fmp.Metadata == nil {
+		return nil, fmt.Errorf("There is no metadata to match the struct fields against")
+	}
+
+	columns := map[string]int{}
+
+	for i, field := range fmp.Metadata.Fields {
+		columns[field.Name] = i
+	}
+
+	out := []fieldDecoder{}
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		// Unexported fields can't be set
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		tag, hasTag := sf.Tag.Lookup("fmp")
+
+		if tag == "-" {
+			continue
+		}
+
+		name, option := tag, ""
+
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, option = tag[:comma], tag[comma+1:]
+		}
+
+		d := fieldDecoder{index: sf.Index, name: sf.Name, column: -1}
+
+		switch option {
+		case "recordid", "modid":
+			d.id = option
+			out = append(out, d)
+			continue
+		case "":
+		default:
+			return nil, fmt.Errorf("Field %s has unknown tag option '%s'", sf.Name, option)
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+
+		column, found := columns[name]
+
+		// Untagged fields are only used if there is a Filemaker field by the same name, but a tag must always match
+		if !found && hasTag {
+			return nil, fmt.Errorf("Field %s is tagged '%s', which is not in the export", sf.Name, name)
+		}
+
+		if !found {
+			continue
+		}
+
+		layout, err := fmp.timeLayout(fmp.Metadata.Fields[column])
+
+		if err != nil {
+			return nil, fmt.Errorf("Field %s: %v", sf.Name, err)
+		}
+
+		d.column = column
+		d.field = fmp.Metadata.Fields[column]
+		d.fmType = fmp.OutputType(d.field)
+		d.layout = layout
+
+		if d.field.MaxRepeat > 1 && !isSlice(sf.Type) {
+			return nil, fmt.Errorf("Field %s needs to be a slice for the repeating field '%s'", sf.Name, name)
+		}
+
+		out = append(out, d)
+	}
+
+	return out, nil
+}
+
+// Get the Go layout for a date or time field, or an empty string for anything else
+func (fmp *FMPXMLResult) timeLayout(f Field) (string, error) {
+	fmType := fmp.OutputType(f)
+	format := fmp.TypeMapping[f.Name].Format
+
+	switch {
+	case fmType == "DATE" && format != "":
+		return timeconv.ParseDateFormat(format), nil
+	case fmType == "TIME" && format != "":
+		return timeconv.ParseTimeFormat(format), nil
+	case fmType == "TIMESTAMP" && format != "":
+		return timeconv.ParseTimestampFormat(format), nil
+	case fmType != "DATE" && fmType != "TIME" && fmType != "TIMESTAMP":
+		return "", nil
+	}
+
+	// Without its own format, the field uses the file's
+	if fmp.Database == nil {
+		return "", fmt.Errorf("There is no database to get the date and time formats from")
+	}
+
+	dateFormat := timeconv.ParseDateFormat(fmp.Database.DateFormat)
+	timeFormat := timeconv.ParseTimeFormat(fmp.Database.TimeFormat)
+
+	switch fmType {
+	case "DATE":
+		return dateFormat, nil
+	case "TIME":
+		return timeFormat, nil
+	}
+
+	return dateFormat + " " + timeFormat, nil
+}
+
+func isSlice(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+}
+
+func (fmp *FMPXMLResult) decodeRow(item reflect.Value, row Row, decoders []fieldDecoder) error {
+	if len(row.Cols) != len(fmp.Metadata.Fields) {
+		return fmt.Errorf("Column count mismatch: have %d, expect %d", len(row.Cols), len(fmp.Metadata.Fields))
+	}
+
+	for _, d := range decoders {
+		dst := item.FieldByIndex(d.index)
+
+		var err error
+
+		switch d.id {
+		case "recordid":
+			err = d.setScalar(dst, row.RecordID)
+		case "modid":
+			err = d.setScalar(dst, row.ModID)
+		default:
+			err = d.setValue(dst, row.Cols[d.column].Data)
+		}
+
+		if err != nil {
+			return fmt.Errorf("Field %s: %v", d.name, err)
+		}
+	}
+
+	return nil
+}
+
+func (d fieldDecoder) setValue(dst reflect.Value, data []string) error {
+	if isSlice(dst.Type()) {
+		out := reflect.MakeSlice(dst.Type(), len(data), len(data))
+
+		for i, s := range data {
+			if err := d.setScalar(out.Index(i), s); err != nil {
+				return err
+			}
+		}
+
+		dst.Set(out)
+
+		return nil
+	}
+
+	if len(data) == 0 {
+		return d.setScalar(dst, "")
+	}
+
+	// Only a slice can take more than one value, and dropping the rest would lose data
+	if len(data) > 1 {
+		return fmt.Errorf("Has %d values, which can't go in a %s", len(data), dst.Type())
+	}
+
+	return d.setScalar(dst, data[0])
+}
+
+func (d fieldDecoder) setScalar(dst reflect.Value, s string) error {
+	if dst.Kind() == reflect.Ptr {
+		if s == "" {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+
+		dst.Set(reflect.New(dst.Type().Elem()))
+
+		return d.setScalar(dst.Elem(), s)
+	}
+
+	if reflect.PtrTo(dst.Type()).Implements(scannerType) {
+		return d.scan(dst.Addr().Interface().(sql.Scanner), s)
+	}
+
+	// Everything else takes the zero value when empty
+	if s == "" {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	if dst.Type() == timeType {
+		t, err := d.parseTime(s)
+
+		if err != nil {
+			return err
+		}
+
+		dst.Set(reflect.ValueOf(t))
+
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.String:
+		dst.SetString(s)
+	case reflect.Bool:
+		v, err := parseBoolean(s)
+
+		if err != nil {
+			return err
+		}
+
+		dst.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+
+		if err != nil || dst.OverflowInt(v) {
+			return fmt.Errorf("Could not parse '%s' as %s", s, dst.Type())
+		}
+
+		dst.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+
+		if err != nil || dst.OverflowUint(v) {
+			return fmt.Errorf("Could not parse '%s' as %s", s, dst.Type())
+		}
+
+		dst.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+
+		if err != nil || dst.OverflowFloat(v) {
+			return fmt.Errorf("Could not parse '%s' as %s", s, dst.Type())
+		}
+
+		dst.SetFloat(v)
+	default:
+		return fmt.Errorf("Cannot unmarshal into %s", dst.Type())
+	}
+
+	return nil
+}
+
+// Scanners get the value as the Filemaker type: a time for dates and times, a bool for booleans,
+// and a string for everything else, which database/sql converts as needed. Empty values are scanned as nil
+func (d fieldDecoder) scan(scanner sql.Scanner, s string) error {
+	if s == "" {
+		return scanner.Scan(nil)
+	}
+
+	if d.fmType == "BOOLEAN" {
+		v, err := parseBoolean(s)
+
+		if err != nil {
+			return err
+		}
+
+		return scanner.Scan(v)
+	}
+
+	if d.layout == "" {
+		return scanner.Scan(s)
+	}
+
+	t, err := d.parseTime(s)
+
+	if err != nil {
+		return err
+	}
+
+	return scanner.Scan(t)
+}
+
+func (d fieldDecoder) parseTime(s string) (time.Time, error) {
+	if d.layout == "" {
+		return time.Time{}, fmt.Errorf("Cannot read a time from a %s field", d.field.Type)
+	}
+
+	return time.Parse(d.layout, s)
+}
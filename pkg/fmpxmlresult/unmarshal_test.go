@@ -0,0 +1,304 @@
+package fmpxmlresult_test
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+type person struct {
+	RecordID int64           `fmp:",recordid"`
+	ModID    string          `fmp:",modid"`
+	Name     string          `fmp:"First Name"`
+	Age      *int            `fmp:"Age"`
+	Born     time.Time       `fmp:"Born"`
+	Phones   []string        `fmp:"Phones"`
+	Active   sql.NullBool    `fmp:"Active"`
+	Balance  sql.NullFloat64 `fmp:"Balance"`
+	Started  sql.NullTime    `fmp:"Started"`
+	Ignored  string          `fmp:"-"`
+	Missing  string          // There is no field by this name, so it is left alone
+}
+
+func Test_Unmarshal(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "First Name", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Age", Type: "NUMBER", MaxRepeat: 1},
+			{Name: "Born", Type: "DATE", MaxRepeat: 1},
+			{Name: "Phones", Type: "TEXT", MaxRepeat: 2},
+			{Name: "Active", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Balance", Type: "NUMBER", MaxRepeat: 1},
+			{Name: "Started", Type: "TEXT", MaxRepeat: 1},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "34", ModID: "2", Cols: []fmpxmlresult.Col{
+				{Data: []string{"Joe"}}, {Data: []string{"41"}}, {Data: []string{"1/11/1986"}}, {Data: []string{"555-1234", "555-9876"}},
+				{Data: []string{"Yes"}}, {Data: []string{"12.5"}}, {Data: []string{"2020-03-01"}},
+			}},
+			{RecordID: "35", ModID: "7", Cols: []fmpxmlresult.Col{
+				{Data: []string{"Sue"}}, {Data: []string{""}}, {Data: []string{""}}, {Data: []string{}},
+				{Data: []string{""}}, {Data: []string{""}}, {Data: []string{""}},
+			}},
+		}},
+		TypeMapping: fmpxmlresult.TypeMapping{
+			"Active":  {Type: "BOOLEAN"},
+			"Started": {Type: "DATE", Format: "yyyy-MM-dd"},
+		},
+	}
+
+	people := []person{}
+
+	if err := sample.Unmarshal(&people); err != nil {
+		t.Error(err)
+		return
+	}
+
+	age := 41
+
+	expected := []person{
+		{
+			RecordID: 34, ModID: "2", Name: "Joe", Age: &age,
+			Born:    time.Date(1986, 1, 11, 0, 0, 0, 0, time.UTC),
+			Phones:  []string{"555-1234", "555-9876"},
+			Active:  sql.NullBool{Bool: true, Valid: true},
+			Balance: sql.NullFloat64{Float64: 12.5, Valid: true},
+			Started: sql.NullTime{Time: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), Valid: true},
+		},
+		{RecordID: 35, ModID: "7", Name: "Sue", Phones: []string{}},
+	}
+
+	for _, diff := range deep.Equal(people, expected) {
+		t.Error(diff)
+	}
+
+	// Struct pointers work too
+	pointers := []*person{}
+
+	if err := sample.Unmarshal(&pointers); err != nil || len(pointers) != 2 || pointers[1].Name != "Sue" {
+		t.Errorf("Unexpected pointers %v, %v", pointers, err)
+	}
+}
+
+func Test_UnmarshalEach(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "First Name", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Age", Type: "NUMBER", MaxRepeat: 1},
+			{Name: "Born", Type: "DATE", MaxRepeat: 1},
+			{Name: "Phones", Type: "TEXT", MaxRepeat: 2},
+			{Name: "Active", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Balance", Type: "NUMBER", MaxRepeat: 1},
+			{Name: "Started", Type: "TEXT", MaxRepeat: 1},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "34", ModID: "2", Cols: []fmpxmlresult.Col{
+				{Data: []string{"Joe"}}, {Data: []string{"41"}}, {Data: []string{"1/11/1986"}}, {Data: []string{"555-1234", "555-9876"}},
+				{Data: []string{"Yes"}}, {Data: []string{"12.5"}}, {Data: []string{"2020-03-01"}},
+			}},
+			{RecordID: "35", ModID: "7", Cols: []fmpxmlresult.Col{
+				{Data: []string{"Sue"}}, {Data: []string{""}}, {Data: []string{""}}, {Data: []string{}},
+				{Data: []string{""}}, {Data: []string{""}}, {Data: []string{""}},
+			}},
+		}},
+		TypeMapping: fmpxmlresult.TypeMapping{
+			"Active":  {Type: "BOOLEAN"},
+			"Started": {Type: "DATE", Format: "yyyy-MM-dd"},
+		},
+	}
+
+	var p struct {
+		Name string `fmp:"First Name"`
+		Age  int    `fmp:"Age"`
+	}
+
+	names := []string{}
+
+	err := sample.UnmarshalEach(&p, func() error {
+		names = append(names, fmt.Sprintf("%s %d", p.Name, p.Age))
+		return nil
+	})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal(names, []string{"Joe 41", "Sue 0"}) {
+		t.Error(diff)
+	}
+
+	stop := fmt.Errorf("Stop")
+	calls := 0
+
+	err = sample.UnmarshalEach(&p, func() error {
+		calls++
+		return stop
+	})
+
+	if err != stop || calls != 1 {
+		t.Errorf("Expected to stop after one call, got %d calls and %v", calls, err)
+	}
+}
+
+func Test_UnmarshalDecimal(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "First Name", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Age", Type: "NUMBER", MaxRepeat: 1},
+			{Name: "Born", Type: "DATE", MaxRepeat: 1},
+			{Name: "Phones", Type: "TEXT", MaxRepeat: 2},
+			{Name: "Active", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Balance", Type: "NUMBER", MaxRepeat: 1},
+			{Name: "Started", Type: "TEXT", MaxRepeat: 1},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "34", ModID: "2", Cols: []fmpxmlresult.Col{
+				{Data: []string{"Joe"}}, {Data: []string{"41"}}, {Data: []string{"1/11/1986"}}, {Data: []string{"555-1234", "555-9876"}},
+				{Data: []string{"Yes"}}, {Data: []string{"12.5"}}, {Data: []string{"2020-03-01"}},
+			}},
+			{RecordID: "35", ModID: "7", Cols: []fmpxmlresult.Col{
+				{Data: []string{"Sue"}}, {Data: []string{""}}, {Data: []string{""}}, {Data: []string{}},
+				{Data: []string{""}}, {Data: []string{""}}, {Data: []string{""}},
+			}},
+		}},
+		TypeMapping: fmpxmlresult.TypeMapping{
+			"Active":  {Type: "BOOLEAN"},
+			"Started": {Type: "DATE", Format: "yyyy-MM-dd"},
+		},
+	}
+
+	var ages []struct {
+		Age  int  `fmp:"Age"`
+		Uint uint `fmp:"Age"`
+	}
+
+	// Leading zeros don't make a number octal
+	for input, want := range map[string]int{"010": 10, "08": 8, " 41 ": 41} {
+		sample.ResultSet.Rows[0].Cols[1].Data = []string{input}
+
+		if err := sample.Unmarshal(&ages); err != nil {
+			t.Errorf("%s: %v", input, err)
+			continue
+		}
+
+		if ages[0].Age != want || ages[0].Uint != uint(want) {
+			t.Errorf("%s: expected %d, got %d and %d", input, want, ages[0].Age, ages[0].Uint)
+		}
+	}
+
+	for _, input := range []string{"0x1F", "0b11", "1_000"} {
+		sample.ResultSet.Rows[0].Cols[1].Data = []string{input}
+
+		if err := sample.Unmarshal(&ages); err == nil {
+			t.Errorf("Err is nil for %s", input)
+		}
+	}
+}
+
+func Test_UnmarshalErrors(t *testing.T) {
+	sample := fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{DateFormat: "M/d/yyyy", TimeFormat: "h:mm:ss a"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "First Name", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Age", Type: "NUMBER", MaxRepeat: 1},
+			{Name: "Born", Type: "DATE", MaxRepeat: 1},
+			{Name: "Phones", Type: "TEXT", MaxRepeat: 2},
+			{Name: "Active", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Balance", Type: "NUMBER", MaxRepeat: 1},
+			{Name: "Started", Type: "TEXT", MaxRepeat: 1},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "34", ModID: "2", Cols: []fmpxmlresult.Col{
+				{Data: []string{"Joe"}}, {Data: []string{"41"}}, {Data: []string{"1/11/1986"}}, {Data: []string{"555-1234", "555-9876"}},
+				{Data: []string{"Yes"}}, {Data: []string{"12.5"}}, {Data: []string{"2020-03-01"}},
+			}},
+			{RecordID: "35", ModID: "7", Cols: []fmpxmlresult.Col{
+				{Data: []string{"Sue"}}, {Data: []string{""}}, {Data: []string{""}}, {Data: []string{}},
+				{Data: []string{""}}, {Data: []string{""}}, {Data: []string{""}},
+			}},
+		}},
+		TypeMapping: fmpxmlresult.TypeMapping{
+			"Active":  {Type: "BOOLEAN"},
+			"Started": {Type: "DATE", Format: "yyyy-MM-dd"},
+		},
+	}
+
+	var notSlice person
+
+	if err := sample.Unmarshal(&notSlice); err == nil {
+		t.Error("Err is nil for a non-slice")
+	}
+
+	var badTag []struct {
+		Name string `fmp:"Nickname"`
+	}
+
+	if err := sample.Unmarshal(&badTag); err == nil {
+		t.Error("Err is nil for a tag that isn't in the export")
+	}
+
+	var notRepeating []struct {
+		Phone string `fmp:"Phones"`
+	}
+
+	if err := sample.Unmarshal(&notRepeating); err == nil {
+		t.Error("Err is nil for a repeating field that isn't a slice")
+	}
+
+	var badNumber []struct {
+		Name int8 `fmp:"First Name"`
+	}
+
+	if err := sample.Unmarshal(&badNumber); err == nil {
+		t.Error("Err is nil for text in a number")
+	}
+
+	var badTime []struct {
+		Name time.Time `fmp:"First Name"`
+	}
+
+	if err := sample.Unmarshal(&badTime); err == nil {
+		t.Error("Err is nil for a time from a TEXT field")
+	}
+
+	// A field that isn't declared as repeating can still have more than one value, which needs a slice
+	var extraValues []struct {
+		Name string `fmp:"First Name"`
+	}
+
+	sample.ResultSet.Rows[1].Cols[0].Data = []string{"Sue", "Susan"}
+
+	if err := sample.Unmarshal(&extraValues); err == nil {
+		t.Error("Err is nil for extra values in a single value field")
+	}
+
+	// Exports missing a section can't be unmarshalled, rather than panicking
+	type birthday struct {
+		Born time.Time `fmp:"Born"`
+	}
+
+	var born []birthday
+	var each birthday
+
+	for name, incomplete := range map[string]fmpxmlresult.FMPXMLResult{
+		"database":   {Metadata: sample.Metadata, ResultSet: sample.ResultSet},
+		"metadata":   {Database: sample.Database, ResultSet: sample.ResultSet},
+		"result set": {Database: sample.Database, Metadata: sample.Metadata},
+	} {
+		if err := incomplete.Unmarshal(&born); err == nil {
+			t.Errorf("Err is nil without the %s", name)
+		}
+
+		if err := incomplete.UnmarshalEach(&each, func() error { return nil }); err == nil {
+			t.Errorf("UnmarshalEach err is nil without the %s", name)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package fmpxmlresult
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// This file has the typed value model that records are built from. Every writer renders from these values,
+// so code that filters or transforms records can work with them directly instead of decoding JSON
+
+// Kind is the type of a value
+type Kind int
+
+// The kinds of value. Numbers are kept as decimal text, so they never lose precision
+const (
+	NullKind Kind = iota
+	StringKind
+	NumberKind
+	BoolKind
+	DateKind
+	TimeKind
+	TimestampKind
+	ArrayKind
+	ObjectKind
+)
+
+var kindNames = map[Kind]string{
+	NullKind:      "null",
+	StringKind:    "string",
+	NumberKind:    "number",
+	BoolKind:      "boolean",
+	DateKind:      "date",
+	TimeKind:      "time",
+	TimestampKind: "timestamp",
+	ArrayKind:     "array",
+	ObjectKind:    "object",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// The layouts dates and times are written in
+const (
+	DateLayout      = "2006-01-02"
+	TimeLayout      = "15:04:05"
+	TimestampLayout = "2006-01-02T15:04:05"
+)
+
+// Value is a single converted value. The zero value is null
+type Value struct {
+	kind    Kind
+	text    string // The string, or the number's decimal text
+	time    time.Time
+	boolean bool
+	items   []Value
+	members []Member
+}
+
+// Member is a single key and value of an object, which keeps its members in order
+type Member struct {
+	Key   string
+	Value Value
+}
+
+// Null will get a null value
+func Null() Value {
+	return Value{}
+}
+
+// NewString will get a string value
+func NewString(s string) Value {
+	return Value{kind: StringKind, text: s}
+}
+
+// NewNumber will get a number value from its decimal text, which must be a valid JSON number
+func NewNumber(decimal string) (Value, error) {
+	parsed, err := ParseJSON([]byte(decimal))
+
+	if err != nil || parsed.kind != NumberKind {
+		return Value{}, fmt.Errorf("Invalid number '%s'", decimal)
+	}
+
+	return parsed, nil
+}
+
+// NewInt will get a number value from an integer
+func NewInt(i int64) Value {
+	return Value{kind: NumberKind, text: strconv.FormatInt(i, 10)}
+}
+
+// NewFloat will get a number value from a float. JSON has no infinity or NaN, so those are null
+func NewFloat(f float64) Value {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return Null()
+	}
+
+	return Value{kind: NumberKind, text: fmt.Sprint(f)}
+}
+
+// NewBool will get a boolean value
+func NewBool(b bool) Value {
+	return Value{kind: BoolKind, boolean: b}
+}
+
+// NewDate will get a date value. Only the date part of the time is used
+func NewDate(t time.Time) Value {
+	return Value{kind: DateKind, time: t}
+}
+
+// NewTime will get a time of day value. Only the clock part of the time is used
+func NewTime(t time.Time) Value {
+	return Value{kind: TimeKind, time: t}
+}
+
+// NewTimestamp will get a date and time value
+func NewTimestamp(t time.Time) Value {
+	return Value{kind: TimestampKind, time: t}
+}
+
+// NewArray will get an array of values
+func NewArray(items ...Value) Value {
+	if items == nil {
+		items = []Value{}
+	}
+
+	return Value{kind: ArrayKind, items: items}
+}
+
+// NewObject will get an object with the members in the order given
+func NewObject(members ...Member) Value {
+	if members == nil {
+		members = []Member{}
+	}
+
+	return Value{kind: ObjectKind, members: members}
+}
+
+// Kind will get the kind of value
+func (v Value) Kind() Kind {
+	return v.kind
+}
+
+// IsNull is true for a null value
+func (v Value) IsNull() bool {
+	return v.kind == NullKind
+}
+
+// String will get the plain text form of any value, for writers that only deal in text.
+// Strings are as-is, numbers are decimal text, dates and times use their layouts, null is empty, and arrays and objects are JSON
+func (v Value) String() string {
+	switch v.kind {
+	case NullKind:
+		return ""
+	case StringKind, NumberKind:
+		return v.text
+	case BoolKind:
+		return strconv.FormatBool(v.boolean)
+	case DateKind:
+		return v.time.Format(DateLayout)
+	case TimeKind:
+		return v.time.Format(TimeLayout)
+	case TimestampKind:
+		return v.time.Format(TimestampLayout)
+	}
+
+	encoded, _ := v.MarshalJSON()
+
+	return string(encoded)
+}
+
+// Decimal will get the decimal text of a number, or an empty string for anything else
+func (v Value) Decimal() string {
+	if v.kind != NumberKind {
+		return ""
+	}
+
+	return v.text
+}
+
+// Int64 will get a number as an integer. ok is false if the value isn't a number, or isn't a whole number that fits
+func (v Value) Int64() (i int64, ok bool) {
+	if v.kind != NumberKind {
+		return 0, false
+	}
+
+	i, err := strconv.ParseInt(v.text, 10, 64)
+
+	return i, err == nil
+}
+
+// Float64 will get a number as a float. ok is false if the value isn't a number
+func (v Value) Float64() (f float64, ok bool) {
+	if v.kind != NumberKind {
+		return 0, false
+	}
+
+	f, err := strconv.ParseFloat(v.text, 64)
+
+	return f, err == nil
+}
+
+// Bool will get a boolean value, which is false for anything that isn't a boolean
+func (v Value) Bool() bool {
+	return v.kind == BoolKind && v.boolean
+}
+
+// Time will get the time of a date, time, or timestamp value, or the zero time for anything else
+func (v Value) Time() time.Time {
+	switch v.kind {
+	case DateKind, TimeKind, TimestampKind:
+		return v.time
+	}
+
+	return time.Time{}
+}
+
+// Items will get the items of an array, or nil for anything else
+func (v Value) Items() []Value {
+	return v.items
+}
+
+// Members will get the members of an object in order, or nil for anything else
+func (v Value) Members() []Member {
+	return v.members
+}
+
+// Get will get the member of an object with the given key
+func (v Value) Get(key string) (Value, bool) {
+	for _, member := range v.members {
+		if member.Key == key {
+			return member.Value, true
+		}
+	}
+
+	return Value{}, false
+}
+
+// Equal will compare two values. Dates and times are compared as written, and objects must have their members in the same order
+func (v Value) Equal(other Value) bool {
+	if v.kind != other.kind {
+		return false
+	}
+
+	switch v.kind {
+	case ArrayKind:
+		if len(v.items) != len(other.items) {
+			return false
+		}
+
+		for i := range v.items {
+			if !v.items[i].Equal(other.items[i]) {
+				return false
+			}
+		}
+
+		return true
+	case ObjectKind:
+		if len(v.members) != len(other.members) {
+			return false
+		}
+
+		for i := range v.members {
+			if v.members[i].Key != other.members[i].Key || !v.members[i].Value.Equal(other.members[i].Value) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return v.String() == other.String()
+}
+
+// MarshalJSON will write the value as JSON. Dates and times become strings
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch v.kind {
+	case NullKind:
+		return []byte("null"), nil
+	case NumberKind:
+		return []byte(v.text), nil
+	case BoolKind:
+		return []byte(strconv.FormatBool(v.boolean)), nil
+	case StringKind, DateKind, TimeKind, TimestampKind:
+		return json.Marshal(v.String())
+	}
+
+	out := &bytes.Buffer{}
+
+	if v.kind == ArrayKind {
+		out.WriteString("[")
+
+		for i, item := range v.items {
+			if i > 0 {
+				out.WriteString(",")
+			}
+
+			encoded, err := item.MarshalJSON()
+
+			if err != nil {
+				return nil, err
+			}
+
+			out.Write(encoded)
+		}
+
+		out.WriteString("]")
+
+		return out.Bytes(), nil
+	}
+
+	out.WriteString("{")
+
+	for i, member := range v.members {
+		if i > 0 {
+			out.WriteString(",")
+		}
+
+		key, _ := json.Marshal(member.Key)
+		encoded, err := member.Value.MarshalJSON()
+
+		if err != nil {
+			return nil, err
+		}
+
+		out.Write(key)
+		out.WriteString(":")
+		out.Write(encoded)
+	}
+
+	out.WriteString("}")
+
+	return out.Bytes(), nil
+}
+
+// UnmarshalJSON will read any JSON into a value. JSON has no dates, so they come back as strings
+func (v *Value) UnmarshalJSON(data []byte) error {
+	parsed, err := ParseJSON(data)
+
+	if err != nil {
+		return err
+	}
+
+	*v = parsed
+
+	return nil
+}
+
+// ParseJSON will read a JSON document into a value, keeping the order of object members and the exact text of numbers
+func ParseJSON(data []byte) (Value, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	out, err := parseJSONValue(decoder)
+
+	if err != nil {
+		return Value{}, err
+	}
+
+	// There must be exactly one document
+	if _, err := decoder.Token(); err != io.EOF {
+		return Value{}, fmt.Errorf("Unexpected data after the JSON value")
+	}
+
+	return out, nil
+}
+
+func parseJSONValue(decoder *json.Decoder) (Value, error) {
+	token, err := decoder.Token()
+
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch t := token.(type) {
+	case nil:
+		return Null(), nil
+	case string:
+		return NewString(t), nil
+	case json.Number:
+		return Value{kind: NumberKind, text: t.String()}, nil
+	case bool:
+		return NewBool(t), nil
+	case json.Delim:
+		if t == '[' {
+			items := []Value{}
+
+			for decoder.More() {
+				item, err := parseJSONValue(decoder)
+
+				if err != nil {
+					return Value{}, err
+				}
+
+				items = append(items, item)
+			}
+
+			_, err := decoder.Token()
+
+			return NewArray(items...), err
+		}
+
+		members := []Member{}
+
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+
+			if err != nil {
+				return Value{}, err
+			}
+
+			value, err := parseJSONValue(decoder)
+
+			if err != nil {
+				return Value{}, err
+			}
+
+			members = append(members, Member{Key: keyToken.(string), Value: value})
+		}
+
+		_, err := decoder.Token()
+
+		return NewObject(members...), err
+	}
+
+	return Value{}, fmt.Errorf("Unexpected JSON token %v", token)
+}
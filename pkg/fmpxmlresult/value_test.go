@@ -0,0 +1,111 @@
+package fmpxmlresult_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+func Test_ValueKinds(t *testing.T) {
+	day := time.Date(1986, 1, 11, 20, 9, 21, 0, time.UTC)
+	number, err := fmpxmlresult.NewNumber("12.50")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var tests = []struct {
+		value fmpxmlresult.Value
+		kind  fmpxmlresult.Kind
+		text  string
+		json  string
+	}{
+		{fmpxmlresult.Null(), fmpxmlresult.NullKind, "", `null`},
+		{fmpxmlresult.Value{}, fmpxmlresult.NullKind, "", `null`},
+		{fmpxmlresult.NewString("Adam"), fmpxmlresult.StringKind, "Adam", `"Adam"`},
+		{number, fmpxmlresult.NumberKind, "12.50", `12.50`},
+		{fmpxmlresult.NewInt(42), fmpxmlresult.NumberKind, "42", `42`},
+		{fmpxmlresult.NewFloat(41.1), fmpxmlresult.NumberKind, "41.1", `41.1`},
+		{fmpxmlresult.NewBool(true), fmpxmlresult.BoolKind, "true", `true`},
+		{fmpxmlresult.NewDate(day), fmpxmlresult.DateKind, "1986-01-11", `"1986-01-11"`},
+		{fmpxmlresult.NewTime(day), fmpxmlresult.TimeKind, "20:09:21", `"20:09:21"`},
+		{fmpxmlresult.NewTimestamp(day), fmpxmlresult.TimestampKind, "1986-01-11T20:09:21", `"1986-01-11T20:09:21"`},
+		{fmpxmlresult.NewArray(fmpxmlresult.NewInt(1), fmpxmlresult.Null()), fmpxmlresult.ArrayKind, "[1,null]", `[1,null]`},
+		{fmpxmlresult.NewObject(fmpxmlresult.Member{Key: "b", Value: fmpxmlresult.NewInt(1)}, fmpxmlresult.Member{Key: "a", Value: fmpxmlresult.NewString("x")}), fmpxmlresult.ObjectKind, `{"b":1,"a":"x"}`, `{"b":1,"a":"x"}`},
+	}
+
+	for _, tt := range tests {
+		if tt.value.Kind() != tt.kind {
+			t.Errorf("Kind of %s: have %s, want %s", tt.json, tt.value.Kind(), tt.kind)
+		}
+
+		if tt.value.String() != tt.text {
+			t.Errorf("Text of %s: have '%s', want '%s'", tt.json, tt.value.String(), tt.text)
+		}
+
+		compareJSON(t, tt.value, json.RawMessage(tt.json))
+	}
+
+	for _, invalid := range []string{"", "abc", `"1"`, "true", "null", "[1]", "1 2"} {
+		if _, err := fmpxmlresult.NewNumber(invalid); err == nil {
+			t.Errorf("Err is nil for number '%s'", invalid)
+		}
+	}
+}
+
+func Test_ParseJSON(t *testing.T) {
+	input := `{"z": [1, 2.50, "three"], "a": {"nested": true}, "n": null}`
+
+	value, err := fmpxmlresult.ParseJSON([]byte(input))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Members stay in the order they were written, and numbers keep their digits
+	compareJSON(t, value, json.RawMessage(`{"z":[1,2.50,"three"],"a":{"nested":true},"n":null}`))
+
+	z, found := value.Get("z")
+
+	if !found || len(z.Items()) != 3 || z.Items()[1].Decimal() != "2.50" {
+		t.Errorf("Unexpected z: %s", z)
+	}
+
+	var reread fmpxmlresult.Value
+
+	if err := json.Unmarshal([]byte(input), &reread); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !reread.Equal(value) {
+		t.Errorf("Have %s, want %s", reread, value)
+	}
+
+	for _, invalid := range []string{"", "{", `{"a": 1} 2`, `[1,]`} {
+		if _, err := fmpxmlresult.ParseJSON([]byte(invalid)); err == nil {
+			t.Errorf("Err is nil for '%s'", invalid)
+		}
+	}
+}
+
+func Test_ValueEqual(t *testing.T) {
+	a := fmpxmlresult.NewArray(fmpxmlresult.NewString("1"), fmpxmlresult.NewInt(1))
+
+	if !a.Equal(fmpxmlresult.NewArray(fmpxmlresult.NewString("1"), fmpxmlresult.NewInt(1))) {
+		t.Error("Matching arrays are not equal")
+	}
+
+	// A string and a number with the same text are still different values
+	if fmpxmlresult.NewString("1").Equal(fmpxmlresult.NewInt(1)) {
+		t.Error("A string equals a number")
+	}
+
+	if !fmpxmlresult.Null().Equal(fmpxmlresult.Value{}) {
+		t.Error("Null does not equal the zero value")
+	}
+}
@@ -0,0 +1,538 @@
+package postgres
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+// This package writes converted exports as PostgreSQL scripts, which can be piped straight into psql
+
+// Identifier quoting modes
+const (
+	QuoteAlways = "always" // Every identifier is quoted, keeping the FileMaker names exactly
+	QuoteNeeded = "needed" // Only identifiers that wouldn't survive unquoted are quoted
+)
+
+// How repeating fields are stored
+const (
+	RepeatsArray = "array" // An array of the field's type
+	RepeatsJSONB = "jsonb" // A JSON array
+)
+
+// How the data is written
+const (
+	DataCopy   = "copy"   // COPY ... FROM STDIN in text format, which is fastest for an empty table
+	DataInsert = "insert" // Batched INSERT statements that upsert on the record ID
+)
+
+// RecordIDColumn and ModIDColumn are where the record and modification IDs are kept in every table
+const (
+	RecordIDColumn = "_recordID"
+	ModIDColumn    = "_modID"
+)
+
+// DefaultTypes are the PostgreSQL column types for each FileMaker type, and for the types from a type mapping
+var DefaultTypes = map[string]string{
+	"TEXT":      "text",
+	"STRING":    "text",
+	"NUMBER":    "numeric",
+	"DATE":      "date",
+	"TIME":      "time",
+	"TIMESTAMP": "timestamp",
+	"CONTAINER": "text",
+	"BOOLEAN":   "boolean",
+	"JSON":      "jsonb",
+}
+
+// DefaultBatchSize is how many rows go in each INSERT statement if no batch size is given
+const DefaultBatchSize = 500
+
+// Options control the generated script. The zero value quotes every identifier, uses arrays for repeating fields, and writes COPY data
+type Options struct {
+	Table     string            // The table to create and load. Defaults to the database name without its extension
+	Quote     string            // QuoteAlways or QuoteNeeded
+	Repeats   string            // RepeatsArray or RepeatsJSONB
+	Data      string            // DataCopy or DataInsert
+	BatchSize int               // Rows per INSERT statement
+	Types     map[string]string // Overrides for DefaultTypes, by FileMaker type
+}
+
+// Validate will return an error describing the first invalid option
+func (o Options) Validate() error {
+	switch o.Quote {
+	case "", QuoteAlways, QuoteNeeded:
+	default:
+		return fmt.Errorf("Unknown quoting mode '%s'", o.Quote)
+	}
+
+	switch o.Repeats {
+	case "", RepeatsArray, RepeatsJSONB:
+	default:
+		return fmt.Errorf("Unknown repeating field mode '%s'", o.Repeats)
+	}
+
+	switch o.Data {
+	case "", DataCopy, DataInsert:
+	default:
+		return fmt.Errorf("Unknown data mode '%s'", o.Data)
+	}
+
+	if o.BatchSize < 0 {
+		return fmt.Errorf("Batch size cannot be negative")
+	}
+
+	for fmType := range o.Types {
+		if _, found := DefaultTypes[fmType]; !found {
+			return fmt.Errorf("Unknown FileMaker type '%s'", fmType)
+		}
+	}
+
+	return nil
+}
+
+// ParseTypes will parse a list of type overrides like "NUMBER=double precision,TIME=text"
+func ParseTypes(s string) (map[string]string, error) {
+	out := map[string]string{}
+
+	if strings.TrimSpace(s) == "" {
+		return out, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		pieces := strings.SplitN(part, "=", 2)
+
+		if len(pieces) != 2 || strings.TrimSpace(pieces[1]) == "" {
+			return nil, fmt.Errorf("Expected TYPE=postgres type, got '%s'", part)
+		}
+
+		fmType := strings.ToUpper(strings.TrimSpace(pieces[0]))
+
+		if _, found := DefaultTypes[fmType]; !found {
+			return nil, fmt.Errorf("Unknown FileMaker type '%s'", fmType)
+		}
+
+		out[fmType] = strings.TrimSpace(pieces[1])
+	}
+
+	return out, nil
+}
+
+// A column of the generated table
+type column struct {
+	name   string // The field name, as found in the records
+	quoted string // The name as it goes in SQL
+	typ    string
+	array  bool // Whether values are written as PostgreSQL arrays
+	jsonb  bool // Whether values are written as JSON
+}
+
+// A script being written
+type script struct {
+	w       *bufio.Writer
+	fmp     *fmpxmlresult.FMPXMLResult
+	opts    Options
+	table   string
+	columns []column
+}
+
+// WriteScript will write a script that creates the table if needed and loads the records into it, in a single transaction.
+// Tombstones, such as from an incremental export, become DELETE statements. Records must already be populated
+func WriteScript(w io.Writer, fmp *fmpxmlresult.FMPXMLResult, opts Options) error {
+	s, err := newScript(w, fmp, opts)
+
+	if err != nil {
+		return err
+	}
+
+	if err := s.write(); err != nil {
+		return err
+	}
+
+	return s.w.Flush()
+}
+
+// CreateTable will get the CREATE TABLE statement for an export
+func CreateTable(fmp *fmpxmlresult.FMPXMLResult, opts Options) (string, error) {
+	s, err := newScript(nil, fmp, opts)
+
+	if err != nil {
+		return "", err
+	}
+
+	return s.createTable(), nil
+}
+
+func newScript(w io.Writer, fmp *fmpxmlresult.FMPXMLResult, opts Options) (*script, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	if fmp.Metadata == nil {
+		return nil, fmt.Errorf("The export has no metadata")
+	}
+
+	table := opts.Table
+
+	if table == "" && fmp.Database != nil {
+		table = strings.TrimSuffix(fmp.Database.Name, filepath.Ext(fmp.Database.Name))
+	}
+
+	if table == "" {
+		return nil, fmt.Errorf("No table name given, and the export has no database name")
+	}
+
+	s := &script{
+		w:     bufio.NewWriter(w),
+		fmp:   fmp,
+		opts:  opts,
+		table: quoteIdentifier(table, opts.Quote),
+		columns: []column{
+			{name: RecordIDColumn, quoted: quoteIdentifier(RecordIDColumn, opts.Quote), typ: "bigint"},
+			{name: ModIDColumn, quoted: quoteIdentifier(ModIDColumn, opts.Quote), typ: "bigint"},
+		},
+	}
+
+	fields, err := fmp.OutputFields()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range fields {
+		s.columns = append(s.columns, s.fieldColumn(f))
+	}
+
+	derived, err := fmp.DerivedOutputs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Derived fields are typed by what their expressions produce
+	for _, d := range derived {
+		s.columns = append(s.columns, s.newColumn(d.Key, s.columnType(d.Type), d.Repeat))
+	}
+
+	return s, nil
+}
+
+func (s *script) fieldColumn(of fmpxmlresult.OutputField) column {
+	fmType := s.fmp.OutputType(of.Field)
+	typ := s.columnType(fmType)
+
+	// Structured container references are objects
+	if s.fmp.StructuredContainer(of.Field) {
+		typ = "jsonb"
+	}
+
+	return s.newColumn(of.Key, typ, of.Field.MaxRepeat > 1)
+}
+
+// Get the PostgreSQL type for a FileMaker type, after any overrides
+func (s *script) columnType(fmType string) string {
+	typ := DefaultTypes[fmType]
+
+	if override, found := s.opts.Types[fmType]; found {
+		typ = override
+	}
+
+	if typ == "" {
+		typ = "text"
+	}
+
+	return typ
+}
+
+// Get the column for a key of the given type, where repeating values are arrays or JSON
+func (s *script) newColumn(key, typ string, repeats bool) column {
+	out := column{name: key, quoted: quoteIdentifier(key, s.opts.Quote), typ: typ}
+
+	if repeats && s.opts.Repeats == RepeatsJSONB {
+		out.typ = "jsonb"
+	} else if repeats {
+		out.typ += "[]"
+		out.array = true
+	}
+
+	out.jsonb = out.typ == "jsonb"
+
+	return out
+}
+
+func (s *script) createTable() string {
+	definitions := []string{}
+
+	for _, c := range s.columns {
+		definition := "  " + c.quoted + " " + c.typ
+
+		switch c.name {
+		case RecordIDColumn:
+			definition += " PRIMARY KEY"
+		case ModIDColumn:
+			definition += " NOT NULL"
+		}
+
+		definitions = append(definitions, definition)
+	}
+
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n%s\n);\n", s.table, strings.Join(definitions, ",\n"))
+}
+
+func (s *script) write() error {
+	if s.fmp.ResultSet == nil || len(s.fmp.Records) < len(s.fmp.ResultSet.Rows) {
+		return fmt.Errorf("Records have not been populated")
+	}
+
+	if s.fmp.Database != nil {
+		fmt.Fprintf(s.w, "-- Generated from %s, layout %s\n", s.fmp.Database.Name, s.fmp.Database.Layout)
+	}
+
+	fmt.Fprintf(s.w, "BEGIN;\n\n%s\n", s.createTable())
+
+	var err error
+
+	if s.opts.Data == DataInsert {
+		err = s.writeInserts()
+	} else {
+		err = s.writeCopy()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.writeDeletes()
+
+	_, err = fmt.Fprintf(s.w, "COMMIT;\n")
+
+	return err
+}
+
+// Get the values of a row, with nil for NULL
+func (s *script) rowValues(row fmpxmlresult.Row, record fmpxmlresult.Record) ([]*string, error) {
+	recordID, modID := row.RecordID, row.ModID
+	out := []*string{&recordID, &modID}
+
+	for _, c := range s.columns[2:] {
+		value, err := textValue(record[c.name], c)
+
+		if err != nil {
+			return nil, fmt.Errorf("Record %s, field '%s': %v", row.RecordID, c.name, err)
+		}
+
+		out = append(out, value)
+	}
+
+	return out, nil
+}
+
+func (s *script) columnList() string {
+	names := []string{}
+
+	for _, c := range s.columns {
+		names = append(names, c.quoted)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func (s *script) writeCopy() error {
+	if len(s.fmp.ResultSet.Rows) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(s.w, "COPY %s (%s) FROM STDIN;\n", s.table, s.columnList())
+
+	for i, row := range s.fmp.ResultSet.Rows {
+		values, err := s.rowValues(row, s.fmp.Records[i])
+
+		if err != nil {
+			return err
+		}
+
+		fields := make([]string, len(values))
+
+		for j, value := range values {
+			fields[j] = copyValue(value)
+		}
+
+		fmt.Fprintf(s.w, "%s\n", strings.Join(fields, "\t"))
+	}
+
+	fmt.Fprintf(s.w, "\\.\n\n")
+
+	return nil
+}
+
+func (s *script) writeInserts() error {
+	batchSize := s.opts.BatchSize
+
+	if batchSize == 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	// Rows are only updated if the modification ID changed
+	assignments := []string{}
+
+	for _, c := range s.columns[1:] {
+		assignments = append(assignments, fmt.Sprintf("%s = EXCLUDED.%s", c.quoted, c.quoted))
+	}
+
+	onConflict := fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s\n  WHERE %s.%s IS DISTINCT FROM EXCLUDED.%s;\n\n",
+		s.columns[0].quoted, strings.Join(assignments, ", "), s.table, s.columns[1].quoted, s.columns[1].quoted)
+
+	rows := s.fmp.ResultSet.Rows
+
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		tuples := []string{}
+
+		for i := start; i < end; i++ {
+			values, err := s.rowValues(rows[i], s.fmp.Records[i])
+
+			if err != nil {
+				return err
+			}
+
+			literals := make([]string, len(values))
+
+			for j, value := range values {
+				literals[j] = literalValue(value)
+			}
+
+			tuples = append(tuples, "  ("+strings.Join(literals, ", ")+")")
+		}
+
+		fmt.Fprintf(s.w, "INSERT INTO %s (%s) VALUES\n%s\n%s", s.table, s.columnList(), strings.Join(tuples, ",\n"), onConflict)
+	}
+
+	return nil
+}
+
+// Anything after the rows is a tombstone
+func (s *script) writeDeletes() {
+	recordIDs := []string{}
+
+	for _, record := range s.fmp.Records[len(s.fmp.ResultSet.Rows):] {
+		if recordID, ok := s.fmp.TombstoneRecordID(record); ok {
+			recordID := recordID
+			recordIDs = append(recordIDs, literalValue(&recordID))
+		}
+	}
+
+	if len(recordIDs) == 0 {
+		return
+	}
+
+	fmt.Fprintf(s.w, "DELETE FROM %s WHERE %s IN (%s);\n\n", s.table, s.columns[0].quoted, strings.Join(recordIDs, ", "))
+}
+
+// Turn a value into its PostgreSQL text representation, or nil for NULL
+func textValue(value fmpxmlresult.Value, c column) (*string, error) {
+	if value.IsNull() {
+		return nil, nil
+	}
+
+	var out string
+
+	switch {
+	case c.jsonb:
+		encoded, err := value.MarshalJSON()
+
+		if err != nil {
+			return nil, err
+		}
+
+		out = string(encoded)
+	case c.array:
+		if value.Kind() != fmpxmlresult.ArrayKind {
+			return nil, fmt.Errorf("Expected an array")
+		}
+
+		out = arrayLiteral(value.Items())
+	default:
+		out = value.String()
+	}
+
+	return &out, nil
+}
+
+// Build an array literal like {"a","b",NULL}
+func arrayLiteral(elements []fmpxmlresult.Value) string {
+	out := make([]string, len(elements))
+
+	for i, element := range elements {
+		if element.IsNull() {
+			out[i] = "NULL"
+			continue
+		}
+
+		text := element.String()
+		out[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text) + `"`
+	}
+
+	return "{" + strings.Join(out, ",") + "}"
+}
+
+var copyEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+func copyValue(value *string) string {
+	if value == nil {
+		return `\N`
+	}
+
+	return copyEscaper.Replace(*value)
+}
+
+// Every value is written as a string literal, which PostgreSQL casts to the column type
+func literalValue(value *string) string {
+	if value == nil {
+		return "NULL"
+	}
+
+	return "'" + strings.ReplaceAll(*value, "'", "''") + "'"
+}
+
+var plainIdentifier = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// The reserved key words, which can never be used as unquoted identifiers
+var reservedWords = map[string]bool{}
+
+func init() {
+	words := []string{
+		"all", "analyse", "analyze", "and", "any", "array", "as", "asc", "asymmetric", "authorization",
+		"binary", "both", "case", "cast", "check", "collate", "collation", "column", "concurrently", "constraint",
+		"create", "cross", "current_catalog", "current_date", "current_role", "current_schema", "current_time",
+		"current_timestamp", "current_user", "default", "deferrable", "desc", "distinct", "do", "else", "end",
+		"except", "false", "fetch", "for", "foreign", "freeze", "from", "full", "grant", "group", "having",
+		"ilike", "in", "initially", "inner", "intersect", "into", "is", "isnull", "join", "lateral", "leading",
+		"left", "like", "limit", "localtime", "localtimestamp", "natural", "not", "notnull", "null", "offset",
+		"on", "only", "or", "order", "outer", "overlaps", "placing", "primary", "references", "returning",
+		"right", "select", "session_user", "similar", "some", "symmetric", "system_user", "table", "tablesample",
+		"then", "to", "trailing", "true", "union", "unique", "user", "using", "variadic", "verbose", "when",
+		"where", "window", "with",
+	}
+
+	for _, word := range words {
+		reservedWords[word] = true
+	}
+}
+
+func quoteIdentifier(name, mode string) string {
+	if mode == QuoteNeeded && plainIdentifier.MatchString(name) && !reservedWords[name] {
+		return name
+	}
+
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
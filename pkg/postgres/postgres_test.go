@@ -0,0 +1,265 @@
+package postgres_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+	"github.com/hovercross/fmpxml-to-json/pkg/postgres"
+)
+
+func Test_WriteScriptCopy(t *testing.T) {
+	fmp := &fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12", Layout: "Web", DateFormat: "M/d/yyyy"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "name", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Born", Type: "DATE", MaxRepeat: 1},
+			{Name: "Phones", Type: "TEXT", MaxRepeat: 2},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Joe's\tplace"}}, {Data: []string{"2/3/1980"}}, {Data: []string{"555", `"x"`}}}},
+			{RecordID: "2", ModID: "9", Cols: []fmpxmlresult.Col{{Data: []string{"Sue"}}, {Data: []string{""}}, {Data: []string{}}}},
+		}},
+
+		// An empty declared date is an error, but a mapped one is null
+		TypeMapping: fmpxmlresult.TypeMapping{"Born": {Type: "DATE"}},
+	}
+
+	if err := fmp.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	fmp.Records = append(fmp.Records, fmp.Tombstone("7", "1"))
+
+	out := &bytes.Buffer{}
+
+	if err := postgres.WriteScript(out, fmp, postgres.Options{Quote: postgres.QuoteNeeded}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := `-- Generated from Contacts.fmp12, layout Web
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS "Contacts" (
+  "_recordID" bigint PRIMARY KEY,
+  "_modID" bigint NOT NULL,
+  name text,
+  "Born" date,
+  "Phones" text[]
+);
+
+COPY "Contacts" ("_recordID", "_modID", name, "Born", "Phones") FROM STDIN;
+1	5	Joe's\tplace	1980-02-03	{"555","\\"x\\""}
+2	9	Sue	\N	{}
+\.
+
+DELETE FROM "Contacts" WHERE "_recordID" IN ('7');
+
+COMMIT;
+`
+
+	for _, diff := range deep.Equal(out.String(), expected) {
+		t.Error(diff)
+	}
+}
+
+func Test_WriteScriptInsert(t *testing.T) {
+	fmp := &fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12", Layout: "Web", DateFormat: "M/d/yyyy"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "name", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Born", Type: "DATE", MaxRepeat: 1},
+			{Name: "Phones", Type: "TEXT", MaxRepeat: 2},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Joe's\tplace"}}, {Data: []string{"2/3/1980"}}, {Data: []string{"555", `"x"`}}}},
+			{RecordID: "2", ModID: "9", Cols: []fmpxmlresult.Col{{Data: []string{"Sue"}}, {Data: []string{""}}, {Data: []string{}}}},
+		}},
+
+		// An empty declared date is an error, but a mapped one is null
+		TypeMapping: fmpxmlresult.TypeMapping{"Born": {Type: "DATE"}},
+	}
+
+	if err := fmp.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	out := &bytes.Buffer{}
+
+	opts := postgres.Options{
+		Data:      postgres.DataInsert,
+		Repeats:   postgres.RepeatsJSONB,
+		BatchSize: 1,
+		Types:     map[string]string{"TEXT": "varchar"},
+	}
+
+	if err := postgres.WriteScript(out, fmp, opts); err != nil {
+		t.Error(err)
+		return
+	}
+
+	upsert := `ON CONFLICT ("_recordID") DO UPDATE SET "_modID" = EXCLUDED."_modID", "name" = EXCLUDED."name", "Born" = EXCLUDED."Born", "Phones" = EXCLUDED."Phones"
+  WHERE "Contacts"."_modID" IS DISTINCT FROM EXCLUDED."_modID";
+`
+
+	expected := `-- Generated from Contacts.fmp12, layout Web
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS "Contacts" (
+  "_recordID" bigint PRIMARY KEY,
+  "_modID" bigint NOT NULL,
+  "name" varchar,
+  "Born" date,
+  "Phones" jsonb
+);
+
+INSERT INTO "Contacts" ("_recordID", "_modID", "name", "Born", "Phones") VALUES
+  ('1', '5', 'Joe''s	place', '1980-02-03', '["555","\"x\""]')
+` + upsert + `
+INSERT INTO "Contacts" ("_recordID", "_modID", "name", "Born", "Phones") VALUES
+  ('2', '9', 'Sue', NULL, '[]')
+` + upsert + `
+COMMIT;
+`
+
+	for _, diff := range deep.Equal(out.String(), expected) {
+		t.Error(diff)
+	}
+}
+
+func Test_WriteScriptDerived(t *testing.T) {
+	fmp := &fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12", Layout: "Web", DateFormat: "M/d/yyyy"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "name", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Born", Type: "DATE", MaxRepeat: 1},
+			{Name: "Phones", Type: "TEXT", MaxRepeat: 2},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Joe's\tplace"}}, {Data: []string{"2/3/1980"}}, {Data: []string{"555", `"x"`}}}},
+			{RecordID: "2", ModID: "9", Cols: []fmpxmlresult.Col{{Data: []string{"Sue"}}, {Data: []string{""}}, {Data: []string{}}}},
+		}},
+
+		// An empty declared date is an error, but a mapped one is null
+		TypeMapping: fmpxmlresult.TypeMapping{"Born": {Type: "DATE"}},
+		Derived: []fmpxmlresult.DerivedField{
+			{Key: "shout", Expression: "upper(name)"},
+			{Key: "born_year", Expression: "year(Born)"},
+			{Key: "next_birthday", Expression: "addDays(Born, 365)"},
+			{Key: "has_phone", Expression: "!isEmpty(Phones)"},
+			{Key: "phones", Expression: "Phones"},
+		},
+	}
+
+	if err := fmp.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	out := &bytes.Buffer{}
+
+	if err := postgres.WriteScript(out, fmp, postgres.Options{Quote: postgres.QuoteNeeded}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Derived fields get columns typed by what their expressions produce
+	expected := `-- Generated from Contacts.fmp12, layout Web
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS "Contacts" (
+  "_recordID" bigint PRIMARY KEY,
+  "_modID" bigint NOT NULL,
+  name text,
+  "Born" date,
+  "Phones" text[],
+  shout text,
+  born_year numeric,
+  next_birthday date,
+  has_phone boolean,
+  phones text[]
+);
+
+COPY "Contacts" ("_recordID", "_modID", name, "Born", "Phones", shout, born_year, next_birthday, has_phone, phones) FROM STDIN;
+1	5	Joe's\tplace	1980-02-03	{"555","\\"x\\""}	JOE'S\tPLACE	1980	1981-02-02	true	{"555","\\"x\\""}
+2	9	Sue	\N	{}	SUE	\N	\N	false	{}
+\.
+
+COMMIT;
+`
+
+	for _, diff := range deep.Equal(out.String(), expected) {
+		t.Error(diff)
+	}
+}
+
+func Test_OptionErrors(t *testing.T) {
+	fmp := &fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "name", Type: "TEXT", MaxRepeat: 1},
+		}},
+	}
+
+	for _, opts := range []postgres.Options{
+		{Quote: "sometimes"},
+		{Repeats: "csv"},
+		{Data: "csv"},
+		{BatchSize: -1},
+		{Types: map[string]string{"BLOB": "bytea"}},
+	} {
+		if _, err := postgres.CreateTable(fmp, opts); err == nil {
+			t.Errorf("Err is nil for %+v", opts)
+		}
+	}
+
+	types, err := postgres.ParseTypes("number=double precision, TIME=text")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal(types, map[string]string{"NUMBER": "double precision", "TIME": "text"}) {
+		t.Error(diff)
+	}
+
+	if _, err := postgres.ParseTypes("NUMBER"); err == nil {
+		t.Error("Err is nil for a missing type")
+	}
+}
+
+func Test_CreateTableMappedContainer(t *testing.T) {
+	fmp := &fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "Photo", Type: "TEXT", MaxRepeat: 1},
+			{Name: "Scan", Type: "CONTAINER", MaxRepeat: 1},
+		}},
+		TypeMapping: fmpxmlresult.TypeMapping{"Photo": {Type: "CONTAINER"}},
+	}
+
+	have, err := postgres.CreateTable(fmp, postgres.Options{Quote: postgres.QuoteNeeded})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A mapped container is always a reference, but an unmapped one is only parsed with container options
+	expected := `CREATE TABLE IF NOT EXISTS "Contacts" (
+  "_recordID" bigint PRIMARY KEY,
+  "_modID" bigint NOT NULL,
+  "Photo" jsonb,
+  "Scan" text
+);
+`
+
+	for _, diff := range deep.Equal(have, expected) {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,448 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+)
+
+// This package writes converted exports into a SQLite database, one table per FileMaker table.
+// It works with any database/sql driver for SQLite 3, which the caller is responsible for registering
+
+// LogTable gets a row for every export written, describing what happened
+const LogTable = "_fmpxml_log"
+
+// RecordIDColumn and ModIDColumn are where the record and modification IDs are kept in every table
+const (
+	RecordIDColumn = "_recordID"
+	ModIDColumn    = "_modID"
+)
+
+// ReservedColumns can't be used by a field. Setting them as the export's ReservedKeys before populating the records
+// moves any field that would use one out of the way, according to the key collision policy
+var ReservedColumns = []string{RecordIDColumn, ModIDColumn}
+
+// Options control how an export is written
+type Options struct {
+	Table  string // The table to write to. Defaults to the database name without its extension
+	Source string // Where the export came from, for the conversion log
+}
+
+// Result is what happened to the rows of an export
+type Result struct {
+	Table     string
+	Inserted  int
+	Updated   int
+	Unchanged int
+	Deleted   int
+}
+
+// Write will upsert the records of a converted export into a table, keyed on the record ID, creating the table or adding any missing columns first.
+// A row is only updated if its modification ID changed, so writing each new export of a table updates it incrementally,
+// unless columns were added, when every row is written again so the new columns are filled in.
+// Tombstones, such as from an incremental export, delete their record. Records must already be populated.
+// Everything happens in a single transaction, along with the conversion log entry
+func Write(db *sql.DB, fmp *fmpxmlresult.FMPXMLResult, opts Options) (Result, error) {
+	result := Result{Table: opts.Table}
+
+	if result.Table == "" && fmp.Database != nil {
+		result.Table = strings.TrimSuffix(fmp.Database.Name, filepath.Ext(fmp.Database.Name))
+	}
+
+	if result.Table == "" {
+		return result, fmt.Errorf("No table name given, and the export has no database name")
+	}
+
+	if fmp.Metadata == nil || fmp.ResultSet == nil || len(fmp.Records) < len(fmp.ResultSet.Rows) {
+		return result, fmt.Errorf("Records have not been populated")
+	}
+
+	tx, err := db.Begin()
+
+	if err != nil {
+		return result, err
+	}
+
+	if err := write(tx, fmp, opts, &result); err != nil {
+		tx.Rollback()
+		return result, err
+	}
+
+	return result, tx.Commit()
+}
+
+func write(tx *sql.Tx, fmp *fmpxmlresult.FMPXMLResult, opts Options, result *Result) error {
+	columns, err := tableColumns(fmp)
+
+	if err != nil {
+		return err
+	}
+
+	added, err := createTable(tx, columns, result.Table)
+
+	if err != nil {
+		return fmt.Errorf("Unable to create table '%s': %v", result.Table, err)
+	}
+
+	if err := createLogTable(tx); err != nil {
+		return fmt.Errorf("Unable to create log table: %v", err)
+	}
+
+	statements, err := prepare(tx, columns, result.Table)
+
+	if err != nil {
+		return err
+	}
+
+	defer statements.close()
+
+	statements.refresh = added
+
+	for i, row := range fmp.ResultSet.Rows {
+		if err := statements.upsert(row, fmp.Records[i], columns, result); err != nil {
+			return fmt.Errorf("Unable to write record %s: %v", row.RecordID, err)
+		}
+	}
+
+	// Anything after the rows is a tombstone
+	for _, record := range fmp.Records[len(fmp.ResultSet.Rows):] {
+		recordID, ok := fmp.TombstoneRecordID(record)
+
+		if !ok {
+			continue
+		}
+
+		res, err := statements.delete.Exec(recordID)
+
+		if err != nil {
+			return fmt.Errorf("Unable to delete record %s: %v", recordID, err)
+		}
+
+		if affected, err := res.RowsAffected(); err == nil {
+			result.Deleted += int(affected)
+		}
+	}
+
+	return writeLog(tx, fmp, opts, result)
+}
+
+// A column a record key is written to
+type column struct {
+	key      string
+	affinity string
+}
+
+// Get the columns for the fields and then the derived fields, in the order they're written
+func tableColumns(fmp *fmpxmlresult.FMPXMLResult) ([]column, error) {
+	fields, err := fmp.OutputFields()
+
+	if err != nil {
+		return nil, err
+	}
+
+	derived, err := fmp.DerivedOutputs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	out := []column{}
+	names := []string{}
+
+	for _, f := range fields {
+		out = append(out, column{f.Key, Affinity(fmp, f.Field)})
+		names = append(names, fmt.Sprintf("Field '%s'", f.Field.Name))
+	}
+
+	for _, d := range derived {
+		out = append(out, column{d.Key, DerivedAffinity(d)})
+		names = append(names, fmt.Sprintf("Derived field '%s'", d.Key))
+	}
+
+	// SQLite column names aren't case sensitive
+	for i, c := range out {
+		for _, reserved := range ReservedColumns {
+			if strings.EqualFold(c.key, reserved) {
+				return nil, fmt.Errorf("%s would be written to the reserved '%s' column", names[i], reserved)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// Affinity will get the SQLite column affinity for a field. Repeating fields are stored as JSON arrays, so they are always text
+func Affinity(fmp *fmpxmlresult.FMPXMLResult, f fmpxmlresult.Field) string {
+	if f.MaxRepeat > 1 {
+		return "TEXT"
+	}
+
+	return typeAffinity(fmp.OutputType(f))
+}
+
+// DerivedAffinity will get the SQLite column affinity for a derived field, from the type its expression produces
+func DerivedAffinity(d fmpxmlresult.DerivedOutput) string {
+	if d.Repeat {
+		return "TEXT"
+	}
+
+	return typeAffinity(d.Type)
+}
+
+func typeAffinity(fmType string) string {
+	switch fmType {
+	case "NUMBER":
+		return "NUMERIC"
+	case "BOOLEAN":
+		return "INTEGER"
+	}
+
+	// Dates and times are stored as ISO 8601 text, which SQLite's date functions understand
+	return "TEXT"
+}
+
+// Create the table if it doesn't exist, and add any columns that a newer export has.
+// Whether any columns were added to an existing table is returned, since its rows won't have values for them
+func createTable(tx *sql.Tx, columns []column, table string) (bool, error) {
+	definitions := []string{
+		quoteIdentifier(RecordIDColumn) + " NUMERIC NOT NULL PRIMARY KEY",
+		quoteIdentifier(ModIDColumn) + " NUMERIC NOT NULL",
+	}
+
+	for _, c := range columns {
+		definitions = append(definitions, quoteIdentifier(c.key)+" "+c.affinity)
+	}
+
+	create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quoteIdentifier(table), strings.Join(definitions, ", "))
+
+	if _, err := tx.Exec(create); err != nil {
+		return false, err
+	}
+
+	existing, err := existingColumns(tx, table)
+
+	if err != nil {
+		return false, err
+	}
+
+	added := false
+
+	for _, c := range columns {
+		if existing[strings.ToLower(c.key)] {
+			continue
+		}
+
+		alter := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", quoteIdentifier(table), quoteIdentifier(c.key), c.affinity)
+
+		if _, err := tx.Exec(alter); err != nil {
+			return false, err
+		}
+
+		added = true
+	}
+
+	return added, nil
+}
+
+// Get the columns a table has, by their lowercase names since SQLite column names aren't case sensitive
+func existingColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT name FROM pragma_table_info(%s)", quoteString(table)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	out := map[string]bool{}
+
+	for rows.Next() {
+		var name string
+
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		out[strings.ToLower(name)] = true
+	}
+
+	return out, rows.Err()
+}
+
+func createLogTable(tx *sql.Tx) error {
+	_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		"id" INTEGER PRIMARY KEY,
+		"convertedAt" TEXT NOT NULL,
+		"source" TEXT,
+		"table" TEXT NOT NULL,
+		"database" TEXT,
+		"layout" TEXT,
+		"rows" INTEGER NOT NULL,
+		"inserted" INTEGER NOT NULL,
+		"updated" INTEGER NOT NULL,
+		"unchanged" INTEGER NOT NULL,
+		"deleted" INTEGER NOT NULL
+	)`, quoteIdentifier(LogTable)))
+
+	return err
+}
+
+func writeLog(tx *sql.Tx, fmp *fmpxmlresult.FMPXMLResult, opts Options, result *Result) error {
+	database, layout := "", ""
+
+	if fmp.Database != nil {
+		database, layout = fmp.Database.Name, fmp.Database.Layout
+	}
+
+	insert := fmt.Sprintf(`INSERT INTO %s ("convertedAt", "source", "table", "database", "layout", "rows", "inserted", "updated", "unchanged", "deleted")
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, quoteIdentifier(LogTable))
+
+	_, err := tx.Exec(insert,
+		time.Now().UTC().Format(time.RFC3339), opts.Source, result.Table, database, layout,
+		len(fmp.ResultSet.Rows), result.Inserted, result.Updated, result.Unchanged, result.Deleted,
+	)
+
+	if err != nil {
+		return fmt.Errorf("Unable to write conversion log: %v", err)
+	}
+
+	return nil
+}
+
+// The statements used for every row
+type statements struct {
+	lookup *sql.Stmt
+	insert *sql.Stmt
+	update *sql.Stmt
+	delete *sql.Stmt
+
+	refresh bool // Set when columns were added, so rows with the same modification ID are still updated
+}
+
+func prepare(tx *sql.Tx, tableColumns []column, table string) (*statements, error) {
+	quotedTable := quoteIdentifier(table)
+	quotedRecordID := quoteIdentifier(RecordIDColumn)
+
+	columns := []string{quotedRecordID, quoteIdentifier(ModIDColumn)}
+
+	for _, c := range tableColumns {
+		columns = append(columns, quoteIdentifier(c.key))
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+
+	// The update takes the same arguments as the insert, with the record ID moved to the end for the WHERE
+	assignments := []string{}
+
+	for _, column := range columns[1:] {
+		assignments = append(assignments, column+" = ?")
+	}
+
+	queries := []string{
+		fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", quoteIdentifier(ModIDColumn), quotedTable, quotedRecordID),
+		fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quotedTable, strings.Join(columns, ", "), placeholders),
+		fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", quotedTable, strings.Join(assignments, ", "), quotedRecordID),
+		fmt.Sprintf("DELETE FROM %s WHERE %s = ?", quotedTable, quotedRecordID),
+	}
+
+	prepared := make([]*sql.Stmt, len(queries))
+
+	for i, query := range queries {
+		stmt, err := tx.Prepare(query)
+
+		if err != nil {
+			for _, done := range prepared[:i] {
+				done.Close()
+			}
+
+			return nil, fmt.Errorf("Unable to prepare statement: %v", err)
+		}
+
+		prepared[i] = stmt
+	}
+
+	return &statements{lookup: prepared[0], insert: prepared[1], update: prepared[2], delete: prepared[3]}, nil
+}
+
+func (s *statements) close() {
+	s.lookup.Close()
+	s.insert.Close()
+	s.update.Close()
+	s.delete.Close()
+}
+
+func (s *statements) upsert(row fmpxmlresult.Row, record fmpxmlresult.Record, columns []column, result *Result) error {
+	values := []interface{}{row.RecordID, row.ModID}
+
+	for _, c := range columns {
+		value, err := sqlValue(record[c.key])
+
+		if err != nil {
+			return fmt.Errorf("Field '%s': %v", c.key, err)
+		}
+
+		values = append(values, value)
+	}
+
+	var existingModID string
+
+	err := s.lookup.QueryRow(row.RecordID).Scan(&existingModID)
+
+	if err == sql.ErrNoRows {
+		result.Inserted++
+		_, err = s.insert.Exec(values...)
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if existingModID == row.ModID && !s.refresh {
+		result.Unchanged++
+		return nil
+	}
+
+	result.Updated++
+	_, err = s.update.Exec(append(values[1:], row.RecordID)...)
+
+	return err
+}
+
+// Turn a value into something SQLite can store. Arrays and objects, such as repeating fields and containers, are kept as JSON text
+func sqlValue(v fmpxmlresult.Value) (interface{}, error) {
+	switch v.Kind() {
+	case fmpxmlresult.NullKind:
+		return nil, nil
+	case fmpxmlresult.BoolKind:
+		if v.Bool() {
+			return 1, nil
+		}
+
+		return 0, nil
+	case fmpxmlresult.NumberKind:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+
+		if f, ok := v.Float64(); ok {
+			return f, nil
+		}
+
+		return nil, fmt.Errorf("Number out of range: %s", v.Decimal())
+	}
+
+	return v.String(), nil
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quoteString(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
@@ -0,0 +1,394 @@
+//go:build cgo
+// +build cgo
+
+package sqlite_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/fmpxmlresult"
+	"github.com/hovercross/fmpxml-to-json/pkg/sqlite"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func Test_Write(t *testing.T) {
+	metadata := fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+		{Name: "Name", Type: "TEXT", MaxRepeat: 1},
+		{Name: "Age", Type: "NUMBER", MaxRepeat: 1},
+		{Name: "Phones", Type: "TEXT", MaxRepeat: 2},
+	}}
+
+	db, err := sql.Open("sqlite3", ":memory:")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer db.Close()
+
+	// An in memory database only lives as long as its connection
+	db.SetMaxOpenConns(1)
+
+	first := &fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12", DateFormat: "M/d/yyyy"},
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Joe"}}, {Data: []string{"34"}}, {Data: []string{"555-1234"}}}},
+			{RecordID: "2", ModID: "9", Cols: []fmpxmlresult.Col{{Data: []string{"Susan"}}, {Data: []string{"28"}}, {}}},
+		}},
+	}
+
+	second := &fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12", DateFormat: "M/d/yyyy"},
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "6", Cols: []fmpxmlresult.Col{{Data: []string{"Joe"}}, {Data: []string{"35"}}, {Data: []string{"555-1234"}}}},
+			{RecordID: "3", ModID: "1", Cols: []fmpxmlresult.Col{{Data: []string{"Ann"}}, {Data: []string{"51"}}, {}}},
+		}},
+	}
+
+	for _, fmp := range []*fmpxmlresult.FMPXMLResult{first, second} {
+		if err := fmp.PopulateRecords(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	result, err := sqlite.Write(db, first, sqlite.Options{Source: "first.xml"})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal(result, sqlite.Result{Table: "Contacts", Inserted: 2}) {
+		t.Error(diff)
+	}
+
+	// Joe has changed, Ann is new, and Susan is gone
+	second.Records = append(second.Records, second.Tombstone("2", "9"))
+
+	result, err = sqlite.Write(db, second, sqlite.Options{Source: "second.xml"})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal(result, sqlite.Result{Table: "Contacts", Inserted: 1, Updated: 1, Deleted: 1}) {
+		t.Error(diff)
+	}
+
+	// Nothing has changed the second time around
+	result, err = sqlite.Write(db, second, sqlite.Options{Source: "second.xml"})
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal(result, sqlite.Result{Table: "Contacts", Unchanged: 2}) {
+		t.Error(diff)
+	}
+
+	type contact struct {
+		RecordID int64
+		Name     string
+		Age      sql.NullInt64
+		Phones   string
+	}
+
+	rows, err := db.Query(`SELECT "_recordID", "Name", "Age", "Phones" FROM "Contacts" ORDER BY "_recordID"`)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer rows.Close()
+
+	contacts := []contact{}
+
+	for rows.Next() {
+		c := contact{}
+
+		if err := rows.Scan(&c.RecordID, &c.Name, &c.Age, &c.Phones); err != nil {
+			t.Error(err)
+			return
+		}
+
+		contacts = append(contacts, c)
+	}
+
+	expected := []contact{
+		{RecordID: 1, Name: "Joe", Age: sql.NullInt64{Int64: 35, Valid: true}, Phones: `["555-1234"]`},
+		{RecordID: 3, Name: "Ann", Age: sql.NullInt64{Int64: 51, Valid: true}, Phones: `[]`},
+	}
+
+	for _, diff := range deep.Equal(contacts, expected) {
+		t.Error(diff)
+	}
+
+	var logEntries int
+
+	if err := db.QueryRow(`SELECT COUNT(*) FROM "_fmpxml_log"`).Scan(&logEntries); err != nil || logEntries != 3 {
+		t.Errorf("Expected 3 log entries, got %d, %v", logEntries, err)
+	}
+}
+
+func Test_WriteDerived(t *testing.T) {
+	metadata := fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+		{Name: "Name", Type: "TEXT", MaxRepeat: 1},
+		{Name: "Age", Type: "NUMBER", MaxRepeat: 1},
+		{Name: "Phones", Type: "TEXT", MaxRepeat: 2},
+	}}
+
+	db, err := sql.Open("sqlite3", ":memory:")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+
+	fmp := &fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12", DateFormat: "M/d/yyyy"},
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Joe"}}, {Data: []string{"34"}}, {Data: []string{"555-1234"}}}},
+		}},
+	}
+
+	fmp.Derived = []fmpxmlresult.DerivedField{
+		{Key: "Shout", Expression: "upper(Name)"},
+		{Key: "Months", Expression: "Age * 12"},
+		{Key: "Adult", Expression: "Age >= 18"},
+	}
+
+	if err := fmp.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := sqlite.Write(db, fmp, sqlite.Options{}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Derived fields get columns, with the affinity of what their expressions produce
+	type column struct {
+		Name     string
+		Affinity string
+	}
+
+	rows, err := db.Query(`SELECT name, type FROM pragma_table_info('Contacts') WHERE name IN ('Shout', 'Months', 'Adult') ORDER BY cid`)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer rows.Close()
+
+	columns := []column{}
+
+	for rows.Next() {
+		c := column{}
+
+		if err := rows.Scan(&c.Name, &c.Affinity); err != nil {
+			t.Error(err)
+			return
+		}
+
+		columns = append(columns, c)
+	}
+
+	for _, diff := range deep.Equal(columns, []column{{"Shout", "TEXT"}, {"Months", "NUMERIC"}, {"Adult", "INTEGER"}}) {
+		t.Error(diff)
+	}
+
+	var shout string
+	var months, adult int64
+
+	if err := db.QueryRow(`SELECT "Shout", "Months", "Adult" FROM "Contacts"`).Scan(&shout, &months, &adult); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal([]interface{}{shout, months, adult}, []interface{}{"JOE", int64(408), int64(1)}) {
+		t.Error(diff)
+	}
+
+	// A derived field can't take the place of the record or modification ID either
+	clashing := &fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12", DateFormat: "M/d/yyyy"},
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Joe"}}, {Data: []string{"34"}}, {}}},
+		}},
+	}
+
+	clashing.Derived = []fmpxmlresult.DerivedField{{Key: "_ModID", Expression: "Name"}}
+
+	if err := clashing.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := sqlite.Write(db, clashing, sqlite.Options{}); err == nil {
+		t.Error("Err is nil for a derived field on a reserved column")
+	}
+}
+
+func Test_WriteAddedColumns(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+
+	first := &fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "Name", Type: "TEXT", MaxRepeat: 1},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Joe"}}}},
+		}},
+	}
+
+	// The same record, but the field has been renamed in a different case and there's a new derived field
+	second := &fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12"},
+		Metadata: &fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+			{Name: "NAME", Type: "TEXT", MaxRepeat: 1},
+		}},
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Joe"}}}},
+		}},
+		Derived: []fmpxmlresult.DerivedField{{Key: "Shout", Expression: "upper(NAME)"}},
+	}
+
+	for _, fmp := range []*fmpxmlresult.FMPXMLResult{first, second} {
+		if err := fmp.PopulateRecords(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	var tests = []struct {
+		fmp  *fmpxmlresult.FMPXMLResult
+		want sqlite.Result
+	}{
+		{first, sqlite.Result{Table: "Contacts", Inserted: 1}},
+		{second, sqlite.Result{Table: "Contacts", Updated: 1}},
+		{second, sqlite.Result{Table: "Contacts", Unchanged: 1}},
+	}
+
+	// The row hasn't changed, but it is written again the first time so the new column is filled in
+	for i, tt := range tests {
+		result, err := sqlite.Write(db, tt.fmp, sqlite.Options{})
+
+		if err != nil {
+			t.Errorf("Write %d: %v", i, err)
+			return
+		}
+
+		for _, diff := range deep.Equal(result, tt.want) {
+			t.Errorf("Write %d: %s", i, diff)
+		}
+	}
+
+	var name, shout string
+
+	if err := db.QueryRow(`SELECT "Name", "Shout" FROM "Contacts"`).Scan(&name, &shout); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, diff := range deep.Equal([]string{name, shout}, []string{"Joe", "JOE"}) {
+		t.Error(diff)
+	}
+}
+
+func Test_WriteErrors(t *testing.T) {
+	metadata := fmpxmlresult.Metadata{Fields: []fmpxmlresult.Field{
+		{Name: "Name", Type: "TEXT", MaxRepeat: 1},
+		{Name: "Age", Type: "NUMBER", MaxRepeat: 1},
+		{Name: "Phones", Type: "TEXT", MaxRepeat: 2},
+	}}
+
+	db, err := sql.Open("sqlite3", ":memory:")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	defer db.Close()
+
+	unpopulated := &fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12", DateFormat: "M/d/yyyy"},
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Joe"}}, {Data: []string{"34"}}, {}}},
+		}},
+	}
+
+	if _, err := sqlite.Write(db, unpopulated, sqlite.Options{}); err == nil {
+		t.Error("Err is nil for unpopulated records")
+	}
+
+	// A field can't take the place of the record or modification ID, unless the collision policy moves it
+	clashing := &fmpxmlresult.FMPXMLResult{
+		Database: &fmpxmlresult.Database{Name: "Contacts.fmp12", DateFormat: "M/d/yyyy"},
+		Metadata: &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{Rows: []fmpxmlresult.Row{
+			{RecordID: "1", ModID: "5", Cols: []fmpxmlresult.Col{{Data: []string{"Joe"}}, {Data: []string{"34"}}, {}}},
+		}},
+	}
+
+	clashing.Naming.Renames = map[string]string{"Age": "_modID"}
+
+	if err := clashing.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := sqlite.Write(db, clashing, sqlite.Options{}); err == nil {
+		t.Error("Err is nil for a field on a reserved column")
+	}
+
+	clashing.ReservedKeys = sqlite.ReservedColumns
+	clashing.KeyCollisions = fmpxmlresult.CollisionSuffix
+
+	if err := clashing.PopulateRecords(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := sqlite.Write(db, clashing, sqlite.Options{}); err != nil {
+		t.Error(err)
+	}
+
+	unnamed := &fmpxmlresult.FMPXMLResult{
+		Database:  &fmpxmlresult.Database{DateFormat: "M/d/yyyy"},
+		Metadata:  &metadata,
+		ResultSet: &fmpxmlresult.ResultSet{},
+	}
+
+	if _, err := sqlite.Write(db, unnamed, sqlite.Options{}); err == nil {
+		t.Error("Err is nil without a table name")
+	}
+}
@@ -0,0 +1,15 @@
+package timeconv
+
+import "strings"
+
+// ParseTimestampFormat will convert a Filemaker timestamp format into a Go time format string.
+// The date and time portions are split on the first space, since "mm" means something different in each half
+func ParseTimestampFormat(fmt string) string {
+	parts := strings.SplitN(fmt, " ", 2)
+
+	if len(parts) == 1 {
+		return ParseDateFormat(parts[0])
+	}
+
+	return ParseDateFormat(parts[0]) + " " + ParseTimeFormat(parts[1])
+}
@@ -0,0 +1,26 @@
+package timeconv_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/timeconv"
+)
+
+func TestParseTimestampFormat(t *testing.T) {
+	tests := []struct {
+		fmt  string
+		want string
+	}{
+		{"M/d/yyyy h:mm:ss a", "1/2/2006 3:04:05 PM"},
+		{"yyyy-MM-dd kk:mm:ss", "2006-01-02 15:04:05"},
+		{"yyyy-mm-dd", "2006-01-02"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("Test %d", i), func(t *testing.T) {
+			if got := timeconv.ParseTimestampFormat(tt.fmt); got != tt.want {
+				t.Errorf("ParseTimestampFormat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package xmlreader
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// This file has the transparent decompression of input, so exports don't need to be unpacked to disk first
+
+// Input is a single XML document, possibly from inside a compressed file or archive
+type Input struct {
+	Name string // The archive entry name, or the name the input was opened with
+	io.ReadCloser
+}
+
+// The magic bytes at the start of each compression format we understand
+var compressionSignatures = []struct {
+	prefix []byte
+	format string
+}{
+	{[]byte{0x1F, 0x8B}, "gzip"},
+	{[]byte("BZh"), "bzip2"},
+	{[]byte{0x28, 0xB5, 0x2F, 0xFD}, "zstd"},
+	{[]byte("PK\x03\x04"), "zip"},
+}
+
+// DetectCompression will look at the start of the input for a known compression format.
+// It returns the format, or an empty string if the input doesn't look compressed, along with a reader for the whole input
+func DetectCompression(r io.Reader) (string, io.Reader) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(4) // Short input is fine, it just won't match anything
+
+	for _, sig := range compressionSignatures {
+		if bytes.HasPrefix(head, sig.prefix) {
+			return sig.format, br
+		}
+	}
+
+	return "", br
+}
+
+// Decompress will wrap a reader so gzip, bzip2 and zstd input is decompressed on the fly.
+// Uncompressed input is passed through untouched. Zip archives can hold more than one file, so they need OpenInputs
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	format, br := DetectCompression(r)
+
+	return decompress(format, br)
+}
+
+func decompress(format string, r io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case "":
+		return ioutil.NopCloser(r), nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "bzip2":
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return decoder.IOReadCloser(), nil
+	}
+
+	return nil, fmt.Errorf("Cannot decompress %s as a stream", format)
+}
+
+// OpenInputs will detect any compression and return each XML document inside the input.
+// Everything but a zip archive holds exactly one document, and in a zip archive every .xml entry is a document.
+// The inputs should be closed by the caller, along with the original reader
+func OpenInputs(r io.Reader, name string) ([]Input, error) {
+	format, br := DetectCompression(r)
+
+	if format != "zip" {
+		rc, err := decompress(format, br)
+
+		if err != nil {
+			return nil, fmt.Errorf("Could not decompress %s input: %v", format, err)
+		}
+
+		return []Input{{name, rc}}, nil
+	}
+
+	archive, err := openZip(r, br)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read zip archive: %v", err)
+	}
+
+	out := []Input{}
+
+	for _, f := range archive.File {
+		if !isXMLEntry(f) {
+			continue
+		}
+
+		rc, err := f.Open()
+
+		if err != nil {
+			closeInputs(out)
+
+			return nil, fmt.Errorf("Could not open '%s' in zip archive: %v", f.Name, err)
+		}
+
+		out = append(out, Input{f.Name, rc})
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("Zip archive has no XML files")
+	}
+
+	return out, nil
+}
+
+// Zip needs random access, so files get read in place and anything else gets buffered in memory
+func openZip(original io.Reader, buffered io.Reader) (*zip.Reader, error) {
+	if f, ok := original.(*os.File); ok {
+		if stat, err := f.Stat(); err == nil && stat.Mode().IsRegular() {
+			return zip.NewReader(f, stat.Size())
+		}
+	}
+
+	data, err := ioutil.ReadAll(buffered)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return zip.NewReader(bytes.NewReader(data), int64(len(data)))
+}
+
+// Skip directories and the resource forks macOS leaves in its archives
+func isXMLEntry(f *zip.File) bool {
+	if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+		return false
+	}
+
+	return strings.EqualFold(path.Ext(f.Name), ".xml")
+}
+
+func closeInputs(inputs []Input) {
+	for _, input := range inputs {
+		input.Close()
+	}
+}
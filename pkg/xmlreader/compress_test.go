@@ -0,0 +1,162 @@
+package xmlreader_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hovercross/fmpxml-to-json/pkg/xmlreader"
+	"github.com/klauspost/compress/zstd"
+)
+
+// "hello", compressed with bzip2 -9, since the standard library can't write bzip2
+var bzip2Hello = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x19, 0x31,
+	0x65, 0x3d, 0x00, 0x00, 0x00, 0x81, 0x00, 0x02, 0x44, 0xa0, 0x00, 0x21,
+	0x9a, 0x68, 0x33, 0x4d, 0x07, 0x33, 0x8b, 0xb9, 0x22, 0x9c, 0x28, 0x48,
+	0x0c, 0x98, 0xb2, 0x9e, 0x80,
+}
+
+func gzipHello(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func zstdHello(t *testing.T) []byte {
+	encoder, err := zstd.NewWriter(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encoder.EncodeAll([]byte("hello"), nil)
+}
+
+func Test_Decompress(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"plain", []byte("hello")},
+		{"gzip", gzipHello(t)},
+		{"bzip2", bzip2Hello},
+		{"zstd", zstdHello(t)},
+	}
+
+	for _, tt := range tests {
+		rc, err := xmlreader.Decompress(bytes.NewReader(tt.input))
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if string(got) != "hello" {
+			t.Errorf("%s: got %q", tt.name, got)
+		}
+	}
+}
+
+func Test_OpenInputsZip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+
+	entries := []struct {
+		name    string
+		content string
+	}{
+		{"exports/", ""},
+		{"exports/people.xml", "people"},
+		{"exports/readme.txt", "not xml"},
+		{"__MACOSX/exports/._people.xml", "resource fork"},
+		{"exports/places.XML", "places"},
+	}
+
+	for _, entry := range entries {
+		f, err := w.Create(entry.name)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := f.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, err := xmlreader.OpenInputs(buf, "archive.zip")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got := map[string]string{}
+
+	for _, input := range inputs {
+		data, err := ioutil.ReadAll(input)
+		input.Close()
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		got[input.Name] = string(data)
+	}
+
+	expected := map[string]string{
+		"exports/people.xml": "people",
+		"exports/places.XML": "places",
+	}
+
+	for _, diff := range deep.Equal(got, expected) {
+		t.Error(diff)
+	}
+}
+
+func Test_OpenInputsSingle(t *testing.T) {
+	inputs, err := xmlreader.OpenInputs(bytes.NewReader(gzipHello(t)), "hello.xml.gz")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(inputs) != 1 || inputs[0].Name != "hello.xml.gz" {
+		t.Errorf("Unexpected inputs: %v", inputs)
+		return
+	}
+
+	data, err := ioutil.ReadAll(inputs[0])
+	inputs[0].Close()
+
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Got %q, %v", data, err)
+	}
+}
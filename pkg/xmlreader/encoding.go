@@ -0,0 +1,197 @@
+package xmlreader
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// This file has the character encoding support. Go's XML decoder only understands UTF-8 on its own,
+// but older Filemaker installs and Windows tooling produce UTF-16, Windows-1252, and MacRoman
+
+// The encodings we know how to transcode, keyed by their lowercased name and common aliases.
+// UTF-8 and ASCII are nil, since there is nothing to transcode
+var encodings = map[string]encoding.Encoding{
+	"utf-8":        nil,
+	"utf8":         nil,
+	"us-ascii":     nil,
+	"ascii":        nil,
+	"utf-16":       unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	"utf16":        unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"windows-1252": charmap.Windows1252,
+	"cp1252":       charmap.Windows1252,
+	"x-cp1252":     charmap.Windows1252,
+	"iso-8859-1":   charmap.ISO8859_1,
+	"iso8859-1":    charmap.ISO8859_1,
+	"iso_8859-1":   charmap.ISO8859_1,
+	"latin1":       charmap.ISO8859_1,
+	"l1":           charmap.ISO8859_1,
+	"macintosh":    charmap.Macintosh,
+	"macroman":     charmap.Macintosh,
+	"mac":          charmap.Macintosh,
+	"x-mac-roman":  charmap.Macintosh,
+}
+
+// Byte order marks, and the start of an XML declaration for UTF-16 without a BOM
+var encodingSignatures = []struct {
+	prefix   []byte
+	encoding string
+	skip     int // How much of the prefix to strip
+}{
+	{[]byte{0xEF, 0xBB, 0xBF}, "utf-8", 3},
+	{[]byte{0xFF, 0xFE}, "utf-16le", 2},
+	{[]byte{0xFE, 0xFF}, "utf-16be", 2},
+	{[]byte{'<', 0x00, '?', 0x00}, "utf-16le", 0},
+	{[]byte{0x00, '<', 0x00, '?'}, "utf-16be", 0},
+}
+
+// How far into the input we will look for the XML declaration
+const declarationLimit = 1024
+
+var (
+	declarationEncodingPattern = regexp.MustCompile(`encoding\s*=\s*["']([^"']*)["']`)
+	declarationEnd             = []byte("?>")
+)
+
+// NewUTF8Reader will wrap a reader so it produces UTF-8, whatever the input was.
+// If charset is set, the input is converted from it no matter what else the input says.
+// Otherwise a byte order mark wins over the XML declaration, and without either the input is assumed to be UTF-8.
+// The XML declaration is rewritten to say UTF-8, so the output can go through other filters or be decoded again safely
+func NewUTF8Reader(r io.Reader, charset string) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, declarationLimit)
+
+	// Always look for a BOM, so it gets skipped even if the encoding was given.
+	// Plain UTF-16 doesn't say which byte order it is, so a BOM still decides that
+	detected := detectEncoding(br)
+
+	if charset == "" || (isUTF16(charset) && strings.HasPrefix(detected, "utf-16")) {
+		charset = detected
+	}
+
+	if charset == "" {
+		charset = declaredEncoding(br)
+	}
+
+	transcoded, err := Transcode(br, charset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rewriteDeclaration(transcoded), nil
+}
+
+// Transcode will wrap a reader so the given encoding is converted to UTF-8. An empty charset means UTF-8
+func Transcode(r io.Reader, charset string) (io.Reader, error) {
+	if charset == "" {
+		return r, nil
+	}
+
+	enc, found := encodings[normalizeCharset(charset)]
+
+	if !found {
+		return nil, fmt.Errorf("Unsupported encoding '%s'", charset)
+	}
+
+	if enc == nil {
+		return r, nil
+	}
+
+	return transform.NewReader(r, enc.NewDecoder()), nil
+}
+
+func normalizeCharset(charset string) string {
+	return strings.ToLower(strings.TrimSpace(charset))
+}
+
+// Look for a byte order mark or a UTF-16 XML declaration at the start of the data, and skip past any BOM
+func detectEncoding(br *bufio.Reader) string {
+	head, _ := br.Peek(4) // Short input is fine, it just won't match anything
+
+	for _, sig := range encodingSignatures {
+		if bytes.HasPrefix(head, sig.prefix) {
+			br.Discard(sig.skip)
+			return sig.encoding
+		}
+	}
+
+	return ""
+}
+
+// Get the encoding from the XML declaration, if there is one
+func declaredEncoding(br *bufio.Reader) string {
+	head, _ := br.Peek(declarationLimit)
+
+	decl := getDeclaration(head)
+
+	if decl == nil {
+		return ""
+	}
+
+	match := declarationEncodingPattern.FindSubmatch(decl)
+
+	if match == nil {
+		return ""
+	}
+
+	charset := string(match[1])
+
+	// If we could read a declaration saying UTF-16 without a BOM, the declaration is wrong
+	if strings.HasPrefix(normalizeCharset(charset), "utf-16") || strings.HasPrefix(normalizeCharset(charset), "utf16") {
+		return ""
+	}
+
+	return charset
+}
+
+// Whether the charset is UTF-16 without a byte order
+func isUTF16(charset string) bool {
+	switch normalizeCharset(charset) {
+	case "utf-16", "utf16":
+		return true
+	}
+
+	return false
+}
+
+// Get the "<?xml ... ?>" declaration from the start of the data, or nil if there isn't one
+func getDeclaration(head []byte) []byte {
+	if !bytes.HasPrefix(head, []byte("<?xml")) {
+		return nil
+	}
+
+	end := bytes.Index(head, declarationEnd)
+
+	if end == -1 {
+		return nil
+	}
+
+	return head[:end+len(declarationEnd)]
+}
+
+// Change the encoding in the XML declaration to UTF-8, now that it is
+func rewriteDeclaration(r io.Reader) io.Reader {
+	br := bufio.NewReaderSize(r, declarationLimit)
+	head, _ := br.Peek(declarationLimit)
+
+	decl := getDeclaration(head)
+
+	if decl == nil || !declarationEncodingPattern.Match(decl) {
+		return br
+	}
+
+	rewritten := declarationEncodingPattern.ReplaceAll(decl, []byte(`encoding="UTF-8"`))
+	br.Discard(len(decl))
+
+	return io.MultiReader(bytes.NewReader(rewritten), br)
+}
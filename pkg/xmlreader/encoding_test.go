@@ -0,0 +1,110 @@
+package xmlreader_test
+
+import (
+	"bytes"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/xmlreader"
+)
+
+// Build a minimal document with a single text value, with the given declaration
+func encodingSample(declaration, value string) string {
+	return declaration + `<FMPXMLRESULT xmlns="http://www.filemaker.com/fmpxmlresult">
+		<ERRORCODE>0</ERRORCODE>
+		<PRODUCT BUILD="06-07-2018" NAME="FileMaker" VERSION="Server 17.0.2"/>
+		<DATABASE DATEFORMAT="M/d/yyyy" LAYOUT="Overview" NAME="test.fmp12" RECORDS="1" TIMEFORMAT="h:mm:ss a"/>
+		<METADATA>
+			<FIELD EMPTYOK="YES" MAXREPEAT="1" NAME="Name" TYPE="TEXT"/>
+		</METADATA>
+		<RESULTSET FOUND="1">
+			<ROW MODID="1" RECORDID="1"><COL><DATA>` + value + `</DATA></COL></ROW>
+		</RESULTSET>
+	</FMPXMLRESULT>`
+}
+
+func toUTF16(s string, bigEndian, bom bool) []byte {
+	units := utf16.Encode([]rune(s))
+
+	if bom {
+		units = append([]uint16{0xFEFF}, units...)
+	}
+
+	out := make([]byte, 0, len(units)*2)
+
+	for _, u := range units {
+		if bigEndian {
+			out = append(out, byte(u>>8), byte(u))
+		} else {
+			out = append(out, byte(u), byte(u>>8))
+		}
+	}
+
+	return out
+}
+
+func Test_Encodings(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		override string
+	}{
+		{"UTF-8", []byte(encodingSample(`<?xml version="1.0" encoding="UTF-8"?>`, "Café “quoted”")), ""},
+		{"UTF-8 BOM", append([]byte{0xEF, 0xBB, 0xBF}, encodingSample(`<?xml version="1.0"?>`, "Café “quoted”")...), ""},
+		{"UTF-16LE BOM", toUTF16(encodingSample(`<?xml version="1.0" encoding="UTF-16"?>`, "Café “quoted”"), false, true), ""},
+		{"UTF-16BE BOM", toUTF16(encodingSample(`<?xml version="1.0" encoding="UTF-16"?>`, "Café “quoted”"), true, true), ""},
+		{"UTF-16LE no BOM", toUTF16(encodingSample(`<?xml version="1.0" encoding="UTF-16"?>`, "Café “quoted”"), false, false), ""},
+		{"Windows-1252", []byte(encodingSample(`<?xml version="1.0" encoding="windows-1252"?>`, "Caf\xe9 \x93quoted\x94")), ""},
+		{"UTF-16LE BOM override", toUTF16(encodingSample(`<?xml version="1.0" encoding="UTF-16"?>`, "Café “quoted”"), false, true), "utf-16"},
+		{"UTF-16BE BOM override", toUTF16(encodingSample(`<?xml version="1.0" encoding="UTF-16"?>`, "Café “quoted”"), true, true), "UTF-16"},
+		{"UTF-16LE BOM explicit override", toUTF16(encodingSample(`<?xml version="1.0" encoding="UTF-16"?>`, "Café “quoted”"), false, true), "utf-16le"},
+		{"MacRoman override", []byte(encodingSample(`<?xml version="1.0" encoding="UTF-8"?>`, "Caf\x8e \xd2quoted\xd3")), "macintosh"},
+	}
+
+	for _, tt := range tests {
+		input, err := xmlreader.NewUTF8Reader(bytes.NewReader(tt.input), tt.override)
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		parsed, err := xmlreader.ReadXML(input)
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if got := parsed.ResultSet.Rows[0].Cols[0].Data[0]; got != "Café “quoted”" {
+			t.Errorf("%s: got %q", tt.name, got)
+		}
+	}
+}
+
+func Test_ISO88591(t *testing.T) {
+	input := encodingSample(`<?xml version="1.0" encoding="ISO-8859-1"?>`, "Caf\xe9")
+
+	parsed, err := xmlreader.ReadXML(bytes.NewReader([]byte(input)))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if got := parsed.ResultSet.Rows[0].Cols[0].Data[0]; got != "Café" {
+		t.Errorf("Got %q", got)
+	}
+}
+
+func Test_UnknownEncoding(t *testing.T) {
+	if _, err := xmlreader.NewUTF8Reader(bytes.NewReader(nil), "EBCDIC"); err == nil {
+		t.Error("Did not get an error")
+	}
+
+	input := encodingSample(`<?xml version="1.0" encoding="EBCDIC"?>`, "Adam")
+
+	if _, err := xmlreader.ReadXML(bytes.NewReader([]byte(input))); err == nil {
+		t.Error("Did not get an error")
+	}
+}
@@ -7,14 +7,23 @@ import (
 )
 
 func readInternalFormat(r io.Reader) (document, error) {
-	data, err := ioutil.ReadAll(r)
+	input, err := NewUTF8Reader(r, "")
+
+	if err != nil {
+		return document{}, err
+	}
+
+	data, err := ioutil.ReadAll(input)
 
 	if err != nil {
 		return document{}, err
 	}
 
 	out := document{}
-	xml.Unmarshal(data, &out)
+
+	if err := xml.Unmarshal(data, &out); err != nil {
+		return document{}, err
+	}
 
 	return out, nil
 }
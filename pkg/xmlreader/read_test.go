@@ -263,3 +263,25 @@ type errorReader struct{}
 func (er errorReader) Read(buf []byte) (int, error) {
 	return 0, fmt.Errorf("Error goes here")
 }
+
+func Test_ParseMetadataOnly(t *testing.T) {
+	sampleData := []byte(`<?xml version="1.0" encoding="UTF-8" ?>
+	<FMPXMLRESULT xmlns="http://www.filemaker.com/fmpxmlresult">
+		<ERRORCODE>0</ERRORCODE>
+		<DATABASE DATEFORMAT="M/d/yyyy" LAYOUT="Overview" NAME="test.fmp12" RECORDS="0" TIMEFORMAT="h:mm:ss a"/>
+		<METADATA>
+			<FIELD EMPTYOK="YES" MAXREPEAT="1" NAME="First" TYPE="TEXT"/>
+		</METADATA>
+	</FMPXMLRESULT>`)
+
+	parsed, err := xmlreader.ReadXML(bytes.NewReader(sampleData))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(parsed.Metadata.Fields) != 1 || len(parsed.ResultSet.Rows) != 0 {
+		t.Errorf("Expected one field and no rows, got %+v and %+v", parsed.Metadata, parsed.ResultSet)
+	}
+}
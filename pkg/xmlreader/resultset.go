@@ -19,6 +19,11 @@ func (rs resultSet) Normalize() (*fmpxmlresult.ResultSet, error) {
 		Rows: make([]fmpxmlresult.Row, len(rs.Rows)),
 	}
 
+	// An export of just the metadata has no result set at all, so there is nothing to count
+	if rs.Found == "" && len(rs.Rows) == 0 {
+		return &out, nil
+	}
+
 	found, err := strconv.Atoi(rs.Found)
 
 	if err != nil {
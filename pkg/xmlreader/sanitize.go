@@ -0,0 +1,169 @@
+package xmlreader
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// SanitizeMode controls what happens to characters that aren't allowed in XML
+type SanitizeMode int
+
+const (
+	// SanitizeReplace will replace invalid characters with U+FFFD
+	SanitizeReplace SanitizeMode = iota
+	// SanitizeDrop will remove invalid characters entirely
+	SanitizeDrop
+)
+
+// SanitizeOptions controls how a Sanitizer repairs its input
+type SanitizeOptions struct {
+	Mode SanitizeMode
+
+	// Filemaker uses U+000B for soft returns in text fields, which is much more useful as a newline
+	VerticalTabAsNewline bool
+}
+
+// The longest character reference we will look at, which is "#x10FFFF;" with some leading zeros to spare
+const maxCharRefLength = 16
+
+// Sanitizer is an input filter that repairs characters Go's XML decoder rejects,
+// both as raw control characters and as character references like "&#11;"
+type Sanitizer struct {
+	reader  *bufio.Reader
+	opts    SanitizeOptions
+	pending []byte
+	err     error
+	repairs int
+}
+
+// NewSanitizer will wrap a reader so invalid XML characters are repaired before the decoder sees them
+func NewSanitizer(r io.Reader, opts SanitizeOptions) *Sanitizer {
+	return &Sanitizer{
+		reader: bufio.NewReader(r),
+		opts:   opts,
+	}
+}
+
+// Repairs is the number of characters that have been replaced or dropped so far
+func (s *Sanitizer) Repairs() int {
+	return s.repairs
+}
+
+func (s *Sanitizer) Read(p []byte) (int, error) {
+	// Fill as much of p as we can. A dropped character produces no output, so keep going until there's something to return,
+	// but after that, stop rather than wait on the underlying reader once its buffer runs dry
+	for len(s.pending) < len(p) && s.err == nil {
+		if len(s.pending) > 0 && s.reader.Buffered() == 0 {
+			break
+		}
+
+		s.fill()
+	}
+
+	n := copy(p, s.pending)
+
+	if n == len(s.pending) {
+		s.pending = s.pending[:0]
+	} else {
+		s.pending = s.pending[n:]
+	}
+
+	if n == 0 {
+		return 0, s.err
+	}
+
+	return n, nil
+}
+
+// Read a single character from the underlying reader, and add its repaired form to the pending output
+func (s *Sanitizer) fill() {
+	r, size, err := s.reader.ReadRune()
+
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	// Invalid UTF-8 isn't our problem: pass the original byte through untouched
+	if r == utf8.RuneError && size == 1 {
+		s.reader.UnreadRune()
+		b, _ := s.reader.ReadByte()
+		s.pending = append(s.pending, b)
+		return
+	}
+
+	if r == '&' {
+		s.fillReference()
+		return
+	}
+
+	if !isValidXMLChar(r) {
+		s.repair(r)
+		return
+	}
+
+	s.pending = append(s.pending, string(r)...)
+}
+
+// We've just read an ampersand: if this is a numeric reference to an invalid character, repair it
+func (s *Sanitizer) fillReference() {
+	peeked, _ := s.reader.Peek(maxCharRefLength)
+	end := bytes.IndexByte(peeked, ';')
+
+	if end == -1 || len(peeked) < 2 || peeked[0] != '#' {
+		s.pending = append(s.pending, '&')
+		return
+	}
+
+	r, ok := parseCharRef(peeked[1:end])
+
+	if !ok || isValidXMLChar(r) {
+		s.pending = append(s.pending, '&')
+		return
+	}
+
+	s.reader.Discard(end + 1)
+	s.repair(r)
+}
+
+func (s *Sanitizer) repair(r rune) {
+	s.repairs++
+
+	if r == '\v' && s.opts.VerticalTabAsNewline {
+		s.pending = append(s.pending, '\n')
+		return
+	}
+
+	if s.opts.Mode == SanitizeReplace {
+		s.pending = append(s.pending, string(utf8.RuneError)...)
+	}
+}
+
+// Parse the body of a numeric character reference, like "11" or "x0B"
+func parseCharRef(ref []byte) (rune, bool) {
+	base := 10
+
+	if len(ref) > 0 && (ref[0] == 'x' || ref[0] == 'X') {
+		base = 16
+		ref = ref[1:]
+	}
+
+	v, err := strconv.ParseUint(string(ref), base, 32)
+
+	if err != nil {
+		return 0, false
+	}
+
+	return rune(v), true
+}
+
+// Straight from the XML 1.0 Char production
+func isValidXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		(r >= 0x20 && r <= 0xD7FF) ||
+		(r >= 0xE000 && r <= 0xFFFD) ||
+		(r >= 0x10000 && r <= 0x10FFFF)
+}
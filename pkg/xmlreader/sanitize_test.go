@@ -0,0 +1,100 @@
+package xmlreader_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/hovercross/fmpxml-to-json/pkg/xmlreader"
+)
+
+func Test_Sanitize(t *testing.T) {
+	input := "A\x00B&#11;C&#x0c;D\vE&amp;F&#65;G&#xD800;"
+
+	tests := []struct {
+		opts    xmlreader.SanitizeOptions
+		want    string
+		repairs int
+	}{
+		{xmlreader.SanitizeOptions{Mode: xmlreader.SanitizeReplace}, "A�B�C�D�E&amp;F&#65;G�", 5},
+		{xmlreader.SanitizeOptions{Mode: xmlreader.SanitizeDrop}, "ABCDE&amp;F&#65;G", 5},
+		{xmlreader.SanitizeOptions{Mode: xmlreader.SanitizeDrop, VerticalTabAsNewline: true}, "AB\nCD\nE&amp;F&#65;G", 5},
+	}
+
+	for _, tt := range tests {
+		sanitizer := xmlreader.NewSanitizer(strings.NewReader(input), tt.opts)
+
+		got, err := ioutil.ReadAll(sanitizer)
+
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if string(got) != tt.want {
+			t.Errorf("Got %q, want %q", got, tt.want)
+		}
+
+		if sanitizer.Repairs() != tt.repairs {
+			t.Errorf("Got %d repairs, want %d", sanitizer.Repairs(), tt.repairs)
+		}
+	}
+}
+
+func Test_SanitizeReadSize(t *testing.T) {
+	input := strings.Repeat("A\x00B&#11;", 1000)
+	sanitizer := xmlreader.NewSanitizer(strings.NewReader(input), xmlreader.SanitizeOptions{Mode: xmlreader.SanitizeDrop})
+
+	// A read fills the whole buffer when the input is there, rather than returning a character at a time
+	p := make([]byte, 500)
+	n, err := sanitizer.Read(p)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n != len(p) || string(p[:4]) != "ABAB" {
+		t.Errorf("Read %d bytes, starting %q", n, p[:4])
+	}
+}
+
+func Test_SanitizedParse(t *testing.T) {
+	sampleData := []byte(`<?xml version="1.0" encoding="UTF-8" ?>
+	<FMPXMLRESULT xmlns="http://www.filemaker.com/fmpxmlresult">
+		<ERRORCODE>0</ERRORCODE>
+		<PRODUCT BUILD="06-07-2018" NAME="FileMaker" VERSION="Server 17.0.2"/>
+		<DATABASE DATEFORMAT="M/d/yyyy" LAYOUT="Overview" NAME="test.fmp12" RECORDS="1" TIMEFORMAT="h:mm:ss a"/>
+		<METADATA>
+			<FIELD EMPTYOK="YES" MAXREPEAT="1" NAME="Notes" TYPE="TEXT"/>
+		</METADATA>
+		<RESULTSET FOUND="1">
+			<ROW MODID="196" RECORDID="683">
+				<COL>
+					<DATA>Line one&#11;Line two` + "\x0c" + `</DATA>
+				</COL>
+			</ROW>
+		</RESULTSET>
+	</FMPXMLRESULT>`)
+
+	sanitizer := xmlreader.NewSanitizer(bytes.NewBuffer(sampleData), xmlreader.SanitizeOptions{
+		Mode:                 xmlreader.SanitizeDrop,
+		VerticalTabAsNewline: true,
+	})
+
+	parsed, err := xmlreader.ReadXML(sanitizer)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if got := parsed.ResultSet.Rows[0].Cols[0].Data[0]; got != "Line one\nLine two" {
+		t.Errorf("Got %q", got)
+	}
+
+	if sanitizer.Repairs() != 2 {
+		t.Errorf("Got %d repairs, want 2", sanitizer.Repairs())
+	}
+}